@@ -4,6 +4,9 @@ import (
 	"encoding/json"
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	"github.com/iokinai/lcltgbot/internal/lcltgbot/app"
+	"github.com/iokinai/lcltgbot/internal/lcltgbot/filter"
+	"github.com/iokinai/lcltgbot/internal/lcltgbot/formatters"
+	"github.com/iokinai/lcltgbot/internal/lcltgbot/geo"
 	"github.com/iokinai/lcltgbot/internal/lcltgbot/handlers"
 	"github.com/iokinai/lcltgbot/internal/lcltgbot/models"
 	"github.com/iokinai/lcltgbot/pkg/lcltgbot"
@@ -16,6 +19,26 @@ func main() {
 	settings := GetSettings()
 	textsettings := GetText()
 
+	renderer, err := formatters.LoadRenderer("assets/templates", settings.ParseMode, settings.Locale, settings.Location(), textsettings)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	renderer.Debug = handlers.DEBUG
+
+	cities, err := geo.LoadGazetteer()
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	filters, err := filter.LoadDir("assets/filters")
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	db := lcltgbot.NewSqliteDb(settings)
 
 	api, err := tgbotapi.NewBotAPI(settings.Key)
@@ -24,7 +47,11 @@ func main() {
 		log.Fatal(err)
 	}
 
-	handl := handlers.NewHandlers(api, db, settings, textsettings)
+	sender := app.NewSender(api, settings.OutgoingRate())
+
+	go sender.Run()
+
+	handl := handlers.NewHandlers(sender, sender.Bulk(), api.Self, db, settings, textsettings, renderer, cities, filters)
 
 	application := app.New(api, handl)
 
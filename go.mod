@@ -7,4 +7,4 @@ require (
 	github.com/mattn/go-sqlite3 v1.14.17
 )
 
-require github.com/dustin/go-humanize v1.0.1 // indirect
+require github.com/dustin/go-humanize v1.0.1
@@ -4,21 +4,35 @@ import (
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	"github.com/iokinai/lcltgbot/internal/lcltgbot/models"
 	"log"
+	"sync/atomic"
+	"time"
 )
 
 type Handlers interface {
 	HandleSingleCommand(user *models.User, message *tgbotapi.Message) error
 	HandleCommandFlow(user *models.User, message *tgbotapi.Message) error
-	HandleMessage(message *tgbotapi.Message) error
-	HandleCallbackQuery(query *tgbotapi.CallbackQuery) error
+	HandleMessage(message *tgbotapi.Message, threadid int64) error
+	HandleCallbackQuery(query *tgbotapi.CallbackQuery, threadid int64) error
+	HandleInlineQuery(query *tgbotapi.InlineQuery) error
+	PublishDueAds(now time.Time) error
+	ProcessExpiringAds(now time.Time) error
+	DeliverBroadcasts(now time.Time) error
+	PurgeCallbackPayloads(now time.Time) error
+}
+
+type Messenger interface {
+	StopReceivingUpdates()
+	Send(c tgbotapi.Chattable) (tgbotapi.Message, error)
+	Request(c tgbotapi.Chattable) (*tgbotapi.APIResponse, error)
 }
 
 type App struct {
-	botapi   *tgbotapi.BotAPI
+	botapi   Messenger
 	handlers Handlers
+	stopped  atomic.Bool
 }
 
-func New(botapi *tgbotapi.BotAPI, handlers Handlers) *App {
+func New(botapi Messenger, handlers Handlers) *App {
 	return &App{
 		botapi:   botapi,
 		handlers: handlers,
@@ -26,24 +40,42 @@ func New(botapi *tgbotapi.BotAPI, handlers Handlers) *App {
 }
 
 func (a *App) Start() {
-	u := tgbotapi.NewUpdate(0)
-	u.Timeout = 60
-
-	updates := a.botapi.GetUpdatesChan(u)
+	go a.Every(time.Minute, a.handlers.PublishDueAds)
+	go a.Every(10*time.Minute, a.handlers.ProcessExpiringAds)
+	go a.Every(10*time.Second, a.handlers.DeliverBroadcasts)
+	go a.Every(time.Hour, a.handlers.PurgeCallbackPayloads)
 
-	for update := range updates {
-		a.HandleUpdate(update)
-	}
+	a.Poll()
 }
 
+// HandleUpdate handles an update outside of any forum topic.
 func (a *App) HandleUpdate(update tgbotapi.Update) {
+	a.Dispatch(Update{Update: update})
+}
+
+func (a *App) Dispatch(update Update) {
 	if update.Message != nil {
-		if err := a.handlers.HandleMessage(update.Message); err != nil {
-			log.Fatal(err)
+		if err := a.handlers.HandleMessage(update.Message, update.ThreadId); err != nil {
+			log.Println(err)
 		}
 	} else if update.CallbackQuery != nil {
-		if err := a.handlers.HandleCallbackQuery(update.CallbackQuery); err != nil {
+		if err := a.handlers.HandleCallbackQuery(update.CallbackQuery, update.ThreadId); err != nil {
 			return
 		}
+	} else if update.InlineQuery != nil {
+		if err := a.handlers.HandleInlineQuery(update.InlineQuery); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+func (a *App) Every(interval time.Duration, job func(now time.Time) error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for now := time.Now(); ; now = <-ticker.C {
+		if err := job(now); err != nil {
+			log.Println(err)
+		}
 	}
 }
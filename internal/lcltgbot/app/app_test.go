@@ -0,0 +1,223 @@
+package app
+
+import (
+	"encoding/json"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/iokinai/lcltgbot/internal/lcltgbot/bottest"
+	"github.com/iokinai/lcltgbot/internal/lcltgbot/commands"
+	"github.com/iokinai/lcltgbot/internal/lcltgbot/filter"
+	"github.com/iokinai/lcltgbot/internal/lcltgbot/formatters"
+	"github.com/iokinai/lcltgbot/internal/lcltgbot/geo"
+	"github.com/iokinai/lcltgbot/internal/lcltgbot/handlers"
+	"github.com/iokinai/lcltgbot/internal/lcltgbot/models"
+	"github.com/iokinai/lcltgbot/pkg/lcltgbot"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testChannel = "@channel"
+	testKey     = "secret"
+	testGroup   = -100
+)
+
+func newTestApp(t *testing.T) (*App, *bottest.Messenger, *models.TextSettings) {
+	t.Helper()
+
+	settings := &models.AppSettings{
+		SecretKey:         testKey,
+		ManageChannelLink: testChannel,
+		DatabasePath:      filepath.Join(t.TempDir(), "bot.sqlite"),
+		Admins:            []int64{1},
+		GroupsEnabled:     true,
+		MessagesPerMinute: 600,
+		MessageBurst:      100,
+	}
+
+	data, err := os.ReadFile("../../../assets/translations/ru.json")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var text models.TextSettings
+
+	if err := json.Unmarshal(data, &text); err != nil {
+		t.Fatal(err)
+	}
+
+	renderer, err := formatters.LoadRenderer("../../../assets/templates", "", "", time.UTC, &text)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cities, err := geo.LoadGazetteer()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	filters, err := filter.LoadDir("../../../assets/filters")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	messenger := bottest.NewMessenger()
+	me := tgbotapi.User{ID: 999, UserName: "testbot", IsBot: true}
+	h := handlers.NewHandlers(messenger, messenger, me, lcltgbot.NewSqliteDb(settings), settings, &text, renderer, cities, filters)
+
+	return New(messenger, h), messenger, &text
+}
+
+// buttonData finds the callback data of the latest button with the label.
+func buttonData(t *testing.T, messages []tgbotapi.MessageConfig, label string) string {
+	t.Helper()
+
+	for i := len(messages) - 1; i >= 0; i-- {
+		markup, ok := bottest.InlineKeyboard(messages[i])
+
+		if !ok {
+			continue
+		}
+
+		for _, row := range markup.InlineKeyboard {
+			for _, button := range row {
+				if button.Text == label && button.CallbackData != nil {
+					return *button.CallbackData
+				}
+			}
+		}
+	}
+
+	t.Fatalf("no %q button", label)
+
+	return ""
+}
+
+func lastText(t *testing.T, messages []tgbotapi.MessageConfig) string {
+	t.Helper()
+
+	if len(messages) == 0 {
+		t.Fatal("no messages")
+	}
+
+	return messages[len(messages)-1].Text
+}
+
+// amount is the price as the posts show it, without the currency symbol.
+func amount(price float64) string {
+	return strings.TrimSuffix(formatters.FormatMoney(price, "RUB", formatters.DefaultLocale), " "+formatters.CurrencySymbol("RUB"))
+}
+
+func TestAddPreviewEditSend(t *testing.T) {
+	a, m, text := newTestApp(t)
+	seller := bottest.NewUser(1, "seller")
+
+	for _, input := range []string{testKey, "/add_ad", "Велосипед", "Горный, 21 скорость", "1500", "Москва"} {
+		a.HandleUpdate(bottest.NewTextUpdate(1, seller, input))
+	}
+
+	messages := m.MessagesTo(1)
+
+	if got := lastText(t, messages); got != text.AdPreview {
+		t.Fatalf("last message %q, want the preview notice", got)
+	}
+
+	preview := messages[len(messages)-2].Text
+
+	if !strings.Contains(preview, "Велосипед") || !strings.Contains(preview, amount(1500)) {
+		t.Fatalf("preview %q lacks the title or the price", preview)
+	}
+
+	staleSend := buttonData(t, messages, commands.SendButtonPair.ParamName)
+
+	a.HandleUpdate(bottest.NewCallbackUpdate(1, seller, 10, buttonData(t, messages, commands.ChangePriceButton.ParamName)))
+	a.HandleUpdate(bottest.NewTextUpdate(1, seller, "2000"))
+
+	messages = m.MessagesTo(1)
+	send := buttonData(t, messages, commands.SendButtonPair.ParamName)
+
+	if send == staleSend {
+		t.Fatal("the send button was not renewed after the edit")
+	}
+
+	a.HandleUpdate(bottest.NewCallbackUpdate(1, seller, 11, staleSend))
+
+	if posts := m.ChannelPosts(testChannel); len(posts) != 0 {
+		t.Fatalf("stale preview published %d posts", len(posts))
+	}
+
+	a.HandleUpdate(bottest.NewCallbackUpdate(1, seller, 12, send))
+	a.HandleUpdate(bottest.NewCallbackUpdate(1, seller, 12, send))
+
+	posts := m.ChannelPosts(testChannel)
+
+	if len(posts) != 1 {
+		t.Fatalf("got %d channel posts, want 1", len(posts))
+	}
+
+	if !strings.Contains(posts[0].Text, amount(2000)) || strings.Contains(posts[0].Text, amount(1500)) {
+		t.Errorf("channel post %q does not carry the edited price", posts[0].Text)
+	}
+
+	if got := lastText(t, m.MessagesTo(1)); got != text.AdPublished {
+		t.Errorf("last message %q, want the published notice", got)
+	}
+}
+
+func TestPollForumTopic(t *testing.T) {
+	a, m, text := newTestApp(t)
+	seller := bottest.NewUser(1, "seller")
+
+	allow := bottest.NewTextUpdate(testGroup, seller, "/allow_group@testbot")
+	allow.Message.Chat.Type = "supergroup"
+
+	m.Feed(bottest.NewTextUpdate(1, seller, testKey), allow)
+	m.FeedJSON(
+		`{"update_id": 3, "message": {"message_id": 50, "message_thread_id": 5, "is_topic_message": true, "from": {"id": 1, "first_name": "seller"}, "chat": {"id": -100, "type": "supergroup", "is_forum": true}, "text": "/add_ad", "entities": [{"type": "bot_command", "offset": 0, "length": 7}]}}`,
+		`{"update_id": 4, "message": {"message_id": 51, "message_thread_id": 5, "is_topic_message": true, "from": {"id": 1, "first_name": "seller"}, "chat": {"id": -100, "type": "supergroup", "is_forum": true}, "text": "🚲 @testbot Велосипед", "entities": [{"type": "mention", "offset": 3, "length": 8}]}}`,
+		`{"update_id": 5, "message": {"message_id": 52, "from": {"id": 1, "first_name": "seller"}, "chat": {"id": -100, "type": "supergroup", "is_forum": true}, "text": "@testbot Лампа", "entities": [{"type": "mention", "offset": 0, "length": 8}]}}`,
+	)
+
+	a.Stop()
+	a.Poll()
+
+	messages := m.MessagesTo(testGroup)
+
+	if len(messages) != 4 {
+		t.Fatalf("got %d group messages, want 4", len(messages))
+	}
+
+	for i, want := range []struct {
+		replyTo int
+		text    string
+	}{
+		{50, text.AdGuide},
+		{51, text.EnterDescription},
+		{0, text.WrongCommand},
+	} {
+		message := messages[i+1]
+
+		if message.ReplyToMessageID != want.replyTo || message.Text != want.text {
+			t.Errorf("message %d: reply to %d with %q, want reply to %d with %q", i, message.ReplyToMessageID, message.Text, want.replyTo, want.text)
+		}
+	}
+}
+
+func TestPostLinksWithoutKey(t *testing.T) {
+	a, m, text := newTestApp(t)
+
+	for i, payload := range []string{"ad_42", "rep_42"} {
+		reader := bottest.NewUser(int64(10+i), "reader")
+		a.HandleUpdate(bottest.NewTextUpdate(int64(10+i), reader, "/start "+payload))
+
+		if got := lastText(t, m.MessagesTo(int64(10+i))); got != text.AdNotFound {
+			t.Errorf("%s: got %q, want the ad lookup answer", payload, got)
+		}
+	}
+}
@@ -0,0 +1,438 @@
+package app
+
+import (
+	"errors"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/iokinai/lcltgbot/internal/lcltgbot/models"
+	"github.com/iokinai/lcltgbot/internal/lcltgbot/ratelimit"
+	"hash/fnv"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Priority orders the queue: interactive replies are sent before bulk
+// messages such as broadcasts.
+type Priority int
+
+const (
+	PriorityInteractive Priority = iota
+	PriorityBulk
+)
+
+// Telegram lets a bot send about one message per second to a chat and twenty
+// per minute to a group, with short bursts tolerated.
+const (
+	ChatRate    = 1.0
+	GroupRate   = 20.0 / 60
+	ChatBurst   = 3
+	MaxInFlight = 8
+)
+
+const (
+	MaxSendAttempts = 5
+	BackoffBase     = 500 * time.Millisecond
+	BackoffMax      = 30 * time.Second
+	SendTimeout     = 15 * time.Second
+)
+
+// ErrSendTimeout is returned to an interactive caller whose request could not
+// go out within SendTimeout. The request is dropped, not sent later.
+var ErrSendTimeout = errors.New("telegram request waited too long in the queue")
+
+type Transport interface {
+	Send(c tgbotapi.Chattable) (tgbotapi.Message, error)
+	Request(c tgbotapi.Chattable) (*tgbotapi.APIResponse, error)
+}
+
+type result struct {
+	message  tgbotapi.Message
+	response *tgbotapi.APIResponse
+	err      error
+}
+
+type job struct {
+	chattable tgbotapi.Chattable
+	send      bool
+	chat      int64
+	priority  Priority
+	attempts  int
+	done      chan result
+
+	// after holds back a retry of a request outside any chat, deadline is
+	// when an interactive caller stops waiting.
+	after    time.Time
+	deadline time.Time
+}
+
+// Sender is the only way the bot talks to Telegram. Callers block until
+// their request is made, interactive ones for at most SendTimeout, while the
+// sender keeps within the global and per-chat limits, keeps the order of
+// messages within a chat and retries flood waits and network failures.
+type Sender struct {
+	transport Transport
+
+	mu       sync.Mutex
+	queues   [PriorityBulk + 1][]*job
+	busy     map[int64]bool
+	paused   map[int64]time.Time
+	inFlight int
+	sent     int64
+	retried  int64
+	failed   int64
+
+	global *ratelimit.Limiter
+	chats  *ratelimit.Limiter
+	groups *ratelimit.Limiter
+	wake   chan struct{}
+}
+
+func NewSender(transport Transport, rate float64) *Sender {
+	burst := int(rate)
+
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &Sender{
+		transport: transport,
+		busy:      map[int64]bool{},
+		paused:    map[int64]time.Time{},
+		global:    ratelimit.NewLimiter(rate, burst),
+		chats:     ratelimit.NewLimiter(ChatRate, ChatBurst),
+		groups:    ratelimit.NewLimiter(GroupRate, ChatBurst),
+		wake:      make(chan struct{}, 1),
+	}
+}
+
+func (s *Sender) Send(c tgbotapi.Chattable) (tgbotapi.Message, error) {
+	r := s.enqueue(c, true, PriorityInteractive, SendTimeout)
+	return r.message, r.err
+}
+
+func (s *Sender) Request(c tgbotapi.Chattable) (*tgbotapi.APIResponse, error) {
+	r := s.enqueue(c, false, PriorityInteractive, SendTimeout)
+	return r.response, r.err
+}
+
+// Bulk is a client of the same queue whose requests wait for the
+// interactive ones.
+func (s *Sender) Bulk() *Client {
+	return &Client{sender: s, priority: PriorityBulk}
+}
+
+type Client struct {
+	sender   *Sender
+	priority Priority
+}
+
+func (c *Client) Send(chattable tgbotapi.Chattable) (tgbotapi.Message, error) {
+	r := c.sender.enqueue(chattable, true, c.priority, 0)
+	return r.message, r.err
+}
+
+func (c *Client) Request(chattable tgbotapi.Chattable) (*tgbotapi.APIResponse, error) {
+	r := c.sender.enqueue(chattable, false, c.priority, 0)
+	return r.response, r.err
+}
+
+func (s *Sender) Metrics() models.QueueMetrics {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return models.QueueMetrics{
+		Interactive: len(s.queues[PriorityInteractive]),
+		Bulk:        len(s.queues[PriorityBulk]),
+		InFlight:    s.inFlight,
+		Sent:        s.sent,
+		Retried:     s.retried,
+		Failed:      s.failed,
+	}
+}
+
+// enqueue waits for the result of the request. With a timeout the request is
+// taken back out of the queue when it hasn't gone out in time, one already
+// on its way is still waited for.
+func (s *Sender) enqueue(c tgbotapi.Chattable, send bool, priority Priority, timeout time.Duration) result {
+	j := &job{chattable: c, send: send, chat: ChatKey(c), priority: priority, done: make(chan result, 1)}
+
+	if timeout > 0 {
+		j.deadline = time.Now().Add(timeout)
+	}
+
+	s.mu.Lock()
+	s.queues[priority] = append(s.queues[priority], j)
+	s.mu.Unlock()
+
+	s.signal()
+
+	if timeout <= 0 {
+		return <-j.done
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case r := <-j.done:
+		return r
+	case <-timer.C:
+	}
+
+	if s.remove(j) {
+		return result{err: ErrSendTimeout}
+	}
+
+	return <-j.done
+}
+
+// remove takes a request that is still queued out of the queue.
+func (s *Sender) remove(j *job) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	queue := s.queues[j.priority]
+
+	for i := range queue {
+		if queue[i] == j {
+			s.queues[j.priority] = append(queue[:i], queue[i+1:]...)
+			s.failed++
+
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *Sender) signal() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run dispatches queued requests until the process exits.
+func (s *Sender) Run() {
+	for {
+		j, wait := s.next(time.Now())
+
+		if j != nil {
+			go s.execute(j)
+			continue
+		}
+
+		if wait <= 0 {
+			<-s.wake
+			continue
+		}
+
+		timer := time.NewTimer(wait)
+
+		select {
+		case <-s.wake:
+		case <-timer.C:
+		}
+
+		timer.Stop()
+	}
+}
+
+// next takes the first request that may go now. A request waits while an
+// earlier one to the same chat is queued or in flight, so messages never
+// overtake each other. Without a request to send it returns how long to
+// wait, zero meaning until something changes.
+func (s *Sender) next(now time.Time) (*job, time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.inFlight >= MaxInFlight || len(s.queues[PriorityInteractive])+len(s.queues[PriorityBulk]) == 0 {
+		return nil, 0
+	}
+
+	if ok, retry, _ := s.global.Allow(0, now); !ok {
+		return nil, retry
+	}
+
+	var wait time.Duration
+
+	for priority := range s.queues {
+		seen := map[int64]bool{}
+
+		for i, j := range s.queues[priority] {
+			if now.Before(j.after) {
+				wait = shorter(wait, j.after.Sub(now))
+				continue
+			}
+
+			if j.chat != 0 {
+				if seen[j.chat] || s.busy[j.chat] {
+					seen[j.chat] = true
+					continue
+				}
+
+				seen[j.chat] = true
+
+				if until := s.paused[j.chat]; now.Before(until) {
+					wait = shorter(wait, until.Sub(now))
+					continue
+				}
+
+				if ok, retry := s.allowChat(j.chat, now); !ok {
+					wait = shorter(wait, retry)
+					continue
+				}
+
+				delete(s.paused, j.chat)
+				s.busy[j.chat] = true
+			}
+
+			s.queues[priority] = append(s.queues[priority][:i], s.queues[priority][i+1:]...)
+			s.inFlight++
+
+			return j, 0
+		}
+	}
+
+	s.global.Refund(0)
+
+	return nil, wait
+}
+
+func (s *Sender) allowChat(chat int64, now time.Time) (bool, time.Duration) {
+	limiter := s.chats
+
+	if chat < 0 {
+		limiter = s.groups
+	}
+
+	ok, retry, _ := limiter.Allow(chat, now)
+
+	return ok, retry
+}
+
+func (s *Sender) execute(j *job) {
+	var r result
+
+	if j.send {
+		r.message, r.err = s.transport.Send(j.chattable)
+	} else {
+		r.response, r.err = s.transport.Request(j.chattable)
+	}
+
+	retry, delay := RetryDelay(r.err, j.attempts)
+	now := time.Now()
+
+	if retry && !j.deadline.IsZero() && now.Add(delay).After(j.deadline) {
+		retry = false
+	}
+
+	s.mu.Lock()
+
+	delete(s.busy, j.chat)
+	s.inFlight--
+
+	switch {
+	case retry:
+		j.attempts++
+		s.retried++
+
+		if j.chat != 0 {
+			s.paused[j.chat] = now.Add(delay)
+		} else {
+			j.after = now.Add(delay)
+		}
+
+		s.queues[j.priority] = append([]*job{j}, s.queues[j.priority]...)
+	case r.err != nil:
+		s.failed++
+	default:
+		s.sent++
+	}
+
+	s.mu.Unlock()
+
+	s.signal()
+
+	if !retry {
+		j.done <- r
+	}
+}
+
+// RetryDelay decides whether a failed request is worth repeating: flood waits
+// after the time Telegram asks for, server and network failures with an
+// exponential backoff. Other errors are the caller's to handle.
+func RetryDelay(err error, attempts int) (bool, time.Duration) {
+	if err == nil || attempts+1 >= MaxSendAttempts {
+		return false, 0
+	}
+
+	var apiErr *tgbotapi.Error
+
+	if errors.As(err, &apiErr) {
+		if apiErr.RetryAfter > 0 {
+			return true, time.Duration(apiErr.RetryAfter) * time.Second
+		}
+
+		return apiErr.Code >= http.StatusInternalServerError, Backoff(attempts)
+	}
+
+	var netErr net.Error
+
+	return errors.As(err, &netErr), Backoff(attempts)
+}
+
+func Backoff(attempts int) time.Duration {
+	delay := BackoffBase << attempts
+
+	if delay <= 0 || delay > BackoffMax {
+		return BackoffMax
+	}
+
+	return delay
+}
+
+// ChatKey is the chat a request is limited by. Channels addressed by their
+// username get a key of their own, requests outside any chat, like answers
+// to button presses, get zero and are only limited globally.
+func ChatKey(c tgbotapi.Chattable) int64 {
+	var (
+		chatid   int64
+		username string
+	)
+
+	switch config := c.(type) {
+	case tgbotapi.MessageConfig:
+		chatid, username = config.ChatID, config.ChannelUsername
+	case tgbotapi.PhotoConfig:
+		chatid, username = config.ChatID, config.ChannelUsername
+	case tgbotapi.CopyMessageConfig:
+		chatid, username = config.ChatID, config.ChannelUsername
+	case tgbotapi.EditMessageTextConfig:
+		chatid, username = config.ChatID, config.ChannelUsername
+	case tgbotapi.EditMessageReplyMarkupConfig:
+		chatid, username = config.ChatID, config.ChannelUsername
+	case tgbotapi.EditMessageCaptionConfig:
+		chatid, username = config.ChatID, config.ChannelUsername
+	case tgbotapi.DeleteMessageConfig:
+		chatid, username = config.ChatID, config.ChannelUsername
+	}
+
+	if chatid != 0 || username == "" {
+		return chatid
+	}
+
+	hash := fnv.New32a()
+	hash.Write([]byte(username))
+
+	return -int64(hash.Sum32()) - 1
+}
+
+func shorter(a time.Duration, b time.Duration) time.Duration {
+	if a == 0 || b < a {
+		return b
+	}
+
+	return a
+}
@@ -0,0 +1,172 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"net"
+	"testing"
+	"time"
+)
+
+// flakyTransport fails the first sends with the queued errors and succeeds
+// after that.
+type flakyTransport struct {
+	errs []error
+	sent []tgbotapi.Chattable
+}
+
+func (f *flakyTransport) Send(c tgbotapi.Chattable) (tgbotapi.Message, error) {
+	if len(f.errs) > 0 {
+		err := f.errs[0]
+		f.errs = f.errs[1:]
+
+		return tgbotapi.Message{}, err
+	}
+
+	f.sent = append(f.sent, c)
+
+	return tgbotapi.Message{MessageID: len(f.sent)}, nil
+}
+
+func (f *flakyTransport) Request(c tgbotapi.Chattable) (*tgbotapi.APIResponse, error) {
+	_, err := f.Send(c)
+	return &tgbotapi.APIResponse{Ok: err == nil}, err
+}
+
+func newJob(chat int64, text string, priority Priority) *job {
+	return &job{chattable: tgbotapi.NewMessage(chat, text), send: true, chat: chat, priority: priority, done: make(chan result, 1)}
+}
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		attempts int
+		retry    bool
+		delay    time.Duration
+	}{
+		{"sent", nil, 0, false, 0},
+		{"flood wait", &tgbotapi.Error{Code: 429, ResponseParameters: tgbotapi.ResponseParameters{RetryAfter: 7}}, 0, true, 7 * time.Second},
+		{"server error", &tgbotapi.Error{Code: 502}, 2, true, 2 * time.Second},
+		{"bad request", &tgbotapi.Error{Code: 400, Message: "Bad Request: chat not found"}, 0, false, 0},
+		{"network", fmt.Errorf("send: %w", &net.OpError{Op: "dial", Err: errors.New("connection refused")}), 1, true, time.Second},
+		{"other", errors.New("template failed"), 0, false, 0},
+		{"out of attempts", &tgbotapi.Error{Code: 502}, MaxSendAttempts - 1, false, 0},
+	}
+
+	for _, test := range tests {
+		retry, delay := RetryDelay(test.err, test.attempts)
+
+		if retry != test.retry || (retry && delay != test.delay) {
+			t.Errorf("%s: got %v %v, want %v %v", test.name, retry, delay, test.retry, test.delay)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{0, BackoffBase},
+		{3, 8 * BackoffBase},
+		{6, BackoffMax},
+		{70, BackoffMax},
+	}
+
+	for _, test := range tests {
+		if got := Backoff(test.attempts); got != test.want {
+			t.Errorf("%d attempts: got %v, want %v", test.attempts, got, test.want)
+		}
+	}
+}
+
+func TestChatKey(t *testing.T) {
+	channel := ChatKey(tgbotapi.NewMessageToChannel("@channel", "post"))
+
+	tests := []struct {
+		name      string
+		chattable tgbotapi.Chattable
+		want      int64
+	}{
+		{"private", tgbotapi.NewMessage(5, "hi"), 5},
+		{"group", tgbotapi.NewMessage(-100, "hi"), -100},
+		{"no chat", tgbotapi.NewEditMessageTextAndMarkup(0, 0, "", tgbotapi.InlineKeyboardMarkup{}), 0},
+		{"same channel", tgbotapi.NewMessageToChannel("@channel", "another post"), channel},
+		{"callback answer", tgbotapi.NewCallback("1", ""), 0},
+	}
+
+	for _, test := range tests {
+		if got := ChatKey(test.chattable); got != test.want {
+			t.Errorf("%s: got %d, want %d", test.name, got, test.want)
+		}
+	}
+
+	if channel >= 0 {
+		t.Errorf("channel username: got key %d, want a negative one", channel)
+	}
+}
+
+func TestNextKeepsChatOrder(t *testing.T) {
+	s := NewSender(&flakyTransport{}, 100)
+	now := time.Now()
+
+	first, second, other := newJob(1, "first", PriorityBulk), newJob(1, "second", PriorityBulk), newJob(2, "other", PriorityBulk)
+	reply := newJob(3, "reply", PriorityInteractive)
+	s.queues[PriorityBulk] = []*job{first, second, other}
+	s.queues[PriorityInteractive] = []*job{reply}
+
+	tests := []struct {
+		name string
+		want *job
+	}{
+		{"interactive first", reply},
+		{"oldest of the chat", first},
+		{"chat busy, next chat", other},
+		{"chat still busy", nil},
+	}
+
+	for _, test := range tests {
+		if got, _ := s.next(now); got != test.want {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+
+	delete(s.busy, 1)
+	s.inFlight--
+
+	if got, _ := s.next(now); got != second {
+		t.Errorf("after the first is sent: got %v, want the second message", got)
+	}
+}
+
+func TestExecuteRetriesFloodWait(t *testing.T) {
+	transport := &flakyTransport{errs: []error{&tgbotapi.Error{Code: 429, ResponseParameters: tgbotapi.ResponseParameters{RetryAfter: 1}}}}
+	s := NewSender(transport, 100)
+	j := newJob(1, "hi", PriorityInteractive)
+	s.queues[PriorityInteractive] = []*job{j}
+
+	got, _ := s.next(time.Now())
+	s.execute(got)
+
+	if len(transport.sent) != 0 || len(j.done) != 0 {
+		t.Fatal("the flood wait was returned to the caller")
+	}
+
+	if got, wait := s.next(time.Now()); got != nil || wait <= 0 {
+		t.Fatalf("got %v after %v, want the chat paused", got, wait)
+	}
+
+	got, _ = s.next(time.Now().Add(2 * time.Second))
+
+	if got != j {
+		t.Fatal("the request was not retried after the wait")
+	}
+
+	s.execute(got)
+
+	if r := <-j.done; r.err != nil || len(transport.sent) != 1 || j.attempts != 1 {
+		t.Errorf("got %v after %d attempts and %d sends, want one retried send", r.err, j.attempts, len(transport.sent))
+	}
+}
@@ -0,0 +1,109 @@
+package app
+
+import (
+	"encoding/json"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"log"
+	"time"
+)
+
+// PollRetryDelay is the pause after a failed request for updates.
+const PollRetryDelay = 3 * time.Second
+
+// Update is a tgbotapi.Update together with the forum topic its message was
+// posted in. telegram-bot-api v5.5.1 has no field for message_thread_id, so
+// it is decoded from the raw update.
+type Update struct {
+	tgbotapi.Update
+	ThreadId int64
+}
+
+type topicMessage struct {
+	ThreadId int64 `json:"message_thread_id"`
+	IsTopic  bool  `json:"is_topic_message"`
+}
+
+// Topic is the forum topic of the message. Outside forums Telegram sets
+// message_thread_id on replies too, those threads are not topics.
+func (m *topicMessage) Topic() int64 {
+	if m == nil || !m.IsTopic {
+		return 0
+	}
+
+	return m.ThreadId
+}
+
+func (u *Update) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &u.Update); err != nil {
+		return err
+	}
+
+	var raw struct {
+		Message       *topicMessage `json:"message"`
+		CallbackQuery *struct {
+			Message *topicMessage `json:"message"`
+		} `json:"callback_query"`
+	}
+
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	u.ThreadId = raw.Message.Topic()
+
+	if raw.CallbackQuery != nil {
+		u.ThreadId = raw.CallbackQuery.Message.Topic()
+	}
+
+	return nil
+}
+
+func (a *App) GetUpdates(config tgbotapi.UpdateConfig) ([]Update, error) {
+	response, err := a.botapi.Request(config)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var updates []Update
+
+	if err := json.Unmarshal(response.Result, &updates); err != nil {
+		return nil, err
+	}
+
+	return updates, nil
+}
+
+// Poll handles updates one by one as Telegram hands them out, until Stop is
+// called and no more updates are waiting.
+func (a *App) Poll() {
+	config := tgbotapi.NewUpdate(0)
+	config.Timeout = 60
+
+	for {
+		updates, err := a.GetUpdates(config)
+
+		if err != nil {
+			log.Println(err)
+			time.Sleep(PollRetryDelay)
+			continue
+		}
+
+		if len(updates) == 0 && a.stopped.Load() {
+			return
+		}
+
+		for _, update := range updates {
+			if update.UpdateID >= config.Offset {
+				config.Offset = update.UpdateID + 1
+			}
+
+			a.Dispatch(update)
+		}
+	}
+}
+
+func (a *App) Stop() {
+	a.stopped.Store(true)
+	a.botapi.StopReceivingUpdates()
+}
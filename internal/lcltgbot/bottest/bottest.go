@@ -0,0 +1,230 @@
+package bottest
+
+import (
+	"encoding/json"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"strings"
+	"sync"
+)
+
+type Messenger struct {
+	mu            sync.Mutex
+	sent          []tgbotapi.Chattable
+	requests      []tgbotapi.Chattable
+	updates       chan json.RawMessage
+	nextMessageId int
+	SendErr       error
+	RequestErr    error
+	ChatErrs      map[int64]error
+}
+
+func NewMessenger() *Messenger {
+	return &Messenger{updates: make(chan json.RawMessage, 100)}
+}
+
+func (m *Messenger) StopReceivingUpdates() {
+	close(m.updates)
+}
+
+func (m *Messenger) Feed(updates ...tgbotapi.Update) {
+	for _, update := range updates {
+		raw, err := json.Marshal(update)
+
+		if err != nil {
+			panic(err)
+		}
+
+		m.updates <- raw
+	}
+}
+
+// FeedJSON queues updates as Telegram would send them, for fields
+// tgbotapi.Update has no room for.
+func (m *Messenger) FeedJSON(updates ...string) {
+	for _, update := range updates {
+		m.updates <- json.RawMessage(update)
+	}
+}
+
+// GetUpdates waits for at least one fed update and hands out everything
+// queued so far. It returns nothing once StopReceivingUpdates is called.
+func (m *Messenger) GetUpdates() json.RawMessage {
+	update, ok := <-m.updates
+
+	if !ok {
+		return json.RawMessage("[]")
+	}
+
+	updates := []json.RawMessage{update}
+
+	for len(m.updates) > 0 {
+		updates = append(updates, <-m.updates)
+	}
+
+	raw, _ := json.Marshal(updates)
+
+	return raw
+}
+
+func (m *Messenger) Send(c tgbotapi.Chattable) (tgbotapi.Message, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.SendErr != nil {
+		return tgbotapi.Message{}, m.SendErr
+	}
+
+	m.sent = append(m.sent, c)
+	m.nextMessageId++
+
+	chatid, channel := ChatOf(c)
+
+	return tgbotapi.Message{
+		MessageID: m.nextMessageId,
+		Chat:      &tgbotapi.Chat{ID: chatid, UserName: strings.TrimPrefix(channel, "@")},
+	}, nil
+}
+
+func (m *Messenger) Request(c tgbotapi.Chattable) (*tgbotapi.APIResponse, error) {
+	if _, ok := c.(tgbotapi.UpdateConfig); ok {
+		return &tgbotapi.APIResponse{Ok: true, Result: m.GetUpdates()}, nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.RequestErr != nil {
+		return nil, m.RequestErr
+	}
+
+	if chatid, _ := ChatOf(c); m.ChatErrs[chatid] != nil {
+		return nil, m.ChatErrs[chatid]
+	}
+
+	m.requests = append(m.requests, c)
+
+	return &tgbotapi.APIResponse{Ok: true, Result: json.RawMessage("true")}, nil
+}
+
+func (m *Messenger) Sent() []tgbotapi.Chattable {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]tgbotapi.Chattable(nil), m.sent...)
+}
+
+func (m *Messenger) Requests() []tgbotapi.Chattable {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]tgbotapi.Chattable(nil), m.requests...)
+}
+
+func (m *Messenger) Messages() []tgbotapi.MessageConfig {
+	var messages []tgbotapi.MessageConfig
+
+	for _, c := range m.Sent() {
+		if message, ok := c.(tgbotapi.MessageConfig); ok {
+			messages = append(messages, message)
+		}
+	}
+
+	return messages
+}
+
+func (m *Messenger) MessagesTo(chatid int64) []tgbotapi.MessageConfig {
+	var messages []tgbotapi.MessageConfig
+
+	for _, message := range m.Messages() {
+		if message.ChatID == chatid && message.ChannelUsername == "" {
+			messages = append(messages, message)
+		}
+	}
+
+	return messages
+}
+
+func (m *Messenger) ChannelPosts(channel string) []tgbotapi.MessageConfig {
+	var messages []tgbotapi.MessageConfig
+
+	for _, message := range m.Messages() {
+		if message.ChannelUsername == channel {
+			messages = append(messages, message)
+		}
+	}
+
+	return messages
+}
+
+func (m *Messenger) LastMessage() (tgbotapi.MessageConfig, bool) {
+	messages := m.Messages()
+
+	if len(messages) == 0 {
+		return tgbotapi.MessageConfig{}, false
+	}
+
+	return messages[len(messages)-1], true
+}
+
+func (m *Messenger) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sent = nil
+	m.requests = nil
+}
+
+func ChatOf(c tgbotapi.Chattable) (int64, string) {
+	switch config := c.(type) {
+	case tgbotapi.MessageConfig:
+		return config.ChatID, config.ChannelUsername
+	case tgbotapi.PhotoConfig:
+		return config.ChatID, config.ChannelUsername
+	case tgbotapi.EditMessageTextConfig:
+		return config.ChatID, config.ChannelUsername
+	case tgbotapi.EditMessageReplyMarkupConfig:
+		return config.ChatID, config.ChannelUsername
+	case tgbotapi.DeleteMessageConfig:
+		return config.ChatID, config.ChannelUsername
+	case tgbotapi.CopyMessageConfig:
+		return config.ChatID, config.ChannelUsername
+	}
+
+	return 0, ""
+}
+
+func InlineKeyboard(message tgbotapi.MessageConfig) (tgbotapi.InlineKeyboardMarkup, bool) {
+	markup, ok := message.ReplyMarkup.(tgbotapi.InlineKeyboardMarkup)
+	return markup, ok
+}
+
+func NewUser(userid int64, username string) *tgbotapi.User {
+	return &tgbotapi.User{ID: userid, UserName: username, FirstName: username}
+}
+
+func NewTextUpdate(chatid int64, from *tgbotapi.User, text string) tgbotapi.Update {
+	message := &tgbotapi.Message{
+		From: from,
+		Chat: &tgbotapi.Chat{ID: chatid, Type: "private"},
+		Text: text,
+	}
+
+	if strings.HasPrefix(text, "/") {
+		command := strings.SplitN(text, " ", 2)[0]
+		message.Entities = []tgbotapi.MessageEntity{{Type: "bot_command", Offset: 0, Length: len(command)}}
+	}
+
+	return tgbotapi.Update{Message: message}
+}
+
+func NewCallbackUpdate(chatid int64, from *tgbotapi.User, messageid int, data string) tgbotapi.Update {
+	return tgbotapi.Update{CallbackQuery: &tgbotapi.CallbackQuery{
+		ID:   data,
+		From: from,
+		Message: &tgbotapi.Message{
+			MessageID: messageid,
+			Chat:      &tgbotapi.Chat{ID: chatid, Type: "private"},
+		},
+		Data: data,
+	}}
+}
@@ -0,0 +1,183 @@
+package commands
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MaxCallbackData is Telegram's limit for the callback_data of a button.
+const MaxCallbackData = 64
+
+const (
+	argSeparator   = ":"
+	signSeparator  = "|"
+	storedPrefix   = "#"
+	signatureBytes = 6
+)
+
+var ErrBadCallback = errors.New("malformed callback data")
+
+// Payload is a decoded button press: the action and its arguments. The
+// accessors check the argument count, so a forged payload with too few
+// arguments is an error rather than a panic.
+type Payload struct {
+	Action string
+	Args   []string
+}
+
+func NewPayload(action string, args ...any) Payload {
+	payload := Payload{Action: action}
+
+	for _, arg := range args {
+		payload.Args = append(payload.Args, fmt.Sprint(arg))
+	}
+
+	return payload
+}
+
+func (p Payload) String(i int) (string, error) {
+	if i < 0 || i >= len(p.Args) {
+		return "", ErrBadCallback
+	}
+
+	return p.Args[i], nil
+}
+
+func (p Payload) Int(i int) (int64, error) {
+	arg, err := p.String(i)
+
+	if err != nil {
+		return 0, err
+	}
+
+	value, err := strconv.ParseInt(arg, 10, 64)
+
+	if err != nil {
+		return 0, ErrBadCallback
+	}
+
+	return value, nil
+}
+
+func (p Payload) Float(i int) (float64, error) {
+	arg, err := p.String(i)
+
+	if err != nil {
+		return 0, err
+	}
+
+	value, err := strconv.ParseFloat(arg, 64)
+
+	if err != nil {
+		return 0, ErrBadCallback
+	}
+
+	return value, nil
+}
+
+// Last is the final argument, used by buttons that append a tag after
+// their own arguments.
+func (p Payload) Last() string {
+	if len(p.Args) == 0 {
+		return ""
+	}
+
+	return p.Args[len(p.Args)-1]
+}
+
+func (p Payload) encode() string {
+	return strings.Join(append([]string{p.Action}, p.Args...), argSeparator)
+}
+
+func decodePayload(data string) (Payload, error) {
+	parts := strings.Split(data, argSeparator)
+
+	if parts[0] == "" {
+		return Payload{}, ErrBadCallback
+	}
+
+	return Payload{Action: parts[0], Args: parts[1:]}, nil
+}
+
+// Store keeps payloads that don't fit into a button, the button then carries
+// only the id of the stored payload.
+type Store interface {
+	SaveCallbackPayload(payload string, at time.Time) (int64, error)
+	GetCallbackPayload(id int64) (string, error)
+}
+
+// Codec turns payloads into callback data and back. Every callback data is
+// signed, so the bot only acts on buttons it made itself.
+type Codec struct {
+	key   []byte
+	store Store
+}
+
+func NewCodec(key []byte, store Store) *Codec {
+	return &Codec{key: key, store: store}
+}
+
+func (c *Codec) Encode(payload Payload) (string, error) {
+	for _, part := range append([]string{payload.Action}, payload.Args...) {
+		if strings.ContainsAny(part, argSeparator+signSeparator) {
+			return "", fmt.Errorf("callback argument %q contains a separator", part)
+		}
+	}
+
+	data := payload.encode()
+
+	if len(data)+len(signSeparator)+c.signatureLength() > MaxCallbackData {
+		id, err := c.store.SaveCallbackPayload(data, time.Now())
+
+		if err != nil {
+			return "", err
+		}
+
+		data = storedPrefix + strconv.FormatInt(id, 10)
+	}
+
+	return data + signSeparator + c.sign(data), nil
+}
+
+func (c *Codec) Decode(data string) (Payload, error) {
+	body, signature, found := strings.Cut(data, signSeparator)
+
+	if !found || !hmac.Equal([]byte(signature), []byte(c.sign(body))) {
+		return Payload{}, ErrBadCallback
+	}
+
+	if !strings.HasPrefix(body, storedPrefix) {
+		return decodePayload(body)
+	}
+
+	id, err := strconv.ParseInt(body[len(storedPrefix):], 10, 64)
+
+	if err != nil {
+		return Payload{}, ErrBadCallback
+	}
+
+	stored, err := c.store.GetCallbackPayload(id)
+
+	if err != nil {
+		return Payload{}, err
+	}
+
+	return decodePayload(stored)
+}
+
+func (c *Codec) sign(data string) string {
+	mac := hmac.New(sha256.New, c.key)
+	mac.Write([]byte(data))
+
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:signatureBytes])
+}
+
+func (c *Codec) signatureLength() int {
+	return base64.RawURLEncoding.EncodedLen(signatureBytes)
+}
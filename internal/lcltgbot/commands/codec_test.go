@@ -0,0 +1,138 @@
+package commands
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+type memoryStore struct {
+	payloads []string
+}
+
+func (s *memoryStore) SaveCallbackPayload(payload string, at time.Time) (int64, error) {
+	s.payloads = append(s.payloads, payload)
+	return int64(len(s.payloads)), nil
+}
+
+func (s *memoryStore) GetCallbackPayload(id int64) (string, error) {
+	if id < 1 || id > int64(len(s.payloads)) {
+		return "", errors.New("no values in DB")
+	}
+
+	return s.payloads[id-1], nil
+}
+
+func TestCodecRoundTrip(t *testing.T) {
+	codec := NewCodec([]byte("key"), &memoryStore{})
+
+	tests := []struct {
+		name    string
+		payload Payload
+		stored  bool
+	}{
+		{"no args", NewPayload("sold"), false},
+		{"numbers", NewPayload("near", 10, 55.75583, 37.6173), false},
+		{"draft tag", NewPayload("send", "12.3"), false},
+		{"too long", NewPayload("review", strings.Repeat("x", MaxCallbackData), "12.3"), true},
+	}
+
+	for _, test := range tests {
+		data, err := codec.Encode(test.payload)
+
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+
+		if len(data) > MaxCallbackData {
+			t.Errorf("%s: %d bytes of callback data", test.name, len(data))
+		}
+
+		if stored := strings.HasPrefix(data, storedPrefix); stored != test.stored {
+			t.Errorf("%s: stored %v, want %v", test.name, stored, test.stored)
+		}
+
+		got, err := codec.Decode(data)
+
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+
+		if got.encode() != test.payload.encode() {
+			t.Errorf("%s: got %+v, want %+v", test.name, got, test.payload)
+		}
+	}
+}
+
+func TestCodecRejectsForgedData(t *testing.T) {
+	codec := NewCodec([]byte("key"), &memoryStore{})
+
+	signed, err := codec.Encode(NewPayload("sold", 5))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	foreign, err := NewCodec([]byte("other"), &memoryStore{}).Encode(NewPayload("sold", 5))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"unsigned", "sold:5"},
+		{"changed argument", strings.Replace(signed, "sold:5", "sold:6", 1)},
+		{"other key", foreign},
+		{"empty signature", "sold:5" + signSeparator},
+		{"empty", ""},
+	}
+
+	for _, test := range tests {
+		if _, err := codec.Decode(test.data); !errors.Is(err, ErrBadCallback) {
+			t.Errorf("%s: got %v, want %v", test.name, err, ErrBadCallback)
+		}
+	}
+}
+
+func TestEncodeRejectsSeparators(t *testing.T) {
+	codec := NewCodec([]byte("key"), &memoryStore{})
+
+	for _, arg := range []string{"a:b", "a|b"} {
+		if _, err := codec.Encode(NewPayload("city", arg)); err == nil {
+			t.Errorf("%q: encoded an argument with a separator", arg)
+		}
+	}
+}
+
+func TestPayloadAccessors(t *testing.T) {
+	payload := Payload{Action: "near", Args: []string{"10", "55.7", "x"}}
+
+	tests := []struct {
+		name string
+		get  func() error
+		ok   bool
+	}{
+		{"int", func() error { _, err := payload.Int(0); return err }, true},
+		{"float", func() error { _, err := payload.Float(1); return err }, true},
+		{"int of text", func() error { _, err := payload.Int(2); return err }, false},
+		{"float of text", func() error { _, err := payload.Float(2); return err }, false},
+		{"past the end", func() error { _, err := payload.String(3); return err }, false},
+		{"negative", func() error { _, err := payload.String(-1); return err }, false},
+	}
+
+	for _, test := range tests {
+		if err := test.get(); (err == nil) != test.ok {
+			t.Errorf("%s: got %v, want ok %v", test.name, err, test.ok)
+		}
+	}
+
+	if got := payload.Last(); got != "x" {
+		t.Errorf("last: got %q, want %q", got, "x")
+	}
+}
@@ -3,17 +3,146 @@ package commands
 import "github.com/iokinai/lcltgbot/internal/lcltgbot/models"
 
 const (
-	StartCommand = "/start"
-	CancelFlow   = "/cancel_flow"
-	AddAdCommand = "/add_ad"
+	StartCommand     = "/start"
+	CancelFlow       = "/cancel_flow"
+	AddAdCommand     = "/add_ad"
+	MyAdsCommand     = "/my_ads"
+	NearbyCommand    = "/nearby"
+	EndChatCommand   = "/end_chat"
+	InviteCommand    = "/invite"
+	ReferralCommand  = "/referral"
+	FavoritesCommand = "/favorites"
+
+	AllowGroupCommand = "/allow_group"
+	DenyGroupCommand  = "/deny_group"
+
+	StatsCommand      = "/stats"
+	UserCommand       = "/user"
+	AdCommand         = "/ad"
+	ResetStateCommand = "/reset_state"
+	BroadcastCommand  = "/broadcast"
 )
 
-const ChangeValueCommandData = "changevalue"
+const (
+	ChangeValueCommandData    = "changevalue"
+	ScheduleCommandData       = "schedule"
+	SchedulePresetCommandData = "schedulepreset"
+	CancelScheduleCommandData = "cancelschedule"
+	RenewAdCommandData        = "renewad"
+	MarkSoldCommandData       = "soldad"
+	SkipSoldPriceCommandData  = "skipsoldprice"
+	TruncateAdCommandData     = "truncatead"
+	PriceCurrencyCommandData  = "pricecurrency"
+	PriceTypeCommandData      = "pricetype"
+	PickCityCommandData       = "citypick"
+	KeepCityCommandData       = "citykeep"
+	CategoryCommandData       = "category"
+	PickCategoryCommandData   = "categorypick"
+	LifetimeCommandData       = "lifetime"
+	PickLifetimeCommandData   = "lifetimepick"
+	NearbyCommandData         = "nearby"
+	RelayReplyCommandData     = "relayreply"
+	RelayBlockCommandData     = "relayblock"
+	RelayReportCommandData    = "relayreport"
+	AddFavoriteCommandData    = "favadd"
+	RemoveFavoriteCommandData = "favdel"
+	FavoritesPageCommandData  = "favpage"
+	ChangePriceCommandData    = "changeprice"
+	ReportReasonCommandData   = "reportreason"
+	RestoreAdCommandData      = "modrestore"
+	RemoveAdCommandData       = "modremove"
+	BanAuthorCommandData      = "modban"
+	ApproveAdCommandData      = "modapprove"
+	BumpAdCommandData         = "bumpad"
+	SendToReviewCommandData   = "dupreview"
+
+	AdminStatsCommandData  = "adminstats"
+	AdminUserCommandData   = "adminuser"
+	AdminResetCommandData  = "adminreset"
+	AdminBanCommandData    = "adminban"
+	AdminUnbanCommandData  = "adminunban"
+	AdminAdsCommandData    = "adminads"
+	AdminAdCommandData     = "adminad"
+	AdminEditCommandData   = "adminedit"
+	AdminRemoveCommandData = "adminremove"
+
+	BroadcastSendCommandData    = "bcsend"
+	BroadcastCancelCommandData  = "bccancel"
+	BroadcastNoLinksCommandData = "bcnolinks"
+)
+
+var (
+	SendButtonPair             = models.NewParamPair("Отправить", "send")
+	ScheduleButton             = models.NewParamPair("Запланировать", ScheduleCommandData)
+	CancelScheduleButton       = models.NewParamPair("Отменить публикацию", CancelScheduleCommandData)
+	RenewAdButton              = models.NewParamPair("Продлить", RenewAdCommandData)
+	MarkSoldButton             = models.NewParamPair("Продано", MarkSoldCommandData)
+	SkipSoldPriceButton        = models.NewParamPair("Пропустить", SkipSoldPriceCommandData)
+	TruncateAdButton           = models.NewParamPair("Сократить со ссылкой", TruncateAdCommandData)
+	ChangeTitleButton          = models.NewParamPair("Изменить заголовок", ChangeValueCommandData)
+	ChangeDescriptionButton    = models.NewParamPair("Изменить описание", ChangeValueCommandData)
+	ChangePriceButton          = models.NewParamPair("Изменить цену", ChangeValueCommandData)
+	ChangeCityButton           = models.NewParamPair("Изменить город", ChangeValueCommandData)
+	KeepCityButton             = models.NewParamPair("Оставить «%s»", KeepCityCommandData)
+	CategoryButton             = models.NewParamPair("Категория", CategoryCommandData)
+	LifetimeButton             = models.NewParamPair("Срок публикации", LifetimeCommandData)
+	DefaultLifetimeButton      = models.NewParamPair("По умолчанию", PickLifetimeCommandData)
+	RelayReplyButton           = models.NewParamPair("Ответить", RelayReplyCommandData)
+	RelayBlockButton           = models.NewParamPair("Заблокировать", RelayBlockCommandData)
+	RelayReportButton          = models.NewParamPair("Пожаловаться", RelayReportCommandData)
+	AddFavoriteButton          = models.NewParamPair("☆ Сохранить", AddFavoriteCommandData)
+	RemoveFavoriteButton       = models.NewParamPair("★ В избранном", RemoveFavoriteCommandData)
+	ChangePublishedPriceButton = models.NewParamPair("Изменить цену", ChangePriceCommandData)
+	RestoreAdButton            = models.NewParamPair("Восстановить", RestoreAdCommandData)
+	RemoveAdButton             = models.NewParamPair("Удалить", RemoveAdCommandData)
+	BanAuthorButton            = models.NewParamPair("Забанить автора", BanAuthorCommandData)
+	ApproveAdButton            = models.NewParamPair("Опубликовать", ApproveAdCommandData)
+	BumpAdButton               = models.NewParamPair("Поднять существующее", BumpAdCommandData)
+	SendToReviewButton         = models.NewParamPair("Все равно опубликовать", SendToReviewCommandData)
+)
 
 var (
-	SendButtonPair          = models.NewParamPair("Отправить", "send")
-	ChangeTitleButton       = models.NewParamPair("Изменить заголовок", ChangeValueCommandData)
-	ChangeDescriptionButton = models.NewParamPair("Изменить описание", ChangeValueCommandData)
-	ChangePriceButton       = models.NewParamPair("Изменить цену", ChangeValueCommandData)
-	ChangeCityButton        = models.NewParamPair("Изменить город", ChangeValueCommandData)
+	AdminRefreshButton     = models.NewParamPair("Обновить", AdminStatsCommandData)
+	AdminResetButton       = models.NewParamPair("Сбросить состояние", AdminResetCommandData)
+	AdminBanButton         = models.NewParamPair("Забанить", AdminBanCommandData)
+	AdminUnbanButton       = models.NewParamPair("Разбанить", AdminUnbanCommandData)
+	AdminAdsButton         = models.NewParamPair("Объявления", AdminAdsCommandData)
+	AdminAuthorButton      = models.NewParamPair("Автор", AdminUserCommandData)
+	AdminTitleButton       = models.NewParamPair("Изменить заголовок", AdminEditCommandData)
+	AdminDescriptionButton = models.NewParamPair("Изменить описание", AdminEditCommandData)
+	AdminPriceButton       = models.NewParamPair("Изменить цену", AdminEditCommandData)
+	AdminRemoveButton      = models.NewParamPair("Удалить", AdminRemoveCommandData)
+
+	BroadcastSendButton    = models.NewParamPair("Разослать", BroadcastSendCommandData)
+	BroadcastCancelButton  = models.NewParamPair("Отменить", BroadcastCancelCommandData)
+	BroadcastNoLinksButton = models.NewParamPair("Без кнопок", BroadcastNoLinksCommandData)
+)
+
+const ShareLocationButton = "Отправить геопозицию"
+
+const (
+	OpenPostButton      = "Открыть в канале"
+	ContactSellerButton = "Написать продавцу"
+	ReportAdButton      = "Пожаловаться"
 )
+
+var NearbyRadiuses = []int{1, 3, 10, 25, 50}
+
+// LifetimeDays are the lifetimes owners can pick for their ads, longer ones
+// than the category allows are not offered.
+var LifetimeDays = []int{3, 7, 14, 30, 60}
+
+var PriceTypeButtons = []*models.ParamPair{
+	models.NewParamPair("Фиксированная", models.PriceFixed),
+	models.NewParamPair("Торг", models.PriceNegotiable),
+	models.NewParamPair("Бесплатно", models.PriceFree),
+	models.NewParamPair("Обмен", models.PriceExchange),
+}
+
+var ReportReasonButtons = []*models.ParamPair{
+	models.NewParamPair("Мошенничество", models.ReportScam),
+	models.NewParamPair("Запрещенный товар", models.ReportProhibited),
+	models.NewParamPair("Спам", models.ReportSpam),
+	models.NewParamPair("Неверное описание", models.ReportMisleading),
+	models.NewParamPair("Другое", models.ReportOther),
+}
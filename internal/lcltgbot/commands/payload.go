@@ -0,0 +1,82 @@
+package commands
+
+import (
+	"strconv"
+	"strings"
+)
+
+type StartAction int8
+
+const (
+	StartPlain StartAction = iota
+	StartOpenAd
+	StartContactSeller
+	StartInvite
+	StartReferral
+	StartReport
+)
+
+const (
+	FullTextPayloadPrefix = "full_"
+	ContactPayloadPrefix  = "ad_"
+	InvitePayloadPrefix   = "inv_"
+	ReferralPayloadPrefix = "ref_"
+	ReportPayloadPrefix   = "rep_"
+)
+
+// StartPayload is a parsed t.me/<bot>?start=<payload> deep link. Id holds the
+// ad or the referring user, Code the invite code.
+type StartPayload struct {
+	Action StartAction
+	Id     int64
+	Code   string
+}
+
+func ParseStartPayload(payload string) StartPayload {
+	payload = strings.TrimSpace(payload)
+
+	if code, ok := strings.CutPrefix(payload, InvitePayloadPrefix); ok && code != "" {
+		return StartPayload{Action: StartInvite, Code: code}
+	}
+
+	prefixes := []struct {
+		prefix string
+		action StartAction
+	}{
+		{FullTextPayloadPrefix, StartOpenAd},
+		{ContactPayloadPrefix, StartContactSeller},
+		{ReferralPayloadPrefix, StartReferral},
+		{ReportPayloadPrefix, StartReport},
+	}
+
+	for _, p := range prefixes {
+		rest, ok := strings.CutPrefix(payload, p.prefix)
+
+		if !ok {
+			continue
+		}
+
+		if id, err := strconv.ParseInt(rest, 10, 64); err == nil && id > 0 {
+			return StartPayload{Action: p.action, Id: id}
+		}
+	}
+
+	return StartPayload{Action: StartPlain}
+}
+
+func (p StartPayload) String() string {
+	switch p.Action {
+	case StartOpenAd:
+		return FullTextPayloadPrefix + strconv.FormatInt(p.Id, 10)
+	case StartContactSeller:
+		return ContactPayloadPrefix + strconv.FormatInt(p.Id, 10)
+	case StartInvite:
+		return InvitePayloadPrefix + p.Code
+	case StartReferral:
+		return ReferralPayloadPrefix + strconv.FormatInt(p.Id, 10)
+	case StartReport:
+		return ReportPayloadPrefix + strconv.FormatInt(p.Id, 10)
+	}
+
+	return ""
+}
@@ -0,0 +1,203 @@
+package filter
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+)
+
+var (
+	urlPattern   = regexp.MustCompile(`(?i)(?:https?://|www\.)[^\s]+|\b[a-z0-9][a-z0-9-]*(?:\.[a-z0-9-]+)*\.(?:ru|su|com|net|org|info|biz|io|me|ly|cc|co|to|xyz|online|site|shop|store|link|click|pro|рф)\b(?:/[^\s]*)?`)
+	phonePattern = regexp.MustCompile(`(?:\+7|\b8)[\s\-(]*\d{3}[\s\-)]*\d{3}[\s\-]*\d{2}[\s\-]*\d{2}\b|\+\d[\d\s\-()]{8,}\d`)
+)
+
+type Engine struct {
+	rules []*Rule
+}
+
+func NewEngine(rules []*Rule) *Engine {
+	return &Engine{rules: rules}
+}
+
+// Verdict is the outcome of checking a text. Action is the strictest action
+// of the matched rules and Rule the one that set it, both are empty when
+// nothing matched. Text is the input with everything matched by mask rules
+// replaced with asterisks of the same UTF-16 length, so the offsets of
+// Telegram entities still fit.
+type Verdict struct {
+	Action Action
+	Rule   *Rule
+	Text   string
+}
+
+type span struct {
+	start int
+	end   int
+}
+
+func (e *Engine) Check(text string) Verdict {
+	verdict := Verdict{Text: text}
+
+	if e == nil {
+		return verdict
+	}
+
+	var masked []span
+
+	for _, rule := range e.rules {
+		spans := rule.Find(text)
+
+		if len(spans) == 0 {
+			continue
+		}
+
+		if rule.Action == ActionMask {
+			masked = append(masked, spans...)
+		}
+
+		if Stricter(rule.Action, verdict.Action) {
+			verdict.Action, verdict.Rule = rule.Action, rule
+		}
+	}
+
+	verdict.Text = Mask(text, masked)
+
+	return verdict
+}
+
+// Find returns the byte ranges of text the rule matches.
+func (r *Rule) Find(text string) []span {
+	var spans []span
+
+	switch r.Kind {
+	case KindWords:
+		for _, token := range Tokens(text) {
+			normalized := NormalizeWord(text[token.start:token.end])
+
+			for _, w := range r.words {
+				if w.Matches(normalized) {
+					spans = append(spans, token)
+					break
+				}
+			}
+		}
+	case KindRegex:
+		for _, compiled := range r.regexes {
+			spans = append(spans, toSpans(compiled.FindAllStringIndex(text, -1))...)
+		}
+	case KindLinks:
+		for _, found := range urlPattern.FindAllStringIndex(text, -1) {
+			if r.LinkMatches(Domain(text[found[0]:found[1]])) {
+				spans = append(spans, span{found[0], found[1]})
+			}
+		}
+	case KindPhones:
+		spans = toSpans(phonePattern.FindAllStringIndex(text, -1))
+	}
+
+	return spans
+}
+
+func toSpans(indexes [][]int) []span {
+	spans := make([]span, 0, len(indexes))
+
+	for _, index := range indexes {
+		spans = append(spans, span{index[0], index[1]})
+	}
+
+	return spans
+}
+
+// Tokens splits text into runs of letters and digits. Digits are kept inside
+// words because they are used in place of letters ("0" for "о").
+func Tokens(text string) []span {
+	var (
+		tokens []span
+		start  = -1
+	)
+
+	for i, r := range text {
+		inWord := unicode.IsLetter(r) || unicode.IsDigit(r)
+
+		if inWord && start < 0 {
+			start = i
+		} else if !inWord && start >= 0 {
+			tokens = append(tokens, span{start, i})
+			start = -1
+		}
+	}
+
+	if start >= 0 {
+		tokens = append(tokens, span{start, len(text)})
+	}
+
+	return tokens
+}
+
+func Domain(link string) string {
+	link = strings.ToLower(link)
+
+	for _, prefix := range []string{"https://", "http://"} {
+		link = strings.TrimPrefix(link, prefix)
+	}
+
+	if end := strings.IndexAny(link, "/?#:"); end >= 0 {
+		link = link[:end]
+	}
+
+	return strings.TrimPrefix(link, "www.")
+}
+
+func (r *Rule) LinkMatches(domain string) bool {
+	if DomainIn(domain, r.Deny) {
+		return true
+	}
+
+	return len(r.Allow) > 0 && !DomainIn(domain, r.Allow)
+}
+
+// DomainIn also counts subdomains, "m.avito.ru" is in a list with "avito.ru".
+func DomainIn(domain string, list []string) bool {
+	for _, entry := range list {
+		entry = strings.ToLower(entry)
+
+		if domain == entry || strings.HasSuffix(domain, "."+entry) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func Mask(text string, spans []span) string {
+	if len(spans) == 0 {
+		return text
+	}
+
+	sort.Slice(spans, func(i, j int) bool { return spans[i].start < spans[j].start })
+
+	var (
+		builder strings.Builder
+		last    int
+	)
+
+	for _, s := range spans {
+		if s.start < last {
+			s.start = last
+		}
+
+		if s.end <= s.start {
+			continue
+		}
+
+		builder.WriteString(text[last:s.start])
+		builder.WriteString(strings.Repeat("*", len(utf16.Encode([]rune(text[s.start:s.end])))))
+		last = s.end
+	}
+
+	builder.WriteString(text[last:])
+
+	return builder.String()
+}
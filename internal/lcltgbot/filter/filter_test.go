@@ -0,0 +1,124 @@
+package filter
+
+import (
+	"testing"
+	"unicode/utf16"
+)
+
+func TestStem(t *testing.T) {
+	tests := []struct {
+		word string
+		want string
+	}{
+		{"мошенник", "мошенник"},
+		{"мошенники", "мошенник"},
+		{"мошенниками", "мошенник"},
+		{"продать", "прод"},
+		{"кот", "кот"},
+		{"коты", "коты"},
+		{"taxi", "taxi"},
+	}
+
+	for _, test := range tests {
+		if got := Stem(test.word); got != test.want {
+			t.Errorf("%s: got %q, want %q", test.word, got, test.want)
+		}
+	}
+}
+
+func TestNormalizeWord(t *testing.T) {
+	tests := []struct {
+		word string
+		want string
+	}{
+		{"Казино", "казино"},
+		{"кaзинo", "казино"},
+		{"к4зин0", "к4зино"},
+		{"Ёлка", "елка"},
+		{"Taxi", "taxi"},
+	}
+
+	for _, test := range tests {
+		if got := NormalizeWord(test.word); got != test.want {
+			t.Errorf("%s: got %q, want %q", test.word, got, test.want)
+		}
+	}
+}
+
+func testEngine(t *testing.T) *Engine {
+	t.Helper()
+
+	rules := []*Rule{
+		{Name: "scam", Kind: KindWords, Words: []string{"мошенник", "казино*"}, Action: ActionModerate},
+		{Name: "short links", Kind: KindLinks, Deny: []string{"bit.ly"}, Action: ActionReject},
+		{Name: "sites", Kind: KindLinks, Allow: []string{"avito.ru"}, Action: ActionModerate},
+		{Name: "phones", Kind: KindPhones, Action: ActionMask},
+		{Name: "prepayment", Kind: KindRegex, Patterns: []string{`(?i)предоплат[аыуе]`}, Action: ActionModerate},
+	}
+
+	for _, rule := range rules {
+		if err := rule.Compile(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return NewEngine(rules)
+}
+
+func TestCheck(t *testing.T) {
+	engine := testEngine(t)
+
+	tests := []struct {
+		name   string
+		text   string
+		action Action
+		rule   string
+		masked string
+	}{
+		{"clean", "Продам велосипед", "", "", "Продам велосипед"},
+		{"inflected word", "Осторожно, мошенники!", ActionModerate, "scam", "Осторожно, мошенники!"},
+		{"lookalike letters", "Играй в кaзинo", ActionModerate, "scam", "Играй в кaзинo"},
+		{"word inside a word", "Мошенничество", "", "", "Мошенничество"},
+		{"denied link", "Фото: https://bit.ly/abc", ActionReject, "short links", "Фото: https://bit.ly/abc"},
+		{"allowed subdomain", "См. m.avito.ru/item", "", "", "См. m.avito.ru/item"},
+		{"other site", "См. example.com", ActionModerate, "sites", "См. example.com"},
+		{"phone", "Звоните +7 912 345-67-89", ActionMask, "phones", "Звоните ****************"},
+		{"regex", "Только ПРЕДОПЛАТА", ActionModerate, "prepayment", "Только ПРЕДОПЛАТА"},
+		{"strictest wins", "мошенник, bit.ly/x", ActionReject, "short links", "мошенник, bit.ly/x"},
+	}
+
+	for _, test := range tests {
+		verdict := engine.Check(test.text)
+
+		rule := ""
+
+		if verdict.Rule != nil {
+			rule = verdict.Rule.Name
+		}
+
+		if verdict.Action != test.action || rule != test.rule || verdict.Text != test.masked {
+			t.Errorf("%s: got %q %q %q, want %q %q %q", test.name, verdict.Action, rule, verdict.Text, test.action, test.rule, test.masked)
+		}
+	}
+}
+
+func TestMaskKeepsUTF16Length(t *testing.T) {
+	text := "🚲 тел 89123456789 🚲"
+	masked := testEngine(t).Check(text).Text
+
+	if masked == text {
+		t.Fatalf("phone in %q not masked", text)
+	}
+
+	if got, want := len(utf16.Encode([]rune(masked))), len(utf16.Encode([]rune(text))); got != want {
+		t.Errorf("got %d UTF-16 units in %q, want %d", got, masked, want)
+	}
+}
+
+func TestNilEngine(t *testing.T) {
+	var e *Engine
+
+	if verdict := e.Check("текст"); verdict.Action != "" || verdict.Text != "текст" {
+		t.Errorf("got %+v, want the text let through", verdict)
+	}
+}
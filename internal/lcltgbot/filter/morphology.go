@@ -0,0 +1,81 @@
+package filter
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// lookalikes turns Latin letters and digits used to dodge filters into the
+// Cyrillic letters they imitate.
+var lookalikes = strings.NewReplacer(
+	"a", "а", "b", "в", "c", "с", "e", "е", "h", "н", "k", "к", "m", "м",
+	"o", "о", "p", "р", "t", "т", "x", "х", "y", "у", "0", "о", "3", "з",
+	"ё", "е",
+)
+
+// endings are Russian noun, adjective and verb endings, longest first so that
+// "ами" is tried before "и".
+var endings = []string{
+	"иями", "ями", "ами", "ого", "его", "ому", "ему", "ыми", "ими", "ией",
+	"ать", "ять", "ить", "еть", "уть", "ешь", "ишь", "ете", "ите", "ются",
+	"ах", "ях", "ов", "ев", "ей", "ий", "ый", "ой", "ая", "яя", "ое", "ее",
+	"ые", "ие", "ую", "юю", "ом", "ем", "ам", "ям", "ым", "им", "ть", "ет",
+	"ит", "ут", "ют", "ат", "ят", "ся", "сь",
+	"а", "я", "о", "е", "ы", "и", "у", "ю", "ь", "й",
+}
+
+// minStem keeps short words from being cut down to a letter or two that
+// would then match half the dictionary.
+const minStem = 4
+
+// maxEnding is how many letters a word may have past the stem and still be
+// taken for its inflection.
+const maxEnding = 4
+
+// NormalizeWord lowercases a word written with Cyrillic and lookalike Latin
+// letters. A word that is Latin only is left as is, so "taxi" does not turn
+// into nonsense.
+func NormalizeWord(word string) string {
+	word = strings.ToLower(word)
+
+	if !hasCyrillic(word) {
+		return word
+	}
+
+	return lookalikes.Replace(word)
+}
+
+func hasCyrillic(word string) bool {
+	for _, r := range word {
+		if r >= 'а' && r <= 'я' || r == 'ё' {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Stem strips one inflectional ending, a crude but predictable replacement
+// for a real stemmer that is enough to match "мошенник", "мошенники" and
+// "мошенникам" with one entry.
+func Stem(word string) string {
+	for _, ending := range endings {
+		if !strings.HasSuffix(word, ending) {
+			continue
+		}
+
+		if stem := strings.TrimSuffix(word, ending); utf8.RuneCountInString(stem) >= minStem {
+			return stem
+		}
+	}
+
+	return word
+}
+
+func (w word) Matches(token string) bool {
+	if !strings.HasPrefix(token, w.stem) {
+		return false
+	}
+
+	return w.prefix || utf8.RuneCountInString(token)-utf8.RuneCountInString(w.stem) <= maxEnding
+}
@@ -0,0 +1,150 @@
+package filter
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+type Action string
+
+const (
+	ActionMask     Action = "mask"
+	ActionModerate Action = "moderate"
+	ActionReject   Action = "reject"
+)
+
+var severity = map[Action]int{
+	ActionMask:     1,
+	ActionModerate: 2,
+	ActionReject:   3,
+}
+
+func Stricter(a Action, b Action) bool {
+	return severity[a] > severity[b]
+}
+
+type Kind string
+
+const (
+	KindWords  Kind = "words"
+	KindRegex  Kind = "regex"
+	KindLinks  Kind = "links"
+	KindPhones Kind = "phones"
+)
+
+// Rule is one entry of a filter file. Words end with "*" to match any ending,
+// otherwise Russian inflections of the word are matched. A links rule matches
+// URLs whose domain is in Deny or, when Allow is set, not in Allow.
+type Rule struct {
+	Name     string   `json:"name"`
+	Kind     Kind     `json:"kind"`
+	Words    []string `json:"words"`
+	Patterns []string `json:"patterns"`
+	Allow    []string `json:"allow"`
+	Deny     []string `json:"deny"`
+	Action   Action   `json:"action"`
+	Message  string   `json:"message"`
+
+	words   []word
+	regexes []*regexp.Regexp
+}
+
+type word struct {
+	stem   string
+	prefix bool
+}
+
+// LoadDir reads every *.json file in dir, each holding an array of rules.
+// A missing directory gives an engine that lets everything through.
+func LoadDir(dir string) (*Engine, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+
+	if err != nil {
+		return nil, err
+	}
+
+	var rules []*Rule
+
+	for _, path := range files {
+		loaded, err := LoadFile(path)
+
+		if err != nil {
+			return nil, err
+		}
+
+		rules = append(rules, loaded...)
+	}
+
+	return NewEngine(rules), nil
+}
+
+func LoadFile(path string) ([]*Rule, error) {
+	file, err := os.Open(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	var rules []*Rule
+
+	if err := json.NewDecoder(file).Decode(&rules); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	for _, rule := range rules {
+		if err := rule.Compile(); err != nil {
+			return nil, fmt.Errorf("%s: rule %q: %w", path, rule.Name, err)
+		}
+	}
+
+	return rules, nil
+}
+
+func (r *Rule) Compile() error {
+	if _, ok := severity[r.Action]; !ok {
+		return fmt.Errorf("unknown action %q", r.Action)
+	}
+
+	switch r.Kind {
+	case KindWords:
+		r.words = nil
+
+		for _, entry := range r.Words {
+			prefix := strings.HasSuffix(entry, "*")
+			normalized := NormalizeWord(strings.TrimSuffix(entry, "*"))
+
+			if normalized == "" {
+				continue
+			}
+
+			if !prefix {
+				normalized = Stem(normalized)
+			}
+
+			r.words = append(r.words, word{stem: normalized, prefix: prefix})
+		}
+	case KindRegex:
+		r.regexes = nil
+
+		for _, pattern := range r.Patterns {
+			compiled, err := regexp.Compile(pattern)
+
+			if err != nil {
+				return err
+			}
+
+			r.regexes = append(r.regexes, compiled)
+		}
+	case KindLinks, KindPhones:
+	default:
+		return fmt.Errorf("unknown kind %q", r.Kind)
+	}
+
+	return nil
+}
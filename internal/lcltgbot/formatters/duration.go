@@ -0,0 +1,26 @@
+package formatters
+
+import (
+	"fmt"
+	"time"
+)
+
+// FormatWait rounds a retry delay up to what is worth telling the user:
+// seconds under a minute, minutes under an hour, hours and minutes above.
+func FormatWait(wait time.Duration) string {
+	switch {
+	case wait < time.Minute:
+		return fmt.Sprintf("%d сек", int((wait+time.Second-1)/time.Second))
+	case wait < time.Hour:
+		return fmt.Sprintf("%d мин", int((wait+time.Minute-1)/time.Minute))
+	}
+
+	wait = wait.Round(time.Minute)
+	hours, minutes := int(wait/time.Hour), int(wait%time.Hour/time.Minute)
+
+	if minutes == 0 {
+		return fmt.Sprintf("%d ч", hours)
+	}
+
+	return fmt.Sprintf("%d ч %d мин", hours, minutes)
+}
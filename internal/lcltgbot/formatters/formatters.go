@@ -1,34 +1,230 @@
 package formatters
 
 import (
+	"bytes"
 	"fmt"
-	"github.com/dustin/go-humanize"
 	"github.com/iokinai/lcltgbot/internal/lcltgbot/models"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
 )
 
-const DEBUG = true
+const (
+	ChannelPostTemplate = "channel_post.tmpl"
+	PreviewTemplate     = "preview.tmpl"
+	SoldTemplate        = "sold.tmpl"
+	ExpiredTemplate     = "expired.tmpl"
+	SummaryTemplate     = "summary.tmpl"
+	SearchCardTemplate  = "search_card.tmpl"
+	FavoriteTemplate    = "favorite.tmpl"
+	ModeratedTemplate   = "moderated.tmpl"
+)
+
+var AdTemplates = []string{
+	ChannelPostTemplate,
+	PreviewTemplate,
+	SoldTemplate,
+	ExpiredTemplate,
+	SummaryTemplate,
+	SearchCardTemplate,
+	FavoriteTemplate,
+	ModeratedTemplate,
+}
+
+type AdView struct {
+	Ad       *models.Advertisement
+	Contact  string
+	ReadMore string
+	Locale   string
+	Distance *float64
+	Debug    bool
+}
+
+type Renderer struct {
+	templates *template.Template
+	markup    Markup
+	locale    string
+	// Debug adds the beta notice to previews and channel posts.
+	Debug bool
+}
+
+func LoadRenderer(dir string, parsemode string, locale string, location *time.Location, text *models.TextSettings) (*Renderer, error) {
+	markup, err := NewMarkup(parsemode)
+
+	if err != nil {
+		return nil, err
+	}
+
+	templates, err := template.New("").Funcs(TemplateFuncs(markup, location, text)).ParseGlob(filepath.Join(dir, markup.ParseMode(), "*.tmpl"))
+
+	if err != nil {
+		return nil, err
+	}
+
+	renderer := &Renderer{templates: templates, markup: markup, locale: LocaleOf(locale, DefaultLocale)}
+
+	if err := renderer.Validate(); err != nil {
+		return nil, err
+	}
+
+	return renderer, nil
+}
+
+// TemplateFuncs returns helpers that already escape their output for the
+// markup, so templates only need escape for plain user fields.
+func TemplateFuncs(markup Markup, location *time.Location, text *models.TextSettings) template.FuncMap {
+	return template.FuncMap{
+		"escape": markup.Escape,
+		"link":   markup.Link,
+		"rich": func(text string, entities []models.Entity, skip ...string) string {
+			return RenderEntities(markup, text, entities, skip...)
+		},
+		"price": func(ad *models.Advertisement, locale string) string {
+			return markup.Escape(FormatPrice(ad, locale))
+		},
+		"city": func(city string) string {
+			city = strings.TrimSpace(city)
 
-func FormatAdToMessageString(advertisement *models.Advertisement, username string) string {
-	debugmessage := ""
+			if city == "" {
+				return markup.Escape(text.NoCity)
+			}
 
-	if DEBUG {
-		debugmessage = `
-<b>*юзернеймы пользователей скрыты в бета версии</b>
-`
+			return markup.Escape(fmt.Sprintf(text.CityLabel, city))
+		},
+		"distance": func(km float64, locale string) string {
+			return markup.Escape(FormatDistance(km, locale))
+		},
+		"truncate": func(limit int, text string) string {
+			return Truncate(text, limit)
+		},
+		"date": func(t time.Time) string {
+			return markup.Escape(t.In(location).Format("02.01.2006"))
+		},
+		"datetime": func(t time.Time) string {
+			return markup.Escape(t.In(location).Format("02.01.2006 15:04"))
+		},
 	}
+}
 
-	return fmt.Sprintf(
-		`<b>%s</b>
+func Truncate(text string, limit int) string {
+	runes := []rune(text)
 
+	if len(runes) <= limit {
+		return text
+	}
 
-<i>Описание:</i>
-%s
+	return strings.TrimSpace(string(runes[:limit])) + "…"
+}
+
+func (r *Renderer) ParseMode() string {
+	return r.markup.ParseMode()
+}
+
+func (r *Renderer) Escape(text string) string {
+	return r.markup.Escape(text)
+}
+
+func (r *Renderer) Link(label string, url string) string {
+	return r.markup.Link(label, url)
+}
+
+func (r *Renderer) Bold(text string) string {
+	return r.markup.Open("bold") + r.markup.Escape(text) + r.markup.Close("bold")
+}
+
+// Locale picks the number format for a user, the deployment one by default.
+func (r *Renderer) Locale(user *models.User) string {
+	return LocaleOf(user.Language, r.locale)
+}
+
+func (r *Renderer) Contact(user *models.User) string {
+	if user.Username != "" {
+		return r.markup.Escape("@" + user.Username)
+	}
 
-Цена: <b>%s ₽</b>
+	name := user.FirstName
 
-<i>г. %s</i>
+	if name == "" {
+		name = fmt.Sprintf("id%d", user.Id)
+	}
+
+	return r.markup.Link(name, fmt.Sprintf("tg://user?id=%d", user.Id))
+}
+
+// Validate renders every ad template against sample ads in all statuses, so a
+// broken template fails at startup instead of on the first publish.
+func (r *Renderer) Validate() error {
+	now := time.Now()
+
+	for _, name := range AdTemplates {
+		if r.templates.Lookup(name) == nil {
+			return fmt.Errorf("template %s is missing", name)
+		}
+
+		for status := models.AdStatusScheduled; status <= models.AdStatusPublishing; status++ {
+			ad := models.NewAdvertisement(1, "<Велосипед & шлем>", "Почти *новый*, без_царапин. Торг!", 15000.5, "Москва", false)
+			ad.TitleEntities = []models.Entity{{Type: "italic", Offset: 1, Length: 9}}
+			ad.DescriptionEntities = []models.Entity{{Type: "bold", Offset: 0, Length: 5}, {Type: "italic", Offset: 6, Length: 8}}
+			ad.Status = status
+			ad.Currency, ad.PriceType = "RUB", models.PriceType(int(status)%int(models.PriceExchange+1))
+			ad.PublishAt, ad.PublishedAt, ad.SoldAt, ad.ExpiresAt = now, now, now, now
+
+			distance := 2.5
+
+			if _, err := r.Render(name, AdView{Ad: ad, Contact: r.Escape("@seller"), ReadMore: "https://t.me/bot?start=full_1", Locale: r.locale, Distance: &distance, Debug: true}); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (r *Renderer) Render(name string, view AdView) (string, error) {
+	var buffer bytes.Buffer
+
+	if err := r.templates.ExecuteTemplate(&buffer, name, view); err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(buffer.String()), nil
+}
+
+func (r *Renderer) ChannelPost(advertisement *models.Advertisement, contact string, readmore string) (string, error) {
+	view := AdView{Ad: advertisement, Contact: contact, Locale: r.locale, Debug: r.Debug}
+
+	if advertisement.Truncated {
+		view.ReadMore = readmore
+	}
+
+	return r.Fit(ChannelPostTemplate, view, MessageLimit, advertisement.Truncated)
+}
+
+func (r *Renderer) Preview(advertisement *models.Advertisement, contact string, locale string) (string, error) {
+	return r.Fit(PreviewTemplate, AdView{Ad: advertisement, Contact: contact, Locale: locale, Debug: r.Debug}, MessageLimit, true)
+}
+
+func (r *Renderer) Sold(advertisement *models.Advertisement) (string, error) {
+	return r.Render(SoldTemplate, AdView{Ad: advertisement, Locale: r.locale})
+}
+
+func (r *Renderer) Expired(advertisement *models.Advertisement) (string, error) {
+	return r.Render(ExpiredTemplate, AdView{Ad: advertisement, Locale: r.locale})
+}
+
+func (r *Renderer) Moderated(advertisement *models.Advertisement) (string, error) {
+	return r.Render(ModeratedTemplate, AdView{Ad: advertisement, Locale: r.locale})
+}
+
+func (r *Renderer) Summary(advertisement *models.Advertisement, locale string) (string, error) {
+	return r.Render(SummaryTemplate, AdView{Ad: advertisement, Locale: locale})
+}
+
+func (r *Renderer) Favorite(advertisement *models.Advertisement, locale string, readmore string) (string, error) {
+	return r.Render(FavoriteTemplate, AdView{Ad: advertisement, Locale: locale, ReadMore: readmore})
+}
 
-Писать в: %s
-%s			
-	`, advertisement.Title, advertisement.Description, humanize.FormatFloat("# ###.##", advertisement.Price), advertisement.City, username, debugmessage)
+func (r *Renderer) SearchCard(advertisement *models.Advertisement, locale string, distance *float64) (string, error) {
+	return r.Render(SearchCardTemplate, AdView{Ad: advertisement, Locale: locale, Distance: distance})
 }
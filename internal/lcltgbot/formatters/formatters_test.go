@@ -0,0 +1,143 @@
+package formatters
+
+import (
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/iokinai/lcltgbot/internal/lcltgbot/models"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testText = &models.TextSettings{CityLabel: "г. %s", NoCity: "город не указан"}
+
+func loadTestRenderer(t *testing.T, parsemode string) *Renderer {
+	t.Helper()
+
+	renderer, err := LoadRenderer("../../../assets/templates", parsemode, "ru", time.UTC, testText)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return renderer
+}
+
+func sampleAd(title string, description string) *models.Advertisement {
+	ad := models.NewAdvertisement(1, title, description, 15000, "Москва", false)
+	ad.OwnerId = 10
+	ad.Currency = "RUB"
+	ad.Status = models.AdStatusPublished
+	ad.PublishedAt = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	ad.SoldAt = ad.PublishedAt
+
+	return ad
+}
+
+// sampleAds returns the ads every template is rendered for, with the text
+// each one must show in the channel post, already escaped for the markup.
+func sampleAds(markup Markup) map[string]struct {
+	ad   *models.Advertisement
+	want []string
+} {
+	free := sampleAd("Кресло", "Отдам даром")
+	free.PriceType = models.PriceFree
+
+	exchange := sampleAd("Гитара", "Меняю на синтезатор")
+	exchange.PriceType = models.PriceExchange
+
+	nocity := sampleAd("Лампа", "Настольная")
+	nocity.City = ""
+
+	truncated := sampleAd("Диван", strings.Repeat("Очень длинное описание. ", 40))
+	truncated.Truncated = true
+
+	entities := sampleAd("<Велосипед & шлем>", "Почти новый, без_царапин. Торг!")
+	entities.TitleEntities = []models.Entity{{Type: "italic", Offset: 1, Length: 9}}
+	entities.DescriptionEntities = []models.Entity{{Type: "bold", Offset: 0, Length: 5}}
+
+	return map[string]struct {
+		ad   *models.Advertisement
+		want []string
+	}{
+		"free":      {free, []string{"бесплатно", markup.Escape("г. Москва")}},
+		"exchange":  {exchange, []string{"обмен"}},
+		"no city":   {nocity, []string{markup.Escape("город не указан")}},
+		"truncated": {truncated, []string{"https://t.me/bot?start=full_1"}},
+		"entities": {entities, []string{
+			markup.Open("italic") + "Велосипед" + markup.Close("italic"),
+			markup.Escape("<"), markup.Escape("без_царапин. Торг!"),
+			markup.Open("bold") + "Почти" + markup.Close("bold"),
+		}},
+	}
+}
+
+func TestRenderAllTemplates(t *testing.T) {
+	for _, parsemode := range []string{tgbotapi.ModeHTML, tgbotapi.ModeMarkdownV2} {
+		renderer := loadTestRenderer(t, parsemode)
+
+		for name, sample := range sampleAds(renderer.markup) {
+			for _, template := range AdTemplates {
+				distance := 1.5
+				view := AdView{Ad: sample.ad, Contact: renderer.Escape("@seller"), ReadMore: "https://t.me/bot?start=full_1", Locale: "ru", Distance: &distance}
+
+				text, err := renderer.Render(template, view)
+
+				if err != nil {
+					t.Errorf("%s, %s, %s: %v", parsemode, name, template, err)
+					continue
+				}
+
+				if text == "" {
+					t.Errorf("%s, %s, %s: empty text", parsemode, name, template)
+				}
+			}
+
+			post, err := renderer.ChannelPost(sample.ad, renderer.Escape("@seller"), "https://t.me/bot?start=full_1")
+
+			if err != nil {
+				t.Errorf("%s, %s: %v", parsemode, name, err)
+				continue
+			}
+
+			for _, want := range sample.want {
+				if !strings.Contains(post, want) {
+					t.Errorf("%s, %s: post %q does not contain %q", parsemode, name, post, want)
+				}
+			}
+		}
+	}
+}
+
+func TestReadMoreOnlyForTruncatedAds(t *testing.T) {
+	renderer := loadTestRenderer(t, tgbotapi.ModeHTML)
+	ad := sampleAd("Диван", "Угловой")
+
+	post, err := renderer.ChannelPost(ad, "@seller", "https://t.me/bot?start=full_1")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(post, "full_1") {
+		t.Errorf("post of a whole ad links to the full text: %q", post)
+	}
+}
+
+func TestDebugNotice(t *testing.T) {
+	renderer := loadTestRenderer(t, tgbotapi.ModeMarkdownV2)
+	ad := sampleAd("Диван", "Угловой")
+
+	for _, debug := range []bool{false, true} {
+		renderer.Debug = debug
+
+		preview, err := renderer.Preview(ad, "@seller", "ru")
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := strings.Contains(preview, "бета"); got != debug {
+			t.Errorf("debug %v: notice shown %v in %q", debug, got, preview)
+		}
+	}
+}
@@ -0,0 +1,226 @@
+package formatters
+
+import (
+	"fmt"
+	"github.com/iokinai/lcltgbot/internal/lcltgbot/models"
+	"html"
+	"regexp"
+	"strings"
+	"unicode/utf16"
+)
+
+const (
+	MessageLimit = 4096
+	CaptionLimit = 1024
+)
+
+type TooLongError struct {
+	Length int
+	Limit  int
+}
+
+func (e *TooLongError) Error() string {
+	return fmt.Sprintf("message is %d characters long, limit is %d", e.Length, e.Limit)
+}
+
+var htmlTagPattern = regexp.MustCompile(`<[^>]*>`)
+
+// PlainText strips the markup the way Telegram does before it counts the
+// message length.
+func PlainText(markup Markup, rendered string) string {
+	if _, ok := markup.(MarkdownV2Markup); !ok {
+		return html.UnescapeString(htmlTagPattern.ReplaceAllString(rendered, ""))
+	}
+
+	var (
+		builder strings.Builder
+		runes   = []rune(rendered)
+		inURL   bool
+	)
+
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+
+		switch {
+		case r == '\\' && i+1 < len(runes):
+			i++
+
+			if !inURL {
+				builder.WriteRune(runes[i])
+			}
+		case inURL:
+			inURL = r != ')'
+		case r == ']' && i+1 < len(runes) && runes[i+1] == '(':
+			inURL = true
+			i++
+		case strings.ContainsRune("*_~|[\r", r):
+		default:
+			builder.WriteRune(r)
+		}
+	}
+
+	return builder.String()
+}
+
+func TextLength(text string) int {
+	return len(utf16.Encode([]rune(text)))
+}
+
+func (r *Renderer) Length(rendered string) int {
+	return TextLength(PlainText(r.markup, rendered))
+}
+
+// Fit renders the template and, if the result is over the limit, cuts the
+// description down to the longest prefix that still fits. Without allowCut an
+// overlong ad is reported as *TooLongError.
+func (r *Renderer) Fit(name string, view AdView, limit int, allowCut bool) (string, error) {
+	text, err := r.Render(name, view)
+
+	if err != nil {
+		return "", err
+	}
+
+	length := r.Length(text)
+
+	if length <= limit {
+		return text, nil
+	}
+
+	if !allowCut {
+		return "", &TooLongError{Length: length, Limit: limit}
+	}
+
+	full := view.Ad
+	units := utf16.Encode([]rune(full.Description))
+	fitted := ""
+	low, high := 0, len(units)
+
+	for low <= high {
+		middle := (low + high) / 2
+		cut := *full
+		cut.Description, cut.DescriptionEntities = CutText(full.Description, full.DescriptionEntities, middle)
+		view.Ad = &cut
+
+		candidate, err := r.Render(name, view)
+
+		if err != nil {
+			return "", err
+		}
+
+		if r.Length(candidate) <= limit {
+			fitted = candidate
+			low = middle + 1
+		} else {
+			high = middle - 1
+		}
+	}
+
+	if fitted == "" {
+		return "", &TooLongError{Length: length, Limit: limit}
+	}
+
+	return fitted, nil
+}
+
+// CutText keeps the first units UTF-16 code units of text, adds an ellipsis
+// and clips the entities to the kept part.
+func CutText(text string, entities []models.Entity, units int) (string, []models.Entity) {
+	encoded := utf16.Encode([]rune(text))
+
+	if units >= len(encoded) {
+		return text, entities
+	}
+
+	if units > 0 && isHighSurrogate(encoded[units-1]) {
+		units--
+	}
+
+	var clipped []models.Entity
+
+	for _, entity := range entities {
+		if entity.Offset >= units {
+			continue
+		}
+
+		if entity.Offset+entity.Length > units {
+			entity.Length = units - entity.Offset
+		}
+
+		clipped = append(clipped, entity)
+	}
+
+	return strings.TrimRight(string(utf16.Decode(encoded[:units])), " \n") + "…", clipped
+}
+
+type TextChunk struct {
+	Text     string
+	Entities []models.Entity
+}
+
+// SplitText splits plain text into chunks of at most limit UTF-16 code units,
+// preferring line and word breaks, and shifts the entities into each chunk.
+func SplitText(text string, entities []models.Entity, limit int) []TextChunk {
+	units := utf16.Encode([]rune(text))
+
+	var chunks []TextChunk
+
+	for start := 0; start < len(units); {
+		end := start + limit
+
+		if end >= len(units) {
+			end = len(units)
+		} else {
+			for _, separator := range []uint16{'\n', ' '} {
+				if cut := lastIndex(units[start:end], separator); cut > 0 {
+					end = start + cut + 1
+					break
+				}
+			}
+
+			if isHighSurrogate(units[end-1]) && end-1 > start {
+				end--
+			}
+		}
+
+		chunk := TextChunk{Text: string(utf16.Decode(units[start:end]))}
+
+		for _, entity := range entities {
+			from, to := entity.Offset, entity.Offset+entity.Length
+
+			if to <= start || from >= end {
+				continue
+			}
+
+			if from < start {
+				from = start
+			}
+
+			if to > end {
+				to = end
+			}
+
+			chunk.Entities = append(chunk.Entities, models.Entity{Type: entity.Type, Offset: from - start, Length: to - from})
+		}
+
+		chunks = append(chunks, chunk)
+		start = end
+	}
+
+	return chunks
+}
+
+func lastIndex(units []uint16, unit uint16) int {
+	for i := len(units) - 1; i >= 0; i-- {
+		if units[i] == unit {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// isHighSurrogate is true for the first half of a surrogate pair, a cut right
+// after it would split the character.
+func isHighSurrogate(unit uint16) bool {
+	return unit >= 0xd800 && unit < 0xdc00
+}
@@ -0,0 +1,216 @@
+package formatters
+
+import (
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/iokinai/lcltgbot/internal/lcltgbot/models"
+	"html"
+	"sort"
+	"strings"
+	"unicode/utf16"
+)
+
+type Markup interface {
+	ParseMode() string
+	Escape(text string) string
+	Open(entity string) string
+	Close(entity string) string
+	Link(label string, url string) string
+}
+
+func NewMarkup(parsemode string) (Markup, error) {
+	switch parsemode {
+	case "", tgbotapi.ModeHTML:
+		return HTMLMarkup{}, nil
+	case tgbotapi.ModeMarkdownV2:
+		return MarkdownV2Markup{}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported parse mode %q", parsemode)
+}
+
+var htmlTags = map[string]string{
+	"bold":          "b",
+	"italic":        "i",
+	"underline":     "u",
+	"strikethrough": "s",
+	"spoiler":       "tg-spoiler",
+}
+
+type HTMLMarkup struct{}
+
+func (HTMLMarkup) ParseMode() string {
+	return tgbotapi.ModeHTML
+}
+
+func (HTMLMarkup) Escape(text string) string {
+	return html.EscapeString(text)
+}
+
+func (HTMLMarkup) Open(entity string) string {
+	return "<" + htmlTags[entity] + ">"
+}
+
+func (HTMLMarkup) Close(entity string) string {
+	return "</" + htmlTags[entity] + ">"
+}
+
+func (m HTMLMarkup) Link(label string, url string) string {
+	return fmt.Sprintf(`<a href="%s">%s</a>`, html.EscapeString(url), m.Escape(label))
+}
+
+var markdownV2Tags = map[string]string{
+	"bold":          "*",
+	"italic":        "_",
+	"underline":     "__",
+	"strikethrough": "~",
+	"spoiler":       "||",
+}
+
+var markdownV2Replacer = strings.NewReplacer(
+	"\\", "\\\\", "_", "\\_", "*", "\\*", "[", "\\[", "]", "\\]", "(", "\\(", ")", "\\)",
+	"~", "\\~", "`", "\\`", ">", "\\>", "#", "\\#", "+", "\\+", "-", "\\-", "=", "\\=",
+	"|", "\\|", "{", "\\{", "}", "\\}", ".", "\\.", "!", "\\!",
+)
+
+type MarkdownV2Markup struct{}
+
+func (MarkdownV2Markup) ParseMode() string {
+	return tgbotapi.ModeMarkdownV2
+}
+
+func (MarkdownV2Markup) Escape(text string) string {
+	return markdownV2Replacer.Replace(text)
+}
+
+func (MarkdownV2Markup) Open(entity string) string {
+	return markdownV2Tags[entity]
+}
+
+// Close ends an italic entity with "\r" so that "_" next to "__" is never read
+// as part of an underline, as recommended by the Bot API docs.
+func (MarkdownV2Markup) Close(entity string) string {
+	if entity == "italic" {
+		return "_\r"
+	}
+
+	return markdownV2Tags[entity]
+}
+
+func (m MarkdownV2Markup) Link(label string, url string) string {
+	return fmt.Sprintf("[%s](%s)", m.Escape(label), strings.NewReplacer("\\", "\\\\", ")", "\\)").Replace(url))
+}
+
+func IsFormattingEntity(entity string) bool {
+	_, ok := htmlTags[entity]
+	return ok
+}
+
+// RenderEntities escapes text for the markup and re-applies the user's
+// formatting entities. Offsets are in UTF-16 code units, as sent by Telegram.
+// Entities listed in skip are dropped, e.g. bold inside an already bold title.
+func RenderEntities(markup Markup, text string, entities []models.Entity, skip ...string) string {
+	units := utf16.Encode([]rune(text))
+
+	var active []models.Entity
+
+	for _, entity := range entities {
+		if !IsFormattingEntity(entity.Type) || entity.Length <= 0 || entity.Offset < 0 || entity.Offset+entity.Length > len(units) {
+			continue
+		}
+
+		skipped := false
+
+		for _, s := range skip {
+			skipped = skipped || s == entity.Type
+		}
+
+		if !skipped {
+			active = append(active, entity)
+		}
+	}
+
+	if len(active) == 0 {
+		return markup.Escape(text)
+	}
+
+	sort.SliceStable(active, func(i, j int) bool {
+		if active[i].Offset != active[j].Offset {
+			return active[i].Offset < active[j].Offset
+		}
+
+		return active[i].Length > active[j].Length
+	})
+
+	var (
+		builder strings.Builder
+		stack   []models.Entity
+		next    int
+		start   int
+	)
+
+	flush := func(end int) {
+		if end > start {
+			builder.WriteString(markup.Escape(string(utf16.Decode(units[start:end]))))
+		}
+
+		start = end
+	}
+
+	for position := 0; position <= len(units); position++ {
+		lowest := -1
+
+		for i, entity := range stack {
+			if entity.Offset+entity.Length == position {
+				lowest = i
+				break
+			}
+		}
+
+		if lowest != -1 {
+			flush(position)
+
+			var reopen []models.Entity
+
+			for i := len(stack) - 1; i >= lowest; i-- {
+				builder.WriteString(markup.Close(stack[i].Type))
+			}
+
+			for _, entity := range stack[lowest:] {
+				if entity.Offset+entity.Length != position {
+					reopen = append(reopen, entity)
+				}
+			}
+
+			stack = stack[:lowest]
+
+			for _, entity := range reopen {
+				builder.WriteString(markup.Open(entity.Type))
+				stack = append(stack, entity)
+			}
+		}
+
+		for next < len(active) && active[next].Offset == position {
+			flush(position)
+			builder.WriteString(markup.Open(active[next].Type))
+			stack = append(stack, active[next])
+			next++
+		}
+	}
+
+	flush(len(units))
+
+	return builder.String()
+}
+
+func EntitiesFromMessage(entities []tgbotapi.MessageEntity) []models.Entity {
+	var result []models.Entity
+
+	for _, entity := range entities {
+		if IsFormattingEntity(entity.Type) {
+			result = append(result, models.Entity{Type: entity.Type, Offset: entity.Offset, Length: entity.Length})
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,164 @@
+package formatters
+
+import (
+	"github.com/dustin/go-humanize"
+	"github.com/iokinai/lcltgbot/internal/lcltgbot/models"
+	"math"
+	"strings"
+)
+
+const DefaultLocale = "ru"
+
+type NumberFormat struct {
+	Group       string
+	Decimal     string
+	SymbolFirst bool
+}
+
+var numberFormats = map[string]NumberFormat{
+	"ru": {Group: " ", Decimal: ","},
+	"uk": {Group: " ", Decimal: ","},
+	"be": {Group: " ", Decimal: ","},
+	"kk": {Group: " ", Decimal: ","},
+	"de": {Group: ".", Decimal: ","},
+	"en": {Group: ",", Decimal: ".", SymbolFirst: true},
+}
+
+var currencySymbols = map[string]string{
+	"RUB": "₽",
+	"USD": "$",
+	"EUR": "€",
+	"KZT": "₸",
+	"UAH": "₴",
+	"BYN": "Br",
+	"GEL": "₾",
+	"AMD": "֏",
+}
+
+// Words are the units and price words a locale shows next to the numbers.
+type Words struct {
+	Meters        string
+	Kilometers    string
+	PriceLabels   map[models.PriceType]string
+	PriceSuffixes map[models.PriceType]string
+}
+
+var localeWords = map[string]Words{
+	"ru": {
+		Meters:        "м",
+		Kilometers:    "км",
+		PriceLabels:   map[models.PriceType]string{models.PriceNegotiable: "договорная", models.PriceFree: "бесплатно", models.PriceExchange: "обмен"},
+		PriceSuffixes: map[models.PriceType]string{models.PriceNegotiable: "торг", models.PriceExchange: "возможен обмен"},
+	},
+	"uk": {
+		Meters:        "м",
+		Kilometers:    "км",
+		PriceLabels:   map[models.PriceType]string{models.PriceNegotiable: "договірна", models.PriceFree: "безкоштовно", models.PriceExchange: "обмін"},
+		PriceSuffixes: map[models.PriceType]string{models.PriceNegotiable: "торг", models.PriceExchange: "можливий обмін"},
+	},
+	"be": {
+		Meters:        "м",
+		Kilometers:    "км",
+		PriceLabels:   map[models.PriceType]string{models.PriceNegotiable: "дамоўная", models.PriceFree: "бясплатна", models.PriceExchange: "абмен"},
+		PriceSuffixes: map[models.PriceType]string{models.PriceNegotiable: "торг", models.PriceExchange: "магчымы абмен"},
+	},
+	"kk": {
+		Meters:        "м",
+		Kilometers:    "км",
+		PriceLabels:   map[models.PriceType]string{models.PriceNegotiable: "келісімді", models.PriceFree: "тегін", models.PriceExchange: "айырбас"},
+		PriceSuffixes: map[models.PriceType]string{models.PriceNegotiable: "саудаласу", models.PriceExchange: "айырбас мүмкін"},
+	},
+	"de": {
+		Meters:        "m",
+		Kilometers:    "km",
+		PriceLabels:   map[models.PriceType]string{models.PriceNegotiable: "VB", models.PriceFree: "zu verschenken", models.PriceExchange: "Tausch"},
+		PriceSuffixes: map[models.PriceType]string{models.PriceNegotiable: "VB", models.PriceExchange: "Tausch möglich"},
+	},
+	"en": {
+		Meters:        "m",
+		Kilometers:    "km",
+		PriceLabels:   map[models.PriceType]string{models.PriceNegotiable: "negotiable", models.PriceFree: "free", models.PriceExchange: "swap"},
+		PriceSuffixes: map[models.PriceType]string{models.PriceNegotiable: "negotiable", models.PriceExchange: "swap possible"},
+	},
+}
+
+// LocaleOf maps a Telegram language code like "en-US" to a known number
+// format, falling back to the deployment locale.
+func LocaleOf(language string, fallback string) string {
+	language = strings.ToLower(strings.SplitN(language, "-", 2)[0])
+
+	if _, ok := numberFormats[language]; ok {
+		return language
+	}
+
+	if _, ok := numberFormats[fallback]; ok {
+		return fallback
+	}
+
+	return DefaultLocale
+}
+
+func CurrencySymbol(currency string) string {
+	if symbol, ok := currencySymbols[currency]; ok {
+		return symbol
+	}
+
+	return currency
+}
+
+func WordsOf(locale string) Words {
+	return localeWords[LocaleOf(locale, DefaultLocale)]
+}
+
+func PriceTypeLabel(priceType models.PriceType, locale string) string {
+	return WordsOf(locale).PriceLabels[priceType]
+}
+
+func FormatMoney(amount float64, currency string, locale string) string {
+	format := numberFormats[LocaleOf(locale, DefaultLocale)]
+	pattern := "#" + format.Group + "###."
+
+	if amount != math.Trunc(amount) {
+		pattern = "#" + format.Group + "###" + format.Decimal + "##"
+	}
+
+	number := humanize.FormatFloat(pattern, amount)
+	symbol := CurrencySymbol(currency)
+
+	if format.SymbolFirst && symbol != currency {
+		return symbol + number
+	}
+
+	return number + " " + symbol
+}
+
+// FormatDistance shows meters below a kilometer and one decimal below ten,
+// unless the distance is a whole number of kilometers.
+func FormatDistance(km float64, locale string) string {
+	format := numberFormats[LocaleOf(locale, DefaultLocale)]
+	words := WordsOf(locale)
+
+	switch {
+	case km < 1:
+		return humanize.FormatFloat("#"+format.Group+"###.", math.Max(10, math.Round(km*100)*10)) + " " + words.Meters
+	case km < 10 && math.Round(km*10) != math.Round(km)*10:
+		return humanize.FormatFloat("#"+format.Group+"###"+format.Decimal+"#", km) + " " + words.Kilometers
+	}
+
+	return humanize.FormatFloat("#"+format.Group+"###.", km) + " " + words.Kilometers
+}
+
+func FormatPrice(ad *models.Advertisement, locale string) string {
+	switch ad.PriceType {
+	case models.PriceFree:
+		return PriceTypeLabel(ad.PriceType, locale)
+	case models.PriceNegotiable, models.PriceExchange:
+		if ad.Price <= 0 {
+			return PriceTypeLabel(ad.PriceType, locale)
+		}
+
+		return FormatMoney(ad.Price, ad.Currency, locale) + ", " + WordsOf(locale).PriceSuffixes[ad.PriceType]
+	}
+
+	return FormatMoney(ad.Price, ad.Currency, locale)
+}
@@ -0,0 +1,96 @@
+package formatters
+
+import (
+	"github.com/iokinai/lcltgbot/internal/lcltgbot/models"
+	"testing"
+)
+
+func TestFormatMoney(t *testing.T) {
+	tests := []struct {
+		amount   float64
+		currency string
+		locale   string
+		want     string
+	}{
+		{15000, "RUB", "ru", "15 000 ₽"},
+		{1500.5, "RUB", "ru", "1 500,50 ₽"},
+		{999, "EUR", "de", "999 €"},
+		{1234567, "EUR", "de", "1.234.567 €"},
+		{2500, "USD", "en", "$2,500"},
+		{2500, "CHF", "en", "2,500 CHF"},
+		{100, "KZT", "en-US", "₸100"},
+		{100, "RUB", "fr", "100 ₽"},
+	}
+
+	for _, test := range tests {
+		if got := FormatMoney(test.amount, test.currency, test.locale); got != test.want {
+			t.Errorf("%v %s %s: got %q, want %q", test.amount, test.currency, test.locale, got, test.want)
+		}
+	}
+}
+
+func TestFormatDistance(t *testing.T) {
+	tests := []struct {
+		km     float64
+		locale string
+		want   string
+	}{
+		{0.004, "ru", "10 м"},
+		{0.35, "ru", "350 м"},
+		{1, "ru", "1 км"},
+		{2.5, "ru", "2,5 км"},
+		{2.5, "en", "2.5 km"},
+		{1234, "de", "1.234 km"},
+	}
+
+	for _, test := range tests {
+		if got := FormatDistance(test.km, test.locale); got != test.want {
+			t.Errorf("%v %s: got %q, want %q", test.km, test.locale, got, test.want)
+		}
+	}
+}
+
+func TestFormatPrice(t *testing.T) {
+	ad := func(price float64, priceType models.PriceType) *models.Advertisement {
+		return &models.Advertisement{Price: price, Currency: "RUB", PriceType: priceType}
+	}
+
+	tests := []struct {
+		name   string
+		ad     *models.Advertisement
+		locale string
+		want   string
+	}{
+		{"fixed", ad(500, models.PriceFixed), "ru", "500 ₽"},
+		{"free", ad(0, models.PriceFree), "ru", "бесплатно"},
+		{"free in english", ad(0, models.PriceFree), "en", "free"},
+		{"negotiable", ad(500, models.PriceNegotiable), "ru", "500 ₽, торг"},
+		{"negotiable without price", ad(0, models.PriceNegotiable), "ru", "договорная"},
+		{"exchange", ad(500, models.PriceExchange), "en", "₽500, swap possible"},
+	}
+
+	for _, test := range tests {
+		if got := FormatPrice(test.ad, test.locale); got != test.want {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestLocaleOf(t *testing.T) {
+	tests := []struct {
+		language string
+		fallback string
+		want     string
+	}{
+		{"en-US", "ru", "en"},
+		{"DE", "ru", "de"},
+		{"fr", "en", "en"},
+		{"", "xx", DefaultLocale},
+	}
+
+	for _, test := range tests {
+		if got := LocaleOf(test.language, test.fallback); got != test.want {
+			t.Errorf("%q, %q: got %q, want %q", test.language, test.fallback, got, test.want)
+		}
+	}
+}
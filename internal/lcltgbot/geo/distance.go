@@ -0,0 +1,47 @@
+package geo
+
+import (
+	"github.com/iokinai/lcltgbot/internal/lcltgbot/models"
+	"math"
+)
+
+const earthRadius = 6371.0
+
+// Distance is the great-circle distance between two points in kilometers.
+func Distance(a models.Location, b models.Location) float64 {
+	lat1, lat2 := radians(a.Latitude), radians(b.Latitude)
+	dlat, dlon := lat2-lat1, radians(b.Longitude-a.Longitude)
+
+	h := math.Sin(dlat/2)*math.Sin(dlat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dlon/2)*math.Sin(dlon/2)
+
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+func radians(degrees float64) float64 {
+	return degrees * math.Pi / 180
+}
+
+type BoundingBox struct {
+	South float64
+	West  float64
+	North float64
+	East  float64
+}
+
+// Around returns a box that contains the circle of radius kilometers, used to
+// prefilter rows in SQL before the exact distance is computed.
+func Around(center models.Location, radius float64) BoundingBox {
+	latDelta := radius / (earthRadius * math.Pi / 180)
+	lonDelta := 180.0
+
+	if cos := math.Cos(radians(center.Latitude)); cos > 0.01 {
+		lonDelta = math.Min(180, latDelta/cos)
+	}
+
+	return BoundingBox{
+		South: math.Max(-90, center.Latitude-latDelta),
+		West:  math.Max(-180, center.Longitude-lonDelta),
+		North: math.Min(90, center.Latitude+latDelta),
+		East:  math.Min(180, center.Longitude+lonDelta),
+	}
+}
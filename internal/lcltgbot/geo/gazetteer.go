@@ -0,0 +1,198 @@
+package geo
+
+import (
+	_ "embed"
+	"encoding/json"
+	"github.com/iokinai/lcltgbot/internal/lcltgbot/models"
+	"sort"
+	"strings"
+)
+
+// NearestCityRadius is how far from a shared location a city is still
+// considered the one the user is in.
+const NearestCityRadius = 50.0
+
+type City struct {
+	Id        string   `json:"id"`
+	Name      string   `json:"name"`
+	Aliases   []string `json:"aliases"`
+	Latitude  float64  `json:"latitude"`
+	Longitude float64  `json:"longitude"`
+}
+
+func (c *City) Location() *models.Location {
+	return &models.Location{Latitude: c.Latitude, Longitude: c.Longitude}
+}
+
+type Gazetteer struct {
+	cities []*City
+	byId   map[string]*City
+	byName map[string]*City
+}
+
+// citiesJSON is built into the binary, so looking up cities needs neither
+// network access nor a particular working directory.
+//
+//go:embed cities.json
+var citiesJSON []byte
+
+func LoadGazetteer() (*Gazetteer, error) {
+	var cities []*City
+
+	if err := json.Unmarshal(citiesJSON, &cities); err != nil {
+		return nil, err
+	}
+
+	return NewGazetteer(cities), nil
+}
+
+func NewGazetteer(cities []*City) *Gazetteer {
+	g := &Gazetteer{cities: cities, byId: map[string]*City{}, byName: map[string]*City{}}
+
+	for _, city := range cities {
+		g.byId[city.Id] = city
+		g.byName[NormalizeName(city.Name)] = city
+
+		for _, alias := range city.Aliases {
+			g.byName[NormalizeName(alias)] = city
+		}
+	}
+
+	return g
+}
+
+var cityPrefixes = []string{"город ", "гор. ", "г. ", "г.", "г "}
+
+// NormalizeName makes "г. Ростов-на-Дону" and "ростов на дону" the same key.
+func NormalizeName(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	name = strings.NewReplacer("ё", "е", "-", " ").Replace(name)
+
+	for _, prefix := range cityPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			name = name[len(prefix):]
+			break
+		}
+	}
+
+	return strings.Join(strings.Fields(name), " ")
+}
+
+func (g *Gazetteer) Get(id string) (*City, bool) {
+	city, ok := g.byId[id]
+	return city, ok
+}
+
+// Match looks the typed name up among names and aliases. An exact hit is
+// returned as city, otherwise suggestions holds up to limit cities whose names
+// start with the text or differ from it by a typo or two.
+func (g *Gazetteer) Match(text string, limit int) (city *City, suggestions []*City) {
+	query := NormalizeName(text)
+
+	if query == "" {
+		return nil, nil
+	}
+
+	if city, ok := g.byName[query]; ok {
+		return city, nil
+	}
+
+	scores := map[*City]int{}
+
+	for name, candidate := range g.byName {
+		score := -1
+
+		if len([]rune(query)) >= 2 && strings.HasPrefix(name, query) {
+			score = 0
+		} else if distance := Levenshtein(name, query); distance <= MaxTypos(query) {
+			score = distance
+		}
+
+		if best, seen := scores[candidate]; score >= 0 && (!seen || score < best) {
+			scores[candidate] = score
+		}
+	}
+
+	for candidate := range scores {
+		suggestions = append(suggestions, candidate)
+	}
+
+	sort.Slice(suggestions, func(i, j int) bool {
+		if scores[suggestions[i]] != scores[suggestions[j]] {
+			return scores[suggestions[i]] < scores[suggestions[j]]
+		}
+
+		return suggestions[i].Name < suggestions[j].Name
+	})
+
+	if len(suggestions) > limit {
+		suggestions = suggestions[:limit]
+	}
+
+	return nil, suggestions
+}
+
+// Nearest returns the closest city within NearestCityRadius kilometers.
+func (g *Gazetteer) Nearest(location models.Location) (*City, bool) {
+	var (
+		nearest *City
+		best    float64
+	)
+
+	for _, city := range g.cities {
+		distance := Distance(location, *city.Location())
+
+		if distance <= NearestCityRadius && (nearest == nil || distance < best) {
+			nearest, best = city, distance
+		}
+	}
+
+	return nearest, nearest != nil
+}
+
+func MaxTypos(query string) int {
+	switch length := len([]rune(query)); {
+	case length < 4:
+		return 0
+	case length < 7:
+		return 1
+	}
+
+	return 2
+}
+
+func Levenshtein(a string, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	previous := make([]int, len(rb)+1)
+	current := make([]int, len(rb)+1)
+
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		current[0] = i
+
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+
+			current[j] = previous[j-1] + cost
+
+			if previous[j]+1 < current[j] {
+				current[j] = previous[j] + 1
+			}
+
+			if current[j-1]+1 < current[j] {
+				current[j] = current[j-1] + 1
+			}
+		}
+
+		previous, current = current, previous
+	}
+
+	return previous[len(rb)]
+}
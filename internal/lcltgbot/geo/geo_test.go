@@ -0,0 +1,122 @@
+package geo
+
+import (
+	"github.com/iokinai/lcltgbot/internal/lcltgbot/models"
+	"math"
+	"testing"
+)
+
+var (
+	moscow = &City{Id: "moscow", Name: "Москва", Aliases: []string{"мск"}, Latitude: 55.7558, Longitude: 37.6173}
+	spb    = &City{Id: "saint-petersburg", Name: "Санкт-Петербург", Aliases: []string{"питер"}, Latitude: 59.9343, Longitude: 30.3351}
+	rostov = &City{Id: "rostov-on-don", Name: "Ростов-на-Дону", Latitude: 47.2357, Longitude: 39.7015}
+	tver   = &City{Id: "tver", Name: "Тверь", Latitude: 56.8587, Longitude: 35.9176}
+)
+
+func TestLevenshtein(t *testing.T) {
+	tests := []struct {
+		a    string
+		b    string
+		want int
+	}{
+		{"", "", 0},
+		{"москва", "москва", 0},
+		{"москва", "масква", 1},
+		{"москва", "моска", 1},
+		{"москва", "мосвка", 2},
+		{"тверь", "", 5},
+		{"", "тверь", 5},
+		{"питер", "петербург", 5},
+	}
+
+	for _, test := range tests {
+		if got := Levenshtein(test.a, test.b); got != test.want {
+			t.Errorf("%q, %q: got %d, want %d", test.a, test.b, got, test.want)
+		}
+	}
+}
+
+func TestNormalizeName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"г. Ростов-на-Дону", "ростов на дону"},
+		{"город  Москва ", "москва"},
+		{"г.Тверь", "тверь"},
+		{"Орёл", "орел"},
+		{"Гатчина", "гатчина"},
+	}
+
+	for _, test := range tests {
+		if got := NormalizeName(test.name); got != test.want {
+			t.Errorf("%q: got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	g := NewGazetteer([]*City{moscow, spb, rostov, tver})
+
+	tests := []struct {
+		text        string
+		city        *City
+		suggestions []*City
+	}{
+		{"Москва", moscow, nil},
+		{"мск", moscow, nil},
+		{"г. Ростов-на-Дону", rostov, nil},
+		{"масква", nil, []*City{moscow}},
+		{"санкт", nil, []*City{spb}},
+		{"твр", nil, nil},
+		{"", nil, nil},
+	}
+
+	for _, test := range tests {
+		city, suggestions := g.Match(test.text, 5)
+
+		if city != test.city || len(suggestions) != len(test.suggestions) {
+			t.Errorf("%q: got %v %v, want %v %v", test.text, city, suggestions, test.city, test.suggestions)
+			continue
+		}
+
+		for i := range suggestions {
+			if suggestions[i] != test.suggestions[i] {
+				t.Errorf("%q: suggestion %d is %s, want %s", test.text, i, suggestions[i].Name, test.suggestions[i].Name)
+			}
+		}
+	}
+}
+
+func TestNearest(t *testing.T) {
+	g := NewGazetteer([]*City{moscow, spb, tver})
+
+	tests := []struct {
+		name     string
+		location models.Location
+		want     *City
+	}{
+		{"city center", models.Location{Latitude: 55.7558, Longitude: 37.6173}, moscow},
+		{"suburb", models.Location{Latitude: 55.9, Longitude: 37.4}, moscow},
+		{"closer to tver", models.Location{Latitude: 56.8, Longitude: 36}, tver},
+		{"far from all", models.Location{Latitude: 47.2357, Longitude: 39.7015}, nil},
+	}
+
+	for _, test := range tests {
+		city, ok := g.Nearest(test.location)
+
+		if city != test.want || ok != (test.want != nil) {
+			t.Errorf("%s: got %v %v, want %v", test.name, city, ok, test.want)
+		}
+	}
+}
+
+func TestDistance(t *testing.T) {
+	if got := Distance(*moscow.Location(), *spb.Location()); math.Abs(got-634) > 5 {
+		t.Errorf("Moscow to Saint Petersburg: got %.0f km, want about 634", got)
+	}
+
+	if got := Distance(*tver.Location(), *tver.Location()); got != 0 {
+		t.Errorf("same point: got %v km, want 0", got)
+	}
+}
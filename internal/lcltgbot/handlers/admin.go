@@ -0,0 +1,602 @@
+package handlers
+
+import (
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/iokinai/lcltgbot/internal/lcltgbot/commands"
+	"github.com/iokinai/lcltgbot/internal/lcltgbot/formatters"
+	"github.com/iokinai/lcltgbot/internal/lcltgbot/models"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const StatsDays = 7
+
+var adminCommands = map[string]bool{
+	commands.StatsCommand:      true,
+	commands.UserCommand:       true,
+	commands.AdCommand:         true,
+	commands.ResetStateCommand: true,
+	commands.BroadcastCommand:  true,
+}
+
+// adminCallbacks are the buttons of the admin console views. They are only
+// sent to admins, but the role is checked again on every press.
+var adminCallbacks = map[string]bool{
+	commands.AdminStatsCommandData:  true,
+	commands.AdminUserCommandData:   true,
+	commands.AdminResetCommandData:  true,
+	commands.AdminBanCommandData:    true,
+	commands.AdminUnbanCommandData:  true,
+	commands.AdminAdsCommandData:    true,
+	commands.AdminAdCommandData:     true,
+	commands.AdminEditCommandData:   true,
+	commands.AdminRemoveCommandData: true,
+
+	commands.BroadcastSendCommandData:    true,
+	commands.BroadcastCancelCommandData:  true,
+	commands.BroadcastNoLinksCommandData: true,
+}
+
+var adminEditStates = map[models.BotState]bool{
+	models.StateWaitingForAdminTitle:       true,
+	models.StateWaitingForAdminDescription: true,
+	models.StateWaitingForAdminPrice:       true,
+}
+
+// IsAdminCommand only accepts the console in private chats, so the views
+// never end up in front of a group.
+func IsAdminCommand(message *tgbotapi.Message) bool {
+	return message.IsCommand() && message.Chat.IsPrivate() && adminCommands["/"+message.Command()]
+}
+
+func (h *Handlers) HandleAdminCommand(user *models.User, message *tgbotapi.Message) error {
+	if h.settings.Role(user.Id) != models.RoleAdmin {
+		return h.SendMessage(user, h.text.AdminOnly)
+	}
+
+	command := "/" + message.Command()
+	args := strings.TrimSpace(message.CommandArguments())
+
+	switch command {
+	case commands.StatsCommand:
+		return h.SendStats(user)
+	case commands.BroadcastCommand:
+		return h.AskForBroadcast(user)
+	}
+
+	if args == "" {
+		return h.SendMessage(user, fmt.Sprintf(h.text.AdminUsage, AdminUsage(command)))
+	}
+
+	switch command {
+	case commands.UserCommand:
+		target, err := h.FindUser(args)
+
+		if err != nil {
+			return h.SendMessage(user, fmt.Sprintf(h.text.UserNotFound, args))
+		}
+
+		return h.SendUserView(user, target)
+	case commands.AdCommand:
+		adid, err := strconv.ParseInt(strings.TrimPrefix(args, "#"), 10, 64)
+
+		if err != nil {
+			return h.SendMessage(user, fmt.Sprintf(h.text.AdminUsage, AdminUsage(command)))
+		}
+
+		return h.SendAdView(user, adid)
+	case commands.ResetStateCommand:
+		target, err := h.FindUser(args)
+
+		if err != nil {
+			return h.SendMessage(user, fmt.Sprintf(h.text.UserNotFound, args))
+		}
+
+		_, err = h.ResetUserState(user, target.Id)
+		return err
+	}
+
+	return nil
+}
+
+func AdminUsage(command string) string {
+	switch command {
+	case commands.UserCommand, commands.ResetStateCommand:
+		return command + " <id|@username>"
+	case commands.AdCommand:
+		return command + " <id>"
+	}
+
+	return command
+}
+
+// FindUser takes a numeric id or a username with or without the "@".
+func (h *Handlers) FindUser(query string) (*models.User, error) {
+	userid, err := strconv.ParseInt(query, 10, 64)
+
+	if err != nil {
+		if userid, err = h.db.GetUserIdByUsername(strings.TrimPrefix(query, "@")); err != nil {
+			return nil, err
+		}
+	}
+
+	return h.db.GetUser(models.PrivateChatKey(userid))
+}
+
+func (h *Handlers) SendStats(user *models.User) error {
+	text, err := h.RenderStats(time.Now())
+
+	if err != nil {
+		return err
+	}
+
+	message := h.NewMessage(user, text)
+	message.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		h.Button(commands.AdminRefreshButton.ParamName, commands.AdminRefreshButton.ParamValue),
+	))
+
+	if _, err := h.bot.Send(message); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (h *Handlers) RefreshStats(message *tgbotapi.Message) error {
+	text, err := h.RenderStats(time.Now())
+
+	if err != nil {
+		return err
+	}
+
+	edit := tgbotapi.NewEditMessageText(message.Chat.ID, message.MessageID, text)
+	edit.ReplyMarkup = message.ReplyMarkup
+
+	if _, err := h.bot.Request(edit); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// RenderStats counts ads per day for the last StatsDays days, today included,
+// in the timezone of the channel.
+func (h *Handlers) RenderStats(now time.Time) (string, error) {
+	location := h.settings.Location()
+	now = now.In(location)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, location)
+	since := today.AddDate(0, 0, 1-StatsDays)
+
+	stats, err := h.db.GetStats(since)
+
+	if err != nil {
+		return "", err
+	}
+
+	var statuses []string
+
+	for status := models.AdStatusScheduled; status <= models.AdStatusPublishing; status++ {
+		statuses = append(statuses, fmt.Sprintf(h.text.AdminStatsDay, h.AdStatusName(status), stats.AdsByStatus[status]))
+	}
+
+	perDay := make([]int, StatsDays)
+
+	for _, created := range stats.Created {
+		if day := int(created.In(location).Sub(since).Hours() / 24); day >= 0 && day < StatsDays {
+			perDay[day]++
+		}
+	}
+
+	var days []string
+
+	for day, count := range perDay {
+		days = append(days, fmt.Sprintf(h.text.AdminStatsDay, since.AddDate(0, 0, day).Format("02.01"), count))
+	}
+
+	text := fmt.Sprintf(
+		h.text.AdminStats,
+		stats.Users, stats.Banned, stats.InFlow, stats.Drafts,
+		strings.Join(statuses, "\n"), strings.Join(days, "\n"),
+		now.Format("15:04:05"),
+	)
+
+	if source, ok := h.bot.(MetricsSource); ok {
+		queue := source.Metrics()
+		text += "\n\n" + fmt.Sprintf(h.text.AdminQueue, queue.Interactive, queue.Bulk, queue.InFlight, queue.Sent, queue.Retried, queue.Failed)
+	}
+
+	return text, nil
+}
+
+func (h *Handlers) AdStatusName(status models.AdStatus) string {
+	if name, ok := h.text.AdStatusNames[status.String()]; ok {
+		return name
+	}
+
+	return status.String()
+}
+
+func (h *Handlers) StateName(state models.BotState) string {
+	if name, ok := h.text.StateNames[state.Name()]; ok {
+		return name
+	}
+
+	return state.Name()
+}
+
+func (h *Handlers) RenderUserView(target *models.User) (string, tgbotapi.InlineKeyboardMarkup, error) {
+	ads, err := h.db.GetUserAds(target.Id)
+
+	if err != nil {
+		return "", tgbotapi.InlineKeyboardMarkup{}, err
+	}
+
+	username := "-"
+
+	if target.Username != "" {
+		username = "@" + target.Username
+	}
+
+	state := h.StateName(models.StateNONE)
+
+	if target.Context.IsInFlow {
+		state = h.StateName(target.Context.State)
+	}
+
+	text := fmt.Sprintf(h.text.AdminUser, target.Id, target.FirstName, username, target.Language, state, len(ads))
+
+	ban := commands.AdminBanButton
+
+	if target.Banned {
+		text += "\n" + h.text.AdminUserBanned
+		ban = commands.AdminUnbanButton
+	}
+
+	markup := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			h.Button(commands.AdminResetButton.ParamName, commands.AdminResetButton.ParamValue, target.Id),
+			h.Button(ban.ParamName, ban.ParamValue, target.Id),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			h.Button(commands.AdminAdsButton.ParamName, commands.AdminAdsButton.ParamValue, target.Id),
+		),
+	)
+
+	return text, markup, nil
+}
+
+func (h *Handlers) SendUserView(user *models.User, target *models.User) error {
+	text, markup, err := h.RenderUserView(target)
+
+	if err != nil {
+		return err
+	}
+
+	message := h.NewMessage(user, text)
+	message.ReplyMarkup = markup
+
+	if _, err := h.bot.Send(message); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (h *Handlers) RefreshUserView(message *tgbotapi.Message, userid int64) error {
+	target, err := h.db.GetUser(models.PrivateChatKey(userid))
+
+	if err != nil {
+		return err
+	}
+
+	text, markup, err := h.RenderUserView(target)
+
+	if err != nil {
+		return err
+	}
+
+	if _, err := h.bot.Request(tgbotapi.NewEditMessageTextAndMarkup(message.Chat.ID, message.MessageID, text, markup)); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// ResetUserState takes the user out of whatever dialog they are stuck in,
+// in every chat, and lets them know they can start over.
+func (h *Handlers) ResetUserState(user *models.User, userid int64) (int64, error) {
+	reset, err := h.db.ResetUserStates(userid)
+
+	if err != nil {
+		return 0, err
+	}
+
+	if reset == 0 {
+		return 0, h.SendMessage(user, fmt.Sprintf(h.text.StateNotStuck, userid))
+	}
+
+	if err := h.SendMessageTo(userid, h.text.StateResetByAdmin); err != nil {
+		return 0, err
+	}
+
+	return reset, h.SendMessage(user, fmt.Sprintf(h.text.StateReset, userid, reset))
+}
+
+func (h *Handlers) SetUserBanned(userid int64, banned bool) error {
+	if banned {
+		return h.BanAuthor(userid)
+	}
+
+	if err := h.db.UnbanUser(userid); err != nil {
+		return err
+	}
+
+	return h.SendMessageTo(userid, h.text.UserUnbanned)
+}
+
+func (h *Handlers) SendUserAds(user *models.User, userid int64) error {
+	ads, err := h.db.GetUserAds(userid)
+
+	if err != nil {
+		return err
+	}
+
+	if len(ads) == 0 {
+		return h.SendMessage(user, h.text.AdminNoUserAds)
+	}
+
+	var rows [][]tgbotapi.InlineKeyboardButton
+
+	for _, ad := range ads {
+		label := fmt.Sprintf("#%d %s · %s", ad.Id, formatters.Truncate(ad.Title, 24), h.AdStatusName(ad.Status))
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(h.Button(label, commands.AdminAdCommandData, ad.Id)))
+	}
+
+	message := h.NewMessage(user, fmt.Sprintf(h.text.AdminUserAds, userid))
+	message.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(rows...)
+
+	if _, err := h.bot.Send(message); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (h *Handlers) RenderAdView(ad *models.Advertisement, locale string) (string, tgbotapi.InlineKeyboardMarkup, error) {
+	summary, err := h.render.Summary(ad, locale)
+
+	if err != nil {
+		return "", tgbotapi.InlineKeyboardMarkup{}, err
+	}
+
+	owner := ""
+
+	if author, err := h.db.GetUser(models.PrivateChatKey(ad.OwnerId)); err == nil && author.Username != "" {
+		owner = "@" + author.Username
+	}
+
+	text := fmt.Sprintf("%s %s\n\n%s", h.render.Escape(fmt.Sprintf("#%d", ad.Id)), summary, h.render.Escape(fmt.Sprintf(h.text.AdminAd, ad.OwnerId, owner)))
+
+	var rows [][]tgbotapi.InlineKeyboardButton
+
+	if IsAdminEditable(ad) {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			h.Button(commands.AdminTitleButton.ParamName, commands.AdminEditCommandData, ad.Id, models.StateWaitingForAdminTitle),
+			h.Button(commands.AdminDescriptionButton.ParamName, commands.AdminEditCommandData, ad.Id, models.StateWaitingForAdminDescription),
+		), tgbotapi.NewInlineKeyboardRow(
+			h.Button(commands.AdminPriceButton.ParamName, commands.AdminEditCommandData, ad.Id, models.StateWaitingForAdminPrice),
+		))
+	}
+
+	last := tgbotapi.NewInlineKeyboardRow(h.Button(commands.AdminAuthorButton.ParamName, commands.AdminAuthorButton.ParamValue, ad.OwnerId))
+
+	if ad.Status != models.AdStatusRemoved {
+		last = append(last, h.Button(commands.AdminRemoveButton.ParamName, commands.AdminRemoveButton.ParamValue, ad.Id))
+	}
+
+	return text, tgbotapi.NewInlineKeyboardMarkup(append(rows, last)...), nil
+}
+
+// IsAdminEditable is true for ads that are still going to be seen: finished
+// ads keep the text they had when they were closed.
+func IsAdminEditable(ad *models.Advertisement) bool {
+	switch ad.Status {
+	case models.AdStatusScheduled, models.AdStatusPublished, models.AdStatusHidden, models.AdStatusPending:
+		return true
+	}
+
+	return false
+}
+
+func (h *Handlers) SendAdView(user *models.User, adid int64) error {
+	ad, err := h.db.GetPublishedAd(adid)
+
+	if err != nil {
+		return h.SendMessage(user, h.text.AdNotFound)
+	}
+
+	text, markup, err := h.RenderAdView(ad, h.render.Locale(user))
+
+	if err != nil {
+		return err
+	}
+
+	message := h.NewMessage(user, text)
+	message.ParseMode = h.render.ParseMode()
+	message.ReplyMarkup = markup
+
+	if _, err := h.bot.Send(message); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (h *Handlers) RefreshAdView(user *models.User, message *tgbotapi.Message, ad *models.Advertisement) error {
+	text, markup, err := h.RenderAdView(ad, h.render.Locale(user))
+
+	if err != nil {
+		return err
+	}
+
+	edit := tgbotapi.NewEditMessageTextAndMarkup(message.Chat.ID, message.MessageID, text, markup)
+	edit.ParseMode = h.render.ParseMode()
+
+	if _, err := h.bot.Request(edit); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (h *Handlers) ForceRemoveAd(user *models.User, message *tgbotapi.Message, adid int64) error {
+	ad, err := h.db.GetPublishedAd(adid)
+
+	if err != nil {
+		return err
+	}
+
+	if ad.Status != models.AdStatusRemoved {
+		if err := h.RemoveAd(ad); err != nil {
+			return err
+		}
+	}
+
+	return h.RefreshAdView(user, message, ad)
+}
+
+func (h *Handlers) AskForAdminEdit(user *models.User, adid int64, state models.BotState) error {
+	if !adminEditStates[state] {
+		return commands.ErrBadCallback
+	}
+
+	if user.Context.IsInFlow {
+		return h.SendMessage(user, fmt.Sprintf(h.text.InChainError, commands.CancelFlow))
+	}
+
+	ad, err := h.db.GetPublishedAd(adid)
+
+	if err != nil || !IsAdminEditable(ad) {
+		return h.SendMessage(user, h.text.AdNotFound)
+	}
+
+	if user, err = h.db.ChangeTargetAd(user, ad.Id); err != nil {
+		return err
+	}
+
+	if user, err = h.db.ChangeUserState(user, state); err != nil {
+		return err
+	}
+
+	return h.SendMessage(user, fmt.Sprintf(h.text.AskAdminEdit, ad.Id, ad.Title))
+}
+
+// HandleAdminEditInput changes a published or pending ad without the checks
+// an author goes through, updates the channel post and tells the author.
+func (h *Handlers) HandleAdminEditInput(user *models.User, message *tgbotapi.Message) error {
+	ad, err := h.db.GetPublishedAd(user.Context.TargetAdId)
+
+	if err != nil || !IsAdminEditable(ad) || h.settings.Role(user.Id) != models.RoleAdmin {
+		_, err = h.DropUserState(user)
+		return err
+	}
+
+	entities := formatters.EntitiesFromMessage(message.Entities)
+
+	switch user.Context.State {
+	case models.StateWaitingForAdminTitle:
+		err = h.db.UpdateAdTitle(ad, message.Text, entities)
+	case models.StateWaitingForAdminDescription:
+		err = h.db.UpdateAdDescription(ad, message.Text, entities)
+	case models.StateWaitingForAdminPrice:
+		price, ok := ParsePrice(message.Text)
+
+		if !ok {
+			return h.SendMessage(user, h.text.WrongPrice)
+		}
+
+		err = h.db.UpdateAdPrice(ad, price)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if ad.Status == models.AdStatusPublished {
+		if err := h.UpdateChannelPost(ad); err != nil {
+			return err
+		}
+	}
+
+	if user, err = h.DropUserState(user); err != nil {
+		return err
+	}
+
+	if err := h.SendMessageTo(ad.OwnerId, fmt.Sprintf(h.text.AdEditedByAdmin, ad.Title)); err != nil {
+		return err
+	}
+
+	return h.SendMessage(user, fmt.Sprintf(h.text.AdminEditDone, ad.Id))
+}
+
+func (h *Handlers) RouteAdminCallback(user *models.User, message *tgbotapi.Message, payload commands.Payload) error {
+	if h.settings.Role(user.Id) != models.RoleAdmin {
+		return h.SendMessage(user, h.text.AdminOnly)
+	}
+
+	switch payload.Action {
+	case commands.AdminStatsCommandData:
+		return h.RefreshStats(message)
+	case commands.BroadcastSendCommandData, commands.BroadcastCancelCommandData, commands.BroadcastNoLinksCommandData:
+		return h.RouteBroadcastCallback(user, message, payload)
+	}
+
+	id, err := payload.Int(0)
+
+	if err != nil {
+		return err
+	}
+
+	switch payload.Action {
+	case commands.AdminUserCommandData:
+		target, err := h.db.GetUser(models.PrivateChatKey(id))
+
+		if err != nil {
+			return h.SendMessage(user, fmt.Sprintf(h.text.UserNotFound, strconv.FormatInt(id, 10)))
+		}
+
+		return h.SendUserView(user, target)
+	case commands.AdminResetCommandData:
+		reset, err := h.ResetUserState(user, id)
+
+		if err != nil || reset == 0 {
+			return err
+		}
+
+		return h.RefreshUserView(message, id)
+	case commands.AdminBanCommandData, commands.AdminUnbanCommandData:
+		if err := h.SetUserBanned(id, payload.Action == commands.AdminBanCommandData); err != nil {
+			return err
+		}
+
+		return h.RefreshUserView(message, id)
+	case commands.AdminAdsCommandData:
+		return h.SendUserAds(user, id)
+	case commands.AdminAdCommandData:
+		return h.SendAdView(user, id)
+	case commands.AdminEditCommandData:
+		state, err := payload.Int(1)
+
+		if err != nil {
+			return err
+		}
+
+		return h.AskForAdminEdit(user, id, models.BotState(state))
+	case commands.AdminRemoveCommandData:
+		return h.ForceRemoveAd(user, message, id)
+	}
+
+	return nil
+}
@@ -0,0 +1,306 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/iokinai/lcltgbot/internal/lcltgbot/commands"
+	"github.com/iokinai/lcltgbot/internal/lcltgbot/models"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	BroadcastBatch     = 100
+	BroadcastLinkLimit = 8
+)
+
+// MaxDeliveryAttempts caps how often a delivery that keeps hitting flood
+// waits is tried before it counts as failed, so a broadcast always finishes.
+const MaxDeliveryAttempts = 3
+
+func (h *Handlers) AskForBroadcast(user *models.User) error {
+	if _, err := h.db.ChangeUserState(user, models.StateWaitingForBroadcast); err != nil {
+		return err
+	}
+
+	return h.SendMessage(user, fmt.Sprintf(h.text.AskBroadcast, commands.CancelFlow))
+}
+
+// HandleBroadcastInput keeps a reference to the admin's message, it is copied
+// to the users later as it is.
+func (h *Handlers) HandleBroadcastInput(user *models.User, message *tgbotapi.Message) error {
+	if message.Text == "" && message.Photo == nil {
+		return h.SendMessage(user, fmt.Sprintf(h.text.AskBroadcast, commands.CancelFlow))
+	}
+
+	broadcast := &models.Broadcast{
+		CreatedBy:     user.Id,
+		FromChatId:    message.Chat.ID,
+		FromMessageId: message.MessageID,
+		CreatedAt:     time.Now(),
+	}
+
+	if err := h.db.CreateBroadcast(broadcast); err != nil {
+		return err
+	}
+
+	if _, err := h.db.ChangeUserState(user, models.StateWaitingForBroadcastButtons); err != nil {
+		return err
+	}
+
+	prompt := h.NewMessage(user, h.text.AskBroadcastLinks)
+	prompt.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		h.Button(commands.BroadcastNoLinksButton.ParamName, commands.BroadcastNoLinksButton.ParamValue, broadcast.Id),
+	))
+
+	if _, err := h.bot.Send(prompt); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (h *Handlers) HandleBroadcastLinksInput(user *models.User, text string) error {
+	links, bad := ParseLinkButtons(text)
+
+	if bad != "" {
+		return h.SendMessage(user, fmt.Sprintf(h.text.BadBroadcastLink, bad))
+	}
+
+	broadcast, err := h.db.GetDraftBroadcast(user.Id)
+
+	if err != nil {
+		_, err = h.DropUserState(user)
+		return err
+	}
+
+	return h.PreviewBroadcast(user, broadcast, links)
+}
+
+// ParseLinkButtons reads one "Text - https://..." button per line and
+// returns the first line it could not read.
+func ParseLinkButtons(text string) ([]models.LinkButton, string) {
+	var links []models.LinkButton
+
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+
+		if line == "" {
+			continue
+		}
+
+		label, link, found := strings.Cut(line, " - ")
+		label, link = strings.TrimSpace(label), strings.TrimSpace(link)
+
+		if !found || label == "" || !IsButtonURL(link) || len(links) == BroadcastLinkLimit {
+			return nil, line
+		}
+
+		links = append(links, models.LinkButton{Text: label, URL: link})
+	}
+
+	return links, ""
+}
+
+func IsButtonURL(link string) bool {
+	parsed, err := url.Parse(link)
+
+	if err != nil || parsed.Host == "" {
+		return false
+	}
+
+	return parsed.Scheme == "https" || parsed.Scheme == "http" || parsed.Scheme == "tg"
+}
+
+// PreviewBroadcast shows the admin exactly what the users will get, followed
+// by the buttons that start or drop the broadcast.
+func (h *Handlers) PreviewBroadcast(user *models.User, broadcast *models.Broadcast, links []models.LinkButton) error {
+	if err := h.db.SetBroadcastButtons(broadcast, links); err != nil {
+		return err
+	}
+
+	if _, err := h.DropUserState(user); err != nil {
+		return err
+	}
+
+	if _, err := h.bot.Request(h.BroadcastCopy(broadcast, user.Chatid)); err != nil {
+		return err
+	}
+
+	confirm := h.NewMessage(user, h.text.BroadcastConfirm)
+	confirm.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		h.Button(commands.BroadcastSendButton.ParamName, commands.BroadcastSendButton.ParamValue, broadcast.Id),
+		h.Button(commands.BroadcastCancelButton.ParamName, commands.BroadcastCancelButton.ParamValue, broadcast.Id),
+	))
+
+	if _, err := h.bot.Send(confirm); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (h *Handlers) BroadcastCopy(broadcast *models.Broadcast, chatid int64) tgbotapi.CopyMessageConfig {
+	copied := tgbotapi.NewCopyMessage(chatid, broadcast.FromChatId, broadcast.FromMessageId)
+
+	if len(broadcast.Buttons) > 0 {
+		var rows [][]tgbotapi.InlineKeyboardButton
+
+		for _, link := range broadcast.Buttons {
+			rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonURL(link.Text, link.URL)))
+		}
+
+		copied.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(rows...)
+	}
+
+	return copied
+}
+
+func (h *Handlers) RouteBroadcastCallback(user *models.User, message *tgbotapi.Message, payload commands.Payload) error {
+	id, err := payload.Int(0)
+
+	if err != nil {
+		return err
+	}
+
+	broadcast, err := h.db.GetBroadcast(id)
+
+	if err != nil {
+		return err
+	}
+
+	switch payload.Action {
+	case commands.BroadcastNoLinksCommandData:
+		if broadcast.Status != models.BroadcastDraft || !user.Context.IsInFlow || user.Context.State != models.StateWaitingForBroadcastButtons {
+			return h.RemoveInlineKeyboard(message)
+		}
+
+		if err := h.RemoveInlineKeyboard(message); err != nil {
+			return err
+		}
+
+		return h.PreviewBroadcast(user, broadcast, nil)
+	case commands.BroadcastSendCommandData:
+		if err := h.RemoveInlineKeyboard(message); err != nil {
+			return err
+		}
+
+		queued, started, err := h.db.StartBroadcast(broadcast, time.Now())
+
+		if err != nil || !started {
+			return err
+		}
+
+		return h.SendMessage(user, fmt.Sprintf(h.text.BroadcastStarted, broadcast.Id, queued))
+	case commands.BroadcastCancelCommandData:
+		if err := h.RemoveInlineKeyboard(message); err != nil {
+			return err
+		}
+
+		canceled, err := h.db.CancelBroadcast(broadcast)
+
+		if err != nil || !canceled {
+			return err
+		}
+
+		return h.SendMessage(user, h.text.BroadcastCanceled)
+	}
+
+	return nil
+}
+
+// DeliverBroadcasts sends the queued messages of running broadcasts one by
+// one, as fast as the bulk queue lets them out. Every delivery is recorded as
+// it happens, so after a restart the next run picks up the users that are
+// still pending.
+func (h *Handlers) DeliverBroadcasts(now time.Time) error {
+	broadcasts, err := h.db.GetSendingBroadcasts()
+
+	if err != nil {
+		return err
+	}
+
+	for _, broadcast := range broadcasts {
+		if err := h.DeliverBroadcast(broadcast); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (h *Handlers) DeliverBroadcast(broadcast *models.Broadcast) error {
+	for {
+		deliveries, err := h.db.GetPendingDeliveries(broadcast.Id, BroadcastBatch)
+
+		if err != nil {
+			return err
+		}
+
+		if len(deliveries) == 0 {
+			break
+		}
+
+		for _, delivery := range deliveries {
+			if err := h.Deliver(broadcast, delivery); err != nil {
+				return err
+			}
+		}
+	}
+
+	counts, err := h.db.CountDeliveries(broadcast.Id)
+
+	if err != nil {
+		return err
+	}
+
+	if err := h.db.FinishBroadcast(broadcast, time.Now()); err != nil {
+		return err
+	}
+
+	return h.SendMessageTo(broadcast.CreatedBy, fmt.Sprintf(
+		h.text.BroadcastDone,
+		broadcast.Id, counts.Sent, counts.Failed+counts.Blocked, counts.Blocked,
+	))
+}
+
+// Deliver sends the broadcast to one user through the bulk queue, so replies
+// to users go first. A user who blocked the bot is marked inactive, a flood
+// wait the queue gave up on leaves the delivery pending for the next batch
+// until MaxDeliveryAttempts, and a network failure stops the run until the
+// next tick.
+func (h *Handlers) Deliver(broadcast *models.Broadcast, delivery *models.Delivery) error {
+	_, err := h.bulk.Request(h.BroadcastCopy(broadcast, delivery.ChatId))
+
+	if err == nil {
+		return h.db.MarkDelivery(delivery, models.DeliverySent, "", time.Now())
+	}
+
+	var apiErr *tgbotapi.Error
+
+	if !errors.As(err, &apiErr) {
+		return err
+	}
+
+	if apiErr.RetryAfter > 0 && delivery.Attempts+1 < MaxDeliveryAttempts {
+		if err := h.db.RetryDelivery(delivery); err != nil {
+			return err
+		}
+
+		time.Sleep(time.Duration(apiErr.RetryAfter) * time.Second)
+		return nil
+	}
+
+	if apiErr.Code == http.StatusForbidden {
+		if err := h.db.SetUserInactive(delivery.UserId, true, time.Now()); err != nil {
+			return err
+		}
+
+		return h.db.MarkDelivery(delivery, models.DeliveryBlocked, apiErr.Message, time.Now())
+	}
+
+	return h.db.MarkDelivery(delivery, models.DeliveryFailed, apiErr.Message, time.Now())
+}
@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/iokinai/lcltgbot/internal/lcltgbot/commands"
+	"github.com/iokinai/lcltgbot/internal/lcltgbot/models"
+	"log"
+	"time"
+)
+
+// CallbackPayloadLifetime is how long buttons with a stored payload keep
+// working. Older ones are reported as outdated when pressed.
+const CallbackPayloadLifetime = 30 * 24 * time.Hour
+
+// draftCommands act on the user's current draft, their last argument is the
+// DraftTag of the draft the buttons were made for.
+var draftCommands = map[string]bool{
+	commands.SendButtonPair.ParamValue.(string): true,
+	commands.ScheduleCommandData:                true,
+	commands.SchedulePresetCommandData:          true,
+	commands.TruncateAdCommandData:              true,
+	commands.ChangeValueCommandData:             true,
+	commands.SendToReviewCommandData:            true,
+	commands.CategoryCommandData:                true,
+	commands.PickCategoryCommandData:            true,
+	commands.LifetimeCommandData:                true,
+	commands.PickLifetimeCommandData:            true,
+}
+
+// HandleCallbackQuery routes the query and always answers it, otherwise the
+// client keeps a spinner on the button for several seconds.
+func (h *Handlers) HandleCallbackQuery(query *tgbotapi.CallbackQuery, threadid int64) error {
+	answer := tgbotapi.NewCallback(query.ID, "")
+
+	err := h.RouteCallbackQuery(query, threadid, &answer)
+
+	if err != nil {
+		answer.Text = h.text.ActionFailed
+	}
+
+	if _, err := h.bot.Request(answer); err != nil {
+		log.Println(err)
+	}
+
+	return err
+}
+
+// DraftTag identifies a draft together with its revision, which grows with
+// every change and with every ad saved from it.
+func DraftTag(ad *models.Advertisement) string {
+	return fmt.Sprintf("%d.%d", ad.Id, ad.Revision)
+}
+
+// editableStates are the draft fields the change buttons of a preview may
+// jump back to.
+var editableStates = map[models.BotState]bool{
+	models.StateWaitingForCTitle:       true,
+	models.StateWaitingForCDescription: true,
+	models.StateWaitingForCPrice:       true,
+	models.StateWaitingForCCity:        true,
+}
+
+func IsCurrentDraft(user *models.User, payload commands.Payload) bool {
+	if user.Context == nil || user.Context.Advertisement == nil {
+		return false
+	}
+
+	return payload.Last() == DraftTag(user.Context.Advertisement)
+}
+
+// Button signs the action and its arguments into the callback data. Should
+// that fail the button still goes out, but pressing it only reports that it
+// is outdated.
+func (h *Handlers) Button(label string, action any, args ...any) tgbotapi.InlineKeyboardButton {
+	data, err := h.codec.Encode(commands.NewPayload(fmt.Sprint(action), args...))
+
+	if err != nil {
+		log.Println(err)
+		data = "-"
+	}
+
+	return tgbotapi.NewInlineKeyboardButtonData(label, data)
+}
+
+// DropStaleKeyboard removes buttons that no longer do anything and tells the
+// user why nothing happened.
+func (h *Handlers) DropStaleKeyboard(message *tgbotapi.Message, answer *tgbotapi.CallbackConfig) error {
+	answer.Text = h.text.StaleButton
+
+	return h.RemoveInlineKeyboard(message)
+}
+
+func (h *Handlers) PurgeCallbackPayloads(now time.Time) error {
+	_, err := h.db.DeleteCallbackPayloads(now.Add(-CallbackPayloadLifetime))
+
+	return err
+}
@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/iokinai/lcltgbot/internal/lcltgbot/commands"
+	"github.com/iokinai/lcltgbot/internal/lcltgbot/models"
+	"time"
+)
+
+// AdLifetime is how long the ad stays in the channel: as long as its owner
+// picked, otherwise as long as its category allows. Zero means it never
+// expires.
+func (h *Handlers) AdLifetime(ad *models.Advertisement) time.Duration {
+	if ad.LifetimeDays > 0 {
+		return time.Duration(ad.LifetimeDays) * 24 * time.Hour
+	}
+
+	return h.settings.CategoryLifetime(ad.Category)
+}
+
+// GetDraftSettingsMarkup is the preview row with the category and lifetime
+// buttons, the category one only when categories are configured.
+func (h *Handlers) GetDraftSettingsMarkup(ad *models.Advertisement) []tgbotapi.InlineKeyboardButton {
+	var row []tgbotapi.InlineKeyboardButton
+
+	if len(h.settings.Categories) > 0 {
+		label := commands.CategoryButton.ParamName
+
+		if ad.Category != "" {
+			label += ": " + ad.Category
+		}
+
+		row = append(row, h.Button(label, commands.CategoryButton.ParamValue, DraftTag(ad)))
+	}
+
+	label := commands.LifetimeButton.ParamName
+
+	if lifetime := h.AdLifetime(ad); lifetime > 0 {
+		label += ": " + fmt.Sprintf(h.text.LifetimeDays, lifetime/(24*time.Hour))
+	}
+
+	return append(row, h.Button(label, commands.LifetimeButton.ParamValue, DraftTag(ad)))
+}
+
+func (h *Handlers) AskForCategory(user *models.User) error {
+	ad := user.Context.Advertisement
+
+	var rows [][]tgbotapi.InlineKeyboardButton
+
+	for _, category := range h.settings.Categories {
+		label := category.Name
+
+		if category.Name == ad.Category {
+			label = "✓ " + label
+		}
+
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(h.Button(label, commands.PickCategoryCommandData, category.Name, DraftTag(ad))))
+	}
+
+	message := h.NewMessage(user, h.text.ChooseCategory)
+	message.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(rows...)
+
+	if _, err := h.bot.Send(message); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// ChooseCategory files the draft under the category. A lifetime the owner
+// picked earlier is dropped if the new category doesn't allow it.
+func (h *Handlers) ChooseCategory(user *models.User, message *tgbotapi.Message, name string) error {
+	if _, ok := h.settings.Category(name); !ok {
+		return nil
+	}
+
+	user, err := h.db.ChangeAdCategory(user, name)
+
+	if err != nil {
+		return err
+	}
+
+	if !h.IsLifetimeAllowed(user.Context.Advertisement, user.Context.Advertisement.LifetimeDays) {
+		if user, err = h.db.ChangeAdLifetime(user, 0); err != nil {
+			return err
+		}
+	}
+
+	if err := h.RemoveInlineKeyboard(message); err != nil {
+		return err
+	}
+
+	return h.SendPreview(user)
+}
+
+func (h *Handlers) AskForLifetime(user *models.User) error {
+	ad := user.Context.Advertisement
+
+	var buttons []tgbotapi.InlineKeyboardButton
+
+	for _, days := range commands.LifetimeDays {
+		if !h.IsLifetimeAllowed(ad, days) {
+			continue
+		}
+
+		label := fmt.Sprintf(h.text.LifetimeDays, days)
+
+		if days == ad.LifetimeDays {
+			label = "✓ " + label
+		}
+
+		buttons = append(buttons, h.Button(label, commands.PickLifetimeCommandData, days, DraftTag(ad)))
+	}
+
+	label := commands.DefaultLifetimeButton.ParamName
+
+	if ad.LifetimeDays == 0 {
+		label = "✓ " + label
+	}
+
+	message := h.NewMessage(user, h.text.ChooseLifetime)
+	message.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+		buttons,
+		tgbotapi.NewInlineKeyboardRow(h.Button(label, commands.DefaultLifetimeButton.ParamValue, 0, DraftTag(ad))),
+	)
+
+	if _, err := h.bot.Send(message); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// IsLifetimeAllowed reports whether the owner may keep the ad in the channel
+// for days: owners can shorten the category lifetime, not extend it. Zero
+// stands for the category lifetime and is always allowed.
+func (h *Handlers) IsLifetimeAllowed(ad *models.Advertisement, days int) bool {
+	if days < 0 {
+		return false
+	}
+
+	limit := h.settings.CategoryLifetime(ad.Category)
+
+	return limit == 0 || time.Duration(days)*24*time.Hour <= limit
+}
+
+func (h *Handlers) ChooseLifetime(user *models.User, message *tgbotapi.Message, days int) error {
+	if !h.IsLifetimeAllowed(user.Context.Advertisement, days) {
+		return nil
+	}
+
+	user, err := h.db.ChangeAdLifetime(user, days)
+
+	if err != nil {
+		return err
+	}
+
+	if err := h.RemoveInlineKeyboard(message); err != nil {
+		return err
+	}
+
+	return h.SendPreview(user)
+}
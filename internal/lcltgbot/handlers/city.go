@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/iokinai/lcltgbot/internal/lcltgbot/commands"
+	"github.com/iokinai/lcltgbot/internal/lcltgbot/models"
+)
+
+const CitySuggestionsLimit = 5
+
+// HandleCityInput normalizes the city step. A known name or a shared location
+// is stored with the city id right away, an unknown name is kept as typed and
+// the closest gazetteer entries are offered as buttons.
+func (h *Handlers) HandleCityInput(user *models.User, message *tgbotapi.Message) error {
+	if message.Location != nil {
+		location := models.Location{Latitude: message.Location.Latitude, Longitude: message.Location.Longitude}
+		city, ok := h.cities.Nearest(location)
+
+		if !ok {
+			return h.SendMessage(user, h.text.CityNotDetected)
+		}
+
+		user, err := h.db.ChangeAdPlace(user, city.Name, city.Id, &location)
+
+		if err != nil {
+			return err
+		}
+
+		return h.FinishCityStep(user)
+	}
+
+	city, suggestions := h.cities.Match(message.Text, CitySuggestionsLimit)
+
+	if city != nil {
+		user, err := h.db.ChangeAdPlace(user, city.Name, city.Id, city.Location())
+
+		if err != nil {
+			return err
+		}
+
+		return h.FinishCityStep(user)
+	}
+
+	user, err := h.db.ChangeAdPlace(user, message.Text, "", nil)
+
+	if err != nil {
+		return err
+	}
+
+	if len(suggestions) == 0 {
+		return h.FinishCityStep(user)
+	}
+
+	var rows [][]tgbotapi.InlineKeyboardButton
+
+	for _, suggestion := range suggestions {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			h.Button(suggestion.Name, commands.PickCityCommandData, suggestion.Id),
+		))
+	}
+
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		h.Button(fmt.Sprintf(commands.KeepCityButton.ParamName, message.Text), commands.KeepCityButton.ParamValue),
+	))
+
+	reply := h.NewMessage(user, h.text.ChooseCity)
+	reply.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(rows...)
+
+	if _, err := h.bot.Send(reply); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (h *Handlers) ChooseCity(user *models.User, message *tgbotapi.Message, cityid string) error {
+	if user.Context.State != models.StateWaitingForCCity {
+		return nil
+	}
+
+	city, ok := h.cities.Get(cityid)
+
+	if !ok {
+		return nil
+	}
+
+	user, err := h.db.ChangeAdPlace(user, city.Name, city.Id, city.Location())
+
+	if err != nil {
+		return err
+	}
+
+	if err := h.RemoveInlineKeyboard(message); err != nil {
+		return err
+	}
+
+	return h.FinishCityStep(user)
+}
+
+func (h *Handlers) KeepTypedCity(user *models.User, message *tgbotapi.Message) error {
+	if user.Context.State != models.StateWaitingForCCity {
+		return nil
+	}
+
+	if err := h.RemoveInlineKeyboard(message); err != nil {
+		return err
+	}
+
+	return h.FinishCityStep(user)
+}
+
+func (h *Handlers) FinishCityStep(user *models.User) error {
+	confirmation := h.NewMessage(user, fmt.Sprintf(h.text.CityChosen, user.Context.Advertisement.City))
+	confirmation.ReplyMarkup = tgbotapi.NewRemoveKeyboard(false)
+
+	if _, err := h.bot.Send(confirmation); err != nil {
+		return err
+	}
+
+	if !user.Context.Advertisement.Editing {
+		if err := h.SendPreview(user); err != nil {
+			return err
+		}
+	}
+
+	if _, err := h.GoNextIfCreatingElseDropEditing(
+		user,
+		models.StateNONE,
+		h.text.AdPreview,
+	); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (h *Handlers) GetLocationMarkup() tgbotapi.ReplyKeyboardMarkup {
+	markup := tgbotapi.NewReplyKeyboard(tgbotapi.NewKeyboardButtonRow(
+		tgbotapi.NewKeyboardButtonLocation(commands.ShareLocationButton),
+	))
+	markup.OneTimeKeyboard = true
+
+	return markup
+}
+
+func (h *Handlers) RemoveInlineKeyboard(message *tgbotapi.Message) error {
+	empty := tgbotapi.InlineKeyboardMarkup{InlineKeyboard: [][]tgbotapi.InlineKeyboardButton{}}
+
+	if _, err := h.bot.Request(tgbotapi.NewEditMessageReplyMarkup(message.Chat.ID, message.MessageID, empty)); err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,226 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/iokinai/lcltgbot/internal/lcltgbot/commands"
+	"github.com/iokinai/lcltgbot/internal/lcltgbot/formatters"
+	"github.com/iokinai/lcltgbot/internal/lcltgbot/models"
+	"github.com/iokinai/lcltgbot/internal/lcltgbot/similarity"
+	"time"
+)
+
+const BumpCooldown = 24 * time.Hour
+
+func Similarity(a *models.Advertisement, b *models.Advertisement) float64 {
+	return similarity.NewFingerprint(a.Title, a.Description).Similarity(similarity.NewFingerprint(b.Title, b.Description))
+}
+
+// FindSimilarAd returns the recent ad closest to the user's draft. On equal
+// scores the user's own ad wins, since that is the one they can bump.
+func (h *Handlers) FindSimilarAd(user *models.User) (*models.Advertisement, float64, error) {
+	ads, err := h.db.GetRecentAds(time.Now().Add(-h.settings.DuplicateWindow()))
+
+	if err != nil {
+		return nil, 0, err
+	}
+
+	draft := user.Context.Advertisement
+	fingerprint := similarity.NewFingerprint(draft.Title, draft.Description)
+
+	var (
+		similar *models.Advertisement
+		best    float64
+	)
+
+	for _, ad := range ads {
+		score := fingerprint.Similarity(similarity.NewFingerprint(ad.Title, ad.Description))
+
+		if score > best || (score == best && similar != nil && ad.OwnerId == user.Id && similar.OwnerId != user.Id) {
+			similar, best = ad, score
+		}
+	}
+
+	return similar, best, nil
+}
+
+// CheckDuplicates stops the draft from being saved when it repeats a recent
+// ad. Exact copies are refused, a near copy of the user's own published ad
+// offers to bump it and any other near copy goes to the admins for review.
+func (h *Handlers) CheckDuplicates(user *models.User, publishAt time.Time) (bool, error) {
+	similar, score, err := h.FindSimilarAd(user)
+
+	if err != nil {
+		return false, err
+	}
+
+	if similar == nil || score < similarity.NearDuplicate {
+		return true, nil
+	}
+
+	draft := user.Context.Advertisement
+	exact := similarity.NewFingerprint(draft.Title, draft.Description).IsExact(similarity.NewFingerprint(similar.Title, similar.Description))
+
+	if user.Context.IsInFlow {
+		if user, err = h.DropUserState(user); err != nil {
+			return false, err
+		}
+	}
+
+	if exact {
+		message := h.NewMessage(user, fmt.Sprintf(h.text.DuplicateAd, similar.Title))
+
+		if url, ok := h.PostURL(similar); ok {
+			message.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonURL(commands.OpenPostButton, url),
+			))
+		}
+
+		_, err := h.bot.Send(message)
+
+		return false, err
+	}
+
+	if similar.OwnerId == user.Id && similar.Status == models.AdStatusPublished {
+		message := h.NewMessage(user, fmt.Sprintf(h.text.SimilarOwnAd, similar.Title))
+		message.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+			h.Button(commands.BumpAdButton.ParamName, commands.BumpAdButton.ParamValue, similar.Id),
+			h.Button(commands.SendToReviewButton.ParamName, commands.SendToReviewButton.ParamValue, similar.Id, DraftTag(user.Context.Advertisement)),
+		))
+
+		_, err := h.bot.Send(message)
+
+		return false, err
+	}
+
+	return false, h.SendToReview(user, publishAt, h.SimilarReason(similar, score))
+}
+
+func (h *Handlers) SimilarReason(similar *models.Advertisement, score float64) string {
+	return fmt.Sprintf(h.text.ReviewSimilar, similar.Id, similar.Title, int(score*100))
+}
+
+// SendToReview saves the draft as pending and shows it to the admins with the
+// reason it was held back.
+func (h *Handlers) SendToReview(user *models.User, publishAt time.Time, reason string) error {
+	ad, err := h.db.SaveAd(user, models.AdStatusPending, publishAt)
+
+	if err != nil {
+		return err
+	}
+
+	if err := h.SendMessage(user, h.text.AdSentToReview); err != nil {
+		return err
+	}
+
+	text, err := h.render.Preview(ad, h.render.Contact(user), h.settings.Locale)
+
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+
+	for _, admin := range h.settings.Admins {
+		if err := h.SendMessageTo(admin, fmt.Sprintf(h.text.ReviewForAdmin, ad.Id, ad.OwnerId, reason)); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		message := tgbotapi.NewMessage(admin, text)
+		message.ParseMode = h.render.ParseMode()
+		message.ReplyMarkup = h.GetModerationMarkup(ad)
+
+		if _, err := h.bot.Send(message); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// SendDraftToReview is the "publish anyway" answer to the bump prompt. The
+// draft goes through the same checks as when it is sent, only the duplicate
+// check is left to the admins.
+func (h *Handlers) SendDraftToReview(user *models.User, message *tgbotapi.Message, similarid int64) error {
+	similar, err := h.db.GetPublishedAd(similarid)
+
+	if err != nil {
+		return err
+	}
+
+	if fits, err := h.CheckAdLength(user); err != nil || !fits {
+		return err
+	}
+
+	if allowed, err := h.CheckAdsPerDay(user); err != nil || !allowed {
+		return err
+	}
+
+	if clean, err := h.CheckContent(user, time.Now()); err != nil || !clean {
+		return err
+	}
+
+	if err := h.RemoveInlineKeyboard(message); err != nil {
+		return err
+	}
+
+	return h.SendToReview(user, time.Now(), h.SimilarReason(similar, Similarity(user.Context.Advertisement, similar)))
+}
+
+// BumpAd reposts a published ad at the top of the channel instead of a copy,
+// at most once per BumpCooldown.
+func (h *Handlers) BumpAd(user *models.User, message *tgbotapi.Message, adid int64) error {
+	ad, err := h.GetOwnedAd(user, adid, models.AdStatusPublished)
+
+	if err != nil {
+		return err
+	}
+
+	if wait := time.Until(ad.PublishedAt.Add(BumpCooldown)); wait > 0 {
+		return h.SendMessage(user, fmt.Sprintf(h.text.BumpTooEarly, formatters.FormatWait(wait)))
+	}
+
+	// The old post has to go before the new one is sent, otherwise the
+	// channel would show the ad twice.
+	if ad.ChannelMessageId != 0 {
+		if _, err := h.bot.Request(tgbotapi.NewDeleteMessage(ad.ChannelChatId, ad.ChannelMessageId)); err != nil && !IsPostGone(err) {
+			return err
+		}
+	}
+
+	if err := h.RemoveInlineKeyboard(message); err != nil {
+		return err
+	}
+
+	if err := h.PublishAd(ad, user); err != nil {
+		return err
+	}
+
+	return h.SendMessage(user, fmt.Sprintf(h.text.AdBumped, ad.Title))
+}
+
+// ApproveAd publishes an ad that waited for review, or hands it back to the
+// scheduler if its publish time is still ahead.
+func (h *Handlers) ApproveAd(ad *models.Advertisement) error {
+	if ad.PublishAt.After(time.Now()) {
+		if err := h.db.ChangeAdStatus(ad, models.AdStatusScheduled); err != nil {
+			return err
+		}
+
+		return h.SendMessageTo(ad.OwnerId, fmt.Sprintf(h.text.AdApproved, ad.Title))
+	}
+
+	owner, err := h.db.GetUser(models.PrivateChatKey(ad.OwnerId))
+
+	if err != nil {
+		return err
+	}
+
+	if err := h.PublishAd(ad, owner); err != nil {
+		return err
+	}
+
+	return h.NotifyPublished(owner, ad)
+}
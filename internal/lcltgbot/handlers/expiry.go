@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/iokinai/lcltgbot/internal/lcltgbot/commands"
+	"github.com/iokinai/lcltgbot/internal/lcltgbot/models"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+func (h *Handlers) ProcessExpiringAds(now time.Time) error {
+	var errs []error
+
+	expired, err := h.db.GetExpiredAds(now)
+
+	if err != nil {
+		return err
+	}
+
+	for _, ad := range expired {
+		if err := h.ExpireAd(ad); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if h.settings.ReminderBefore() <= 0 {
+		return errors.Join(errs...)
+	}
+
+	expiring, err := h.db.GetAdsToRemind(now.Add(h.settings.ReminderBefore()))
+
+	if err != nil {
+		return err
+	}
+
+	for _, ad := range expiring {
+		if err := h.RemindAboutExpiry(ad, now); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// ExpireAd takes the ad out of the channel: the post is deleted when the
+// settings ask for it and edited to the closed template otherwise, or when
+// Telegram refuses to delete it.
+func (h *Handlers) ExpireAd(ad *models.Advertisement) error {
+	if !h.settings.DeleteExpiredPost || !h.DeleteChannelPost(ad) {
+		text, err := h.render.Expired(ad)
+
+		if err != nil {
+			return err
+		}
+
+		if err := h.EditChannelPost(ad, text); err != nil && !IsPostGone(err) {
+			return err
+		}
+	}
+
+	if err := h.db.ChangeAdStatus(ad, models.AdStatusExpired); err != nil {
+		return err
+	}
+
+	return h.SendMessageTo(ad.OwnerId, fmt.Sprintf(h.text.AdExpired, ad.Title))
+}
+
+func (h *Handlers) RemindAboutExpiry(ad *models.Advertisement, now time.Time) error {
+	message := tgbotapi.NewMessage(ad.OwnerId, fmt.Sprintf(h.text.ExpiryReminder, ad.Title, ad.ExpiresAt.In(h.settings.Location()).Format("02.01.2006 15:04")))
+	message.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			h.Button(commands.RenewAdButton.ParamName, commands.RenewAdButton.ParamValue, ad.Id),
+			h.Button(commands.MarkSoldButton.ParamName, commands.MarkSoldButton.ParamValue, ad.Id),
+		),
+	)
+
+	if _, err := h.bot.Send(message); err != nil {
+		return err
+	}
+
+	return h.db.MarkAdReminded(ad, now)
+}
+
+// postGoneErrors are the answers to an edit or a delete of a post that was
+// deleted by hand or can't be changed anymore, retrying won't help with any.
+var postGoneErrors = []string{"message to edit not found", "message to delete not found", "message can't be edited"}
+
+func IsPostGone(err error) bool {
+	var apiErr *tgbotapi.Error
+
+	if !errors.As(err, &apiErr) || apiErr.Code != http.StatusBadRequest {
+		return false
+	}
+
+	for _, gone := range postGoneErrors {
+		if strings.Contains(apiErr.Message, gone) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// DeleteChannelPost reports whether the post is gone from the channel.
+func (h *Handlers) DeleteChannelPost(ad *models.Advertisement) bool {
+	if ad.ChannelMessageId == 0 {
+		return true
+	}
+
+	if _, err := h.bot.Request(tgbotapi.NewDeleteMessage(ad.ChannelChatId, ad.ChannelMessageId)); err != nil {
+		log.Println(err)
+		return false
+	}
+
+	return true
+}
+
+// EditChannelPost replaces the post text and drops its buttons.
+func (h *Handlers) EditChannelPost(ad *models.Advertisement, text string) error {
+	if ad.ChannelMessageId == 0 {
+		return nil
+	}
+
+	edit := tgbotapi.NewEditMessageText(ad.ChannelChatId, ad.ChannelMessageId, text)
+	edit.ParseMode = h.render.ParseMode()
+
+	if _, err := h.bot.Request(edit); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (h *Handlers) GetOwnedAd(user *models.User, adid int64, status models.AdStatus) (*models.Advertisement, error) {
+	ad, err := h.db.GetPublishedAd(adid)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if ad.OwnerId != user.Id || ad.Status != status {
+		return nil, errors.New("ad is not available for this action")
+	}
+
+	return ad, nil
+}
+
+func (h *Handlers) RenewAd(user *models.User, adid int64) error {
+	ad, err := h.GetOwnedAd(user, adid, models.AdStatusPublished)
+
+	if err != nil {
+		return err
+	}
+
+	lifetime := h.AdLifetime(ad)
+
+	if lifetime <= 0 {
+		return errors.New("ad doesn't expire")
+	}
+
+	expiresAt := time.Now().Add(lifetime)
+
+	if err := h.db.SetAdExpiry(ad, expiresAt); err != nil {
+		return err
+	}
+
+	return h.SendMessage(user, fmt.Sprintf(h.text.AdRenewed, expiresAt.In(h.settings.Location()).Format("02.01.2006 15:04")))
+}
@@ -0,0 +1,30 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"testing"
+)
+
+func TestIsPostGone(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"deleted by hand", &tgbotapi.Error{Code: 400, Message: "Bad Request: message to edit not found"}, true},
+		{"deleted before a bump", &tgbotapi.Error{Code: 400, Message: "Bad Request: message to delete not found"}, true},
+		{"too old to edit", &tgbotapi.Error{Code: 400, Message: "Bad Request: message can't be edited"}, true},
+		{"wrapped", fmt.Errorf("edit: %w", &tgbotapi.Error{Code: 400, Message: "Bad Request: message to edit not found"}), true},
+		{"other bad request", &tgbotapi.Error{Code: 400, Message: "Bad Request: can't parse entities"}, false},
+		{"flood", &tgbotapi.Error{Code: 429, Message: "Too Many Requests: retry after 5"}, false},
+		{"network", errors.New("connection reset by peer"), false},
+	}
+
+	for _, test := range tests {
+		if got := IsPostGone(test.err); got != test.want {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,328 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/iokinai/lcltgbot/internal/lcltgbot/commands"
+	"github.com/iokinai/lcltgbot/internal/lcltgbot/formatters"
+	"github.com/iokinai/lcltgbot/internal/lcltgbot/models"
+	"log"
+	"strconv"
+	"strings"
+)
+
+const FavoritesPageSize = 5
+
+func (h *Handlers) GetFavoriteButton(user *models.User, ad *models.Advertisement) (tgbotapi.InlineKeyboardButton, error) {
+	saved, err := h.db.IsFavorite(user.Id, ad.Id)
+
+	if err != nil {
+		return tgbotapi.InlineKeyboardButton{}, err
+	}
+
+	button := commands.AddFavoriteButton
+
+	if saved {
+		button = commands.RemoveFavoriteButton
+	}
+
+	return h.Button(button.ParamName, button.ParamValue, ad.Id), nil
+}
+
+// ToggleFavorite saves or forgets an ad from a card and flips the button on
+// that card in place.
+func (h *Handlers) ToggleFavorite(user *models.User, message *tgbotapi.Message, adid int64, save bool) error {
+	ad, err := h.db.GetPublishedAd(adid)
+
+	if err != nil {
+		return err
+	}
+
+	if save && ad.Status == models.AdStatusPublished {
+		err = h.db.AddFavorite(user.Id, ad.Id)
+	} else if !save {
+		err = h.db.RemoveFavorite(user.Id, ad.Id)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if message == nil || message.ReplyMarkup == nil {
+		return nil
+	}
+
+	button, err := h.GetFavoriteButton(user, ad)
+
+	if err != nil {
+		return err
+	}
+
+	markup := *message.ReplyMarkup
+
+	for _, row := range markup.InlineKeyboard {
+		for i := range row {
+			if data := row[i].CallbackData; data != nil && h.IsFavoriteToggle(*data, ad.Id) {
+				row[i] = button
+			}
+		}
+	}
+
+	if _, err := h.bot.Request(tgbotapi.NewEditMessageReplyMarkup(message.Chat.ID, message.MessageID, markup)); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// IsFavoriteToggle tells whether the signed callback data is the save or
+// unsave button of the ad. Remove buttons of the favorites list carry a page
+// too and are left alone.
+func (h *Handlers) IsFavoriteToggle(data string, adid int64) bool {
+	payload, err := h.codec.Decode(data)
+
+	if err != nil || len(payload.Args) != 1 || payload.Args[0] != strconv.FormatInt(adid, 10) {
+		return false
+	}
+
+	return payload.Action == commands.AddFavoriteCommandData || payload.Action == commands.RemoveFavoriteCommandData
+}
+
+func (h *Handlers) HandleFavorites(user *models.User) error {
+	text, markup, err := h.RenderFavoritesPage(user, 0)
+
+	if err != nil {
+		return err
+	}
+
+	message := h.NewMessage(user, text)
+	message.ParseMode = h.render.ParseMode()
+	message.DisableWebPagePreview = true
+
+	if markup != nil {
+		message.ReplyMarkup = *markup
+	}
+
+	if _, err := h.bot.Send(message); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (h *Handlers) ShowFavoritesPage(user *models.User, message *tgbotapi.Message, page int) error {
+	text, markup, err := h.RenderFavoritesPage(user, page)
+
+	if err != nil {
+		return err
+	}
+
+	edit := tgbotapi.NewEditMessageText(message.Chat.ID, message.MessageID, text)
+	edit.ParseMode = h.render.ParseMode()
+	edit.DisableWebPagePreview = true
+	edit.ReplyMarkup = markup
+
+	if _, err := h.bot.Request(edit); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// RenderFavoritesPage lists one page of saved ads with a remove button per ad
+// and arrows to the neighbouring pages. A page past the end shows the last one.
+func (h *Handlers) RenderFavoritesPage(user *models.User, page int) (string, *tgbotapi.InlineKeyboardMarkup, error) {
+	total, err := h.db.CountFavorites(user.Id)
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	if total == 0 {
+		return h.render.Escape(h.text.NoFavorites), nil, nil
+	}
+
+	pages := (total + FavoritesPageSize - 1) / FavoritesPageSize
+
+	if page >= pages {
+		page = pages - 1
+	}
+
+	if page < 0 {
+		page = 0
+	}
+
+	ads, err := h.db.GetFavorites(user.Id, page*FavoritesPageSize, FavoritesPageSize)
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	lines := []string{h.render.Escape(fmt.Sprintf(h.text.FavoritesPage, page+1, pages))}
+
+	var rows [][]tgbotapi.InlineKeyboardButton
+
+	for i, ad := range ads {
+		line, err := h.render.Favorite(ad, h.render.Locale(user), h.ReadMoreURL(ad))
+
+		if err != nil {
+			return "", nil, err
+		}
+
+		number := page*FavoritesPageSize + i + 1
+		lines = append(lines, h.render.Escape(fmt.Sprintf("%d. ", number))+line)
+
+		label := fmt.Sprintf("✕ %d. %s", number, formatters.Truncate(ad.Title, 24))
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			h.Button(label, commands.RemoveFavoriteCommandData, ad.Id, page),
+		))
+	}
+
+	var navigation []tgbotapi.InlineKeyboardButton
+
+	if page > 0 {
+		navigation = append(navigation, h.Button("◀", commands.FavoritesPageCommandData, page-1))
+	}
+
+	if page < pages-1 {
+		navigation = append(navigation, h.Button("▶", commands.FavoritesPageCommandData, page+1))
+	}
+
+	if len(navigation) > 0 {
+		rows = append(rows, navigation)
+	}
+
+	markup := tgbotapi.NewInlineKeyboardMarkup(rows...)
+
+	return strings.Join(lines, "\n\n"), &markup, nil
+}
+
+func (h *Handlers) AskForNewPrice(user *models.User, adid int64) error {
+	ad, err := h.GetOwnedAd(user, adid, models.AdStatusPublished)
+
+	if err != nil {
+		return err
+	}
+
+	if user.Context.IsInFlow {
+		return h.SendMessage(user, fmt.Sprintf(h.text.InChainError, commands.CancelFlow))
+	}
+
+	if user, err = h.db.ChangeTargetAd(user, ad.Id); err != nil {
+		return err
+	}
+
+	if user, err = h.db.ChangeUserState(user, models.StateWaitingForNewPrice); err != nil {
+		return err
+	}
+
+	return h.SendMessage(user, fmt.Sprintf(h.text.AskNewPrice, ad.Title))
+}
+
+// HandleNewPriceInput changes the price of a published ad, updates the
+// channel post and tells everyone who saved the ad when the price went down.
+func (h *Handlers) HandleNewPriceInput(user *models.User, text string) error {
+	price, ok := ParsePrice(text)
+
+	if !ok {
+		return h.SendMessage(user, h.text.WrongPrice)
+	}
+
+	ad, err := h.GetOwnedAd(user, user.Context.TargetAdId, models.AdStatusPublished)
+
+	if err != nil {
+		_, err = h.DropUserState(user)
+		return err
+	}
+
+	old := *ad
+
+	if err := h.db.UpdateAdPrice(ad, price); err != nil {
+		return err
+	}
+
+	if err := h.UpdateChannelPost(ad); err != nil {
+		return err
+	}
+
+	if user, err = h.DropUserState(user); err != nil {
+		return err
+	}
+
+	if err := h.SendMessage(user, h.text.PriceChanged); err != nil {
+		return err
+	}
+
+	// The alerts go through the bulk queue and may take a while, the owner's
+	// change is saved already and doesn't wait for them.
+	if old.Price > 0 && price < old.Price {
+		go func() {
+			if err := h.NotifyPriceDrop(&old, ad); err != nil {
+				log.Println(err)
+			}
+		}()
+	}
+
+	return nil
+}
+
+func (h *Handlers) UpdateChannelPost(ad *models.Advertisement) error {
+	if ad.ChannelMessageId == 0 {
+		return nil
+	}
+
+	text, err := h.render.ChannelPost(ad, h.ChannelContact(ad), h.ReadMoreURL(ad))
+
+	if err != nil {
+		return err
+	}
+
+	edit := tgbotapi.NewEditMessageTextAndMarkup(ad.ChannelChatId, ad.ChannelMessageId, text, h.GetChannelPostMarkup(ad))
+	edit.ParseMode = h.render.ParseMode()
+
+	if _, err := h.bot.Request(edit); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// NotifyPriceDrop tells everyone who saved the ad about the new price. A
+// watcher who can't be reached doesn't keep the others from hearing about it.
+func (h *Handlers) NotifyPriceDrop(old *models.Advertisement, ad *models.Advertisement) error {
+	watchers, err := h.db.GetFavoriteWatchers(ad.Id)
+
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+
+	for _, userid := range watchers {
+		if userid == ad.OwnerId {
+			continue
+		}
+
+		watcher, err := h.db.GetUser(models.PrivateChatKey(userid))
+
+		if err != nil {
+			continue
+		}
+
+		locale := h.render.Locale(watcher)
+		message := tgbotapi.NewMessage(watcher.Chatid, fmt.Sprintf(h.text.PriceDropped, ad.Title, formatters.FormatPrice(old, locale), formatters.FormatPrice(ad, locale)))
+
+		if url, ok := h.PostURL(ad); ok {
+			message.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonURL(commands.OpenPostButton, url),
+			))
+		}
+
+		if _, err := h.bulk.Send(message); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"fmt"
+	"github.com/iokinai/lcltgbot/internal/lcltgbot/filter"
+	"github.com/iokinai/lcltgbot/internal/lcltgbot/models"
+	"time"
+)
+
+// FilterText runs a title or description through the content rules before it
+// is saved. Rejected text is refused, masked text is returned with asterisks
+// and text that needs moderation passes here and is held back on publishing.
+func (h *Handlers) FilterText(user *models.User, text string) (string, bool, error) {
+	verdict := h.filters.Check(text)
+
+	if verdict.Action == filter.ActionReject {
+		return "", false, h.SendMessage(user, h.RejectReason(verdict.Rule))
+	}
+
+	if verdict.Text != text {
+		return verdict.Text, true, h.SendMessage(user, h.text.FilterMasked)
+	}
+
+	return text, true, nil
+}
+
+func (h *Handlers) RejectReason(rule *filter.Rule) string {
+	if rule.Message != "" {
+		return rule.Message
+	}
+
+	return fmt.Sprintf(h.text.FilterRejected, rule.Name)
+}
+
+// CheckContent runs the draft through the content rules once more before it
+// is saved, since the rules may have changed while it was being written.
+func (h *Handlers) CheckContent(user *models.User, publishAt time.Time) (bool, error) {
+	ad := user.Context.Advertisement
+
+	title := h.filters.Check(ad.Title)
+	description := h.filters.Check(ad.Description)
+
+	verdict := title
+
+	if filter.Stricter(description.Action, title.Action) {
+		verdict = description
+	}
+
+	var err error
+
+	if title.Text != ad.Title {
+		if user, err = h.db.ChangeAdTitle(user, title.Text, ad.TitleEntities); err != nil {
+			return false, err
+		}
+	}
+
+	if description.Text != ad.Description {
+		if user, err = h.db.ChangeAdDescription(user, description.Text, ad.DescriptionEntities); err != nil {
+			return false, err
+		}
+	}
+
+	switch verdict.Action {
+	case filter.ActionReject:
+		return false, h.SendMessage(user, h.RejectReason(verdict.Rule))
+	case filter.ActionModerate:
+		if user.Context.IsInFlow {
+			if user, err = h.DropUserState(user); err != nil {
+				return false, err
+			}
+		}
+
+		return false, h.SendToReview(user, publishAt, fmt.Sprintf(h.text.ReviewFilter, verdict.Rule.Name))
+	}
+
+	return true, nil
+}
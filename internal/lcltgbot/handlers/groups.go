@@ -0,0 +1,170 @@
+package handlers
+
+import (
+	"errors"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/iokinai/lcltgbot/internal/lcltgbot/commands"
+	"github.com/iokinai/lcltgbot/internal/lcltgbot/models"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+)
+
+func (h *Handlers) HandleGroupMessage(message *tgbotapi.Message, threadid int64) error {
+	if !h.settings.GroupsEnabled || message.Chat.IsChannel() {
+		return nil
+	}
+
+	if !h.NormalizeGroupMessage(message) {
+		return nil
+	}
+
+	switch message.Text {
+	case commands.AllowGroupCommand:
+		return h.HandleGroupAccess(message, true)
+	case commands.DenyGroupCommand:
+		return h.HandleGroupAccess(message, false)
+	}
+
+	allowed, err := h.db.IsGroupAllowed(message.Chat.ID)
+
+	if err != nil {
+		return err
+	}
+
+	if !allowed || !h.Throttle(message.Chat.ID, message.From.ID) {
+		return nil
+	}
+
+	user, err := h.db.GetUser(models.NewChatKey(message.Chat.ID, message.From.ID, threadid))
+
+	if errors.Is(err, models.ErrUserNotFound) {
+		return h.ReplyInGroup(message, h.text.RegisterInPrivate)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if threadid != 0 {
+		user.ReplyTo = message.MessageID
+	}
+
+	return h.HandleUserMessage(user, message)
+}
+
+func (h *Handlers) HandleGroupAccess(message *tgbotapi.Message, allowed bool) error {
+	if !h.settings.IsAdmin(message.From.ID) {
+		return h.ReplyInGroup(message, h.text.AdminOnly)
+	}
+
+	if err := h.db.SetGroupAllowed(message.Chat.ID, message.Chat.Title, allowed); err != nil {
+		return err
+	}
+
+	if allowed {
+		return h.ReplyInGroup(message, h.text.GroupAllowed)
+	}
+
+	return h.ReplyInGroup(message, h.text.GroupDenied)
+}
+
+// NormalizeGroupMessage reports whether a group message is addressed to the bot:
+// a command, a reply to one of the bot's messages or a mention. The bot mention
+// is stripped so the rest of the handlers see the same text as in private chats.
+func (h *Handlers) NormalizeGroupMessage(message *tgbotapi.Message) bool {
+	mention := "@" + h.me.UserName
+
+	if message.IsCommand() {
+		command := message.CommandWithAt()
+
+		if i := strings.Index(command, "@"); i != -1 && !strings.EqualFold(command[i:], mention) {
+			return false
+		}
+
+		stripped := "/" + message.Command()
+
+		message.Text = stripped + message.Text[message.Entities[0].Length:]
+		message.Entities[0].Length = len(stripped)
+
+		return true
+	}
+
+	if message.ReplyToMessage != nil && message.ReplyToMessage.From != nil && message.ReplyToMessage.From.ID == h.me.ID {
+		return true
+	}
+
+	for i, entity := range message.Entities {
+		if !entity.IsMention() {
+			continue
+		}
+
+		units := utf16.Encode([]rune(message.Text))
+		end := entity.Offset + entity.Length
+
+		if end > len(units) || !strings.EqualFold(string(utf16.Decode(units[entity.Offset:end])), mention) {
+			continue
+		}
+
+		message.Text, message.Entities = RemoveEntity(message.Text, message.Entities, i)
+
+		return true
+	}
+
+	return false
+}
+
+// RemoveEntity cuts the text of the i-th entity out of the message and trims
+// the spaces left around it. The other entities keep their formatting, their
+// offsets are shifted by what was cut, in UTF-16 units as Telegram counts.
+func RemoveEntity(text string, entities []tgbotapi.MessageEntity, i int) (string, []tgbotapi.MessageEntity) {
+	units := utf16.Encode([]rune(text))
+	start, end := entities[i].Offset, entities[i].Offset+entities[i].Length
+
+	joined := string(utf16.Decode(units[:start])) + string(utf16.Decode(units[end:]))
+	trimmed := strings.TrimSpace(joined)
+	lead := len(utf16.Encode([]rune(strings.TrimSuffix(joined, strings.TrimLeftFunc(joined, unicode.IsSpace)))))
+	size := len(utf16.Encode([]rune(trimmed)))
+
+	var kept []tgbotapi.MessageEntity
+
+	for j, entity := range entities {
+		if j == i || (entity.Offset < end && entity.Offset+entity.Length > start) {
+			continue
+		}
+
+		if entity.Offset >= end {
+			entity.Offset -= end - start
+		}
+
+		from, to := entity.Offset-lead, entity.Offset+entity.Length-lead
+
+		if from < 0 {
+			from = 0
+		}
+
+		if to > size {
+			to = size
+		}
+
+		if to <= from {
+			continue
+		}
+
+		entity.Offset, entity.Length = from, to-from
+		kept = append(kept, entity)
+	}
+
+	return trimmed, kept
+}
+
+func (h *Handlers) ReplyInGroup(message *tgbotapi.Message, text string) error {
+	reply := tgbotapi.NewMessage(message.Chat.ID, text)
+	reply.ReplyToMessageID = message.MessageID
+
+	if _, err := h.bot.Send(reply); err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,34 @@
+package handlers
+
+import (
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"reflect"
+	"testing"
+)
+
+func TestRemoveEntity(t *testing.T) {
+	mention := tgbotapi.MessageEntity{Type: "mention", Offset: 0, Length: 8}
+
+	tests := []struct {
+		name     string
+		text     string
+		entities []tgbotapi.MessageEntity
+		i        int
+		want     string
+		kept     []tgbotapi.MessageEntity
+	}{
+		{"leading mention", "@testbot Велосипед", []tgbotapi.MessageEntity{mention, {Type: "bold", Offset: 9, Length: 9}}, 0, "Велосипед", []tgbotapi.MessageEntity{{Type: "bold", Offset: 0, Length: 9}}},
+		{"after emoji", "🚲 @testbot Велосипед", []tgbotapi.MessageEntity{{Type: "mention", Offset: 3, Length: 8}, {Type: "italic", Offset: 12, Length: 9}}, 0, "🚲  Велосипед", []tgbotapi.MessageEntity{{Type: "italic", Offset: 4, Length: 9}}},
+		{"before the mention", "Лампа @testbot", []tgbotapi.MessageEntity{{Type: "bold", Offset: 0, Length: 5}, {Type: "mention", Offset: 6, Length: 8}}, 1, "Лампа", []tgbotapi.MessageEntity{{Type: "bold", Offset: 0, Length: 5}}},
+		{"spaces trimmed", "@testbot  очень", []tgbotapi.MessageEntity{mention, {Type: "underline", Offset: 8, Length: 7}}, 0, "очень", []tgbotapi.MessageEntity{{Type: "underline", Offset: 0, Length: 5}}},
+		{"only the mention", "@testbot", []tgbotapi.MessageEntity{mention}, 0, "", nil},
+	}
+
+	for _, test := range tests {
+		text, kept := RemoveEntity(test.text, test.entities, test.i)
+
+		if text != test.want || !reflect.DeepEqual(kept, test.kept) {
+			t.Errorf("%s: got %q %v, want %q %v", test.name, text, kept, test.want, test.kept)
+		}
+	}
+}
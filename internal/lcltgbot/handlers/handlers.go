@@ -5,41 +5,158 @@ import (
 	"fmt"
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	"github.com/iokinai/lcltgbot/internal/lcltgbot/commands"
+	"github.com/iokinai/lcltgbot/internal/lcltgbot/filter"
 	"github.com/iokinai/lcltgbot/internal/lcltgbot/formatters"
+	"github.com/iokinai/lcltgbot/internal/lcltgbot/geo"
 	"github.com/iokinai/lcltgbot/internal/lcltgbot/models"
-	"log"
-	"strconv"
+	"github.com/iokinai/lcltgbot/internal/lcltgbot/ratelimit"
 	"strings"
+	"time"
 )
 
 const DEBUG = true
 
 type Database interface {
-	Register(chatid int64, username string) (*models.User, error)
-	GetUser(chatid int64) (*models.User, error)
+	Register(userid int64, chatid int64, username string, firstname string) (*models.User, error)
+	GetUser(key models.ChatKey) (*models.User, error)
+	UpdateUserProfile(user *models.User, username string, firstname string, language string) (*models.User, error)
 	ChangeUserState(user *models.User, state models.BotState) (*models.User, error)
-	ChangeAdTitle(user *models.User, title string) (*models.User, error)
-	ChangeAdDescription(user *models.User, descr string) (*models.User, error)
+	ChangeAdTitle(user *models.User, title string, entities []models.Entity) (*models.User, error)
+	ChangeAdDescription(user *models.User, descr string, entities []models.Entity) (*models.User, error)
 	ChangeAdPrice(user *models.User, price float64) (*models.User, error)
-	ChangeAdCity(user *models.User, city string) (*models.User, error)
+	ChangeAdCurrency(user *models.User, currency string) (*models.User, error)
+	ChangeAdPriceType(user *models.User, priceType models.PriceType) (*models.User, error)
+	ChangeAdCategory(user *models.User, category string) (*models.User, error)
+	ChangeAdLifetime(user *models.User, days int) (*models.User, error)
+	ChangeAdPlace(user *models.User, city string, cityid string, location *models.Location) (*models.User, error)
 	ChangeAdEditing(user *models.User, editing bool) (*models.User, error)
+	ChangeAdTruncated(user *models.User, truncated bool) (*models.User, error)
+	IsGroupAllowed(chatid int64) (bool, error)
+	SetGroupAllowed(chatid int64, title string, allowed bool) error
+	SaveAd(user *models.User, status models.AdStatus, publishAt time.Time) (*models.Advertisement, error)
+	GetPublishedAd(id int64) (*models.Advertisement, error)
+	GetUserAds(userid int64) ([]*models.Advertisement, error)
+	GetDueAds(now time.Time) ([]*models.Advertisement, error)
+	ClaimAd(ad *models.Advertisement) (bool, error)
+	MarkAdPublished(ad *models.Advertisement, channelchatid int64, messageid int, publishedAt time.Time) error
+	ChangeAdStatus(ad *models.Advertisement, status models.AdStatus) error
+	SetAdExpiry(ad *models.Advertisement, expiresAt time.Time) error
+	MarkAdReminded(ad *models.Advertisement, remindedAt time.Time) error
+	GetAdsToRemind(until time.Time) ([]*models.Advertisement, error)
+	GetExpiredAds(now time.Time) ([]*models.Advertisement, error)
+	MarkAdSold(ad *models.Advertisement, soldAt time.Time) error
+	SetAdSoldPrice(adid int64, price float64) error
+	ChangeTargetAd(user *models.User, adid int64) (*models.User, error)
+	GetPublishedAds() ([]*models.Advertisement, error)
+	GetPublishedAdsIn(box geo.BoundingBox) ([]*models.Advertisement, error)
+	OpenRelay(ad *models.Advertisement, buyerid int64) (*models.Relay, error)
+	GetRelay(id int64) (*models.Relay, error)
+	CloseRelay(relay *models.Relay, status models.RelayStatus, endedAt time.Time) error
+	IsRelayBlocked(sellerid int64, buyerid int64) (bool, error)
+	ChangeActiveRelay(user *models.User, relayid int64) (*models.User, error)
+	CreateInvite(code string, createdBy int64, maxUses int) error
+	UseInvite(code string) (*models.Invite, error)
+	SaveReferral(userid int64, referrerid int64) error
+	CountReferrals(referrerid int64) (int, error)
+	UpdateAdPrice(ad *models.Advertisement, price float64) error
+	AddFavorite(userid int64, adid int64) error
+	RemoveFavorite(userid int64, adid int64) error
+	IsFavorite(userid int64, adid int64) (bool, error)
+	GetFavorites(userid int64, offset int, limit int) ([]*models.Advertisement, error)
+	CountFavorites(userid int64) (int, error)
+	GetFavoriteWatchers(adid int64) ([]int64, error)
+	SaveReport(report *models.Report) (bool, error)
+	SaveCallbackPayload(payload string, at time.Time) (int64, error)
+	GetCallbackPayload(id int64) (string, error)
+	DeleteCallbackPayloads(before time.Time) (int64, error)
+	HasReported(adid int64, reporterid int64) (bool, error)
+	CountReportsSince(reporterid int64, since time.Time) (int, error)
+	GetOpenReports(adid int64) ([]*models.Report, error)
+	ResolveReports(adid int64, resolvedAt time.Time) error
+	BanUser(userid int64, bannedAt time.Time) error
+	GetAdCreationTimes(userid int64, since time.Time) ([]time.Time, error)
+	GetRecentAds(since time.Time) ([]*models.Advertisement, error)
+	GetStats(since time.Time) (*models.Stats, error)
+	GetUserIdByUsername(username string) (int64, error)
+	ResetUserStates(userid int64) (int64, error)
+	UnbanUser(userid int64) error
+	UpdateAdTitle(ad *models.Advertisement, title string, entities []models.Entity) error
+	UpdateAdDescription(ad *models.Advertisement, description string, entities []models.Entity) error
+	CreateBroadcast(broadcast *models.Broadcast) error
+	SetBroadcastButtons(broadcast *models.Broadcast, buttons []models.LinkButton) error
+	GetBroadcast(id int64) (*models.Broadcast, error)
+	GetDraftBroadcast(createdBy int64) (*models.Broadcast, error)
+	GetSendingBroadcasts() ([]*models.Broadcast, error)
+	StartBroadcast(broadcast *models.Broadcast, startedAt time.Time) (int64, bool, error)
+	CancelBroadcast(broadcast *models.Broadcast) (bool, error)
+	FinishBroadcast(broadcast *models.Broadcast, finishedAt time.Time) error
+	GetPendingDeliveries(broadcastid int64, limit int) ([]*models.Delivery, error)
+	MarkDelivery(delivery *models.Delivery, status models.DeliveryStatus, reason string, at time.Time) error
+	RetryDelivery(delivery *models.Delivery) error
+	CountDeliveries(broadcastid int64) (*models.DeliveryCounts, error)
+	SetUserInactive(userid int64, inactive bool, at time.Time) error
+}
+
+type Messenger interface {
+	Send(c tgbotapi.Chattable) (tgbotapi.Message, error)
+	Request(c tgbotapi.Chattable) (*tgbotapi.APIResponse, error)
+}
+
+// MetricsSource is implemented by messengers that queue requests.
+type MetricsSource interface {
+	Metrics() models.QueueMetrics
 }
 
 type Handlers struct {
-	bot      *tgbotapi.BotAPI
+	bot      Messenger
+	bulk     Messenger
+	me       tgbotapi.User
 	db       Database
 	settings *models.AppSettings
 	text     *models.TextSettings
+	render   *formatters.Renderer
+	cities   *geo.Gazetteer
+	filters  *filter.Engine
+	codec    *commands.Codec
+	inline   *inlineCache
+	limiter  *ratelimit.Limiter
+	presses  *ratelimit.Once
 }
 
-func NewHandlers(bot *tgbotapi.BotAPI, db Database, settings *models.AppSettings, text *models.TextSettings) *Handlers {
-	return &Handlers{bot: bot, db: db, settings: settings, text: text}
+func NewHandlers(bot Messenger, bulk Messenger, me tgbotapi.User, db Database, settings *models.AppSettings, text *models.TextSettings, render *formatters.Renderer, cities *geo.Gazetteer, filters *filter.Engine) *Handlers {
+	rate, burst := settings.MessageRate()
+
+	return &Handlers{
+		bot:      bot,
+		bulk:     bulk,
+		me:       me,
+		db:       db,
+		settings: settings,
+		text:     text,
+		render:   render,
+		cities:   cities,
+		filters:  filters,
+		codec:    commands.NewCodec(settings.CallbackSecret(), db),
+		inline:   newInlineCache(InlineCacheTime),
+		limiter:  ratelimit.NewLimiter(rate, burst),
+		presses:  ratelimit.NewOnce(PressDedupWindow),
+	}
 }
 
-func (h *Handlers) HandleMessage(message *tgbotapi.Message) error {
-	chatid := message.Chat.ID
+func (h *Handlers) HandleMessage(message *tgbotapi.Message, threadid int64) error {
+	if message.From == nil {
+		return nil
+	}
+
+	if !message.Chat.IsPrivate() {
+		return h.HandleGroupMessage(message, threadid)
+	}
+
+	if !h.Throttle(message.Chat.ID, message.From.ID) {
+		return nil
+	}
 
-	user, err := h.db.GetUser(chatid)
+	user, err := h.db.GetUser(models.NewChatKey(message.Chat.ID, message.From.ID, 0))
 
 	if err != nil {
 		user, err = h.AskForKey(message)
@@ -53,6 +170,26 @@ func (h *Handlers) HandleMessage(message *tgbotapi.Message) error {
 		}
 	}
 
+	return h.HandleUserMessage(user, message)
+}
+
+func (h *Handlers) HandleUserMessage(user *models.User, message *tgbotapi.Message) error {
+	user, err := h.db.UpdateUserProfile(user, message.From.UserName, message.From.FirstName, message.From.LanguageCode)
+
+	if err != nil {
+		return err
+	}
+
+	if user.Banned {
+		return h.SendMessage(user, h.text.UserBanned)
+	}
+
+	if user.Inactive {
+		if err := h.db.SetUserInactive(user.Id, false, time.Now()); err != nil {
+			return err
+		}
+	}
+
 	if !user.Context.IsInFlow {
 		if err := h.HandleSingleCommand(user, message); err != nil {
 			return err
@@ -65,6 +202,22 @@ func (h *Handlers) HandleMessage(message *tgbotapi.Message) error {
 }
 
 func (h *Handlers) HandleSingleCommand(user *models.User, message *tgbotapi.Message) error {
+	if strings.HasPrefix(message.Text, commands.StartCommand+" ") {
+		return h.HandleStartPayload(user, commands.ParseStartPayload(message.CommandArguments()))
+	}
+
+	if strings.HasPrefix(message.Text, commands.InviteCommand+" ") {
+		return h.HandleInvite(user, message.CommandArguments())
+	}
+
+	if user.Context.RelayId != 0 && !message.IsCommand() {
+		return h.RelayMessage(user, message)
+	}
+
+	if IsAdminCommand(message) {
+		return h.HandleAdminCommand(user, message)
+	}
+
 	switch message.Text {
 	case commands.StartCommand:
 		if err := h.HandleStart(user); err != nil {
@@ -74,6 +227,30 @@ func (h *Handlers) HandleSingleCommand(user *models.User, message *tgbotapi.Mess
 		if err := h.HandleAddAd(user); err != nil {
 			return err
 		}
+	case commands.MyAdsCommand:
+		if err := h.HandleMyAds(user); err != nil {
+			return err
+		}
+	case commands.NearbyCommand:
+		if err := h.HandleNearby(user); err != nil {
+			return err
+		}
+	case commands.EndChatCommand:
+		if err := h.HandleEndChat(user); err != nil {
+			return err
+		}
+	case commands.InviteCommand:
+		if err := h.HandleInvite(user, ""); err != nil {
+			return err
+		}
+	case commands.ReferralCommand:
+		if err := h.HandleReferral(user); err != nil {
+			return err
+		}
+	case commands.FavoritesCommand:
+		if err := h.HandleFavorites(user); err != nil {
+			return err
+		}
 	default:
 		if err := h.SendMessage(user, h.text.WrongCommand); err != nil {
 			return err
@@ -109,11 +286,21 @@ func (h *Handlers) HandleCommandFlow(user *models.User, message *tgbotapi.Messag
 
 	switch user.Context.State {
 	case models.StateWaitingForCTitle:
-		user, err := h.db.ChangeAdTitle(user, message.Text)
+		text, ok, err := h.FilterText(user, message.Text)
+
+		if err != nil || !ok {
+			return err
+		}
+
+		user, err := h.db.ChangeAdTitle(user, text, formatters.EntitiesFromMessage(message.Entities))
 		if err != nil {
 			return err
 		}
 
+		if _, err := h.CheckAdLength(user); err != nil {
+			return err
+		}
+
 		if _, err := h.GoNextIfCreatingElseDropEditing(
 			user,
 			models.StateWaitingForCDescription,
@@ -123,12 +310,22 @@ func (h *Handlers) HandleCommandFlow(user *models.User, message *tgbotapi.Messag
 		}
 
 	case models.StateWaitingForCDescription:
-		user, err := h.db.ChangeAdDescription(user, message.Text)
+		text, ok, err := h.FilterText(user, message.Text)
+
+		if err != nil || !ok {
+			return err
+		}
+
+		user, err := h.db.ChangeAdDescription(user, text, formatters.EntitiesFromMessage(message.Entities))
 
 		if err != nil {
 			return err
 		}
 
+		if _, err := h.CheckAdLength(user); err != nil {
+			return err
+		}
+
 		if _, err := h.GoNextIfCreatingElseDropEditing(
 			user,
 			models.StateWaitingForCPrice,
@@ -138,9 +335,13 @@ func (h *Handlers) HandleCommandFlow(user *models.User, message *tgbotapi.Messag
 		}
 
 	case models.StateWaitingForCPrice:
-		price, _ := strconv.Atoi(message.Text)
+		price, ok := ParsePrice(message.Text)
 
-		user, err := h.db.ChangeAdPrice(user, float64(price))
+		if !ok {
+			return h.SendMessage(user, h.text.WrongPrice)
+		}
+
+		user, err := h.db.ChangeAdPrice(user, price)
 
 		if err != nil {
 			return err
@@ -155,23 +356,47 @@ func (h *Handlers) HandleCommandFlow(user *models.User, message *tgbotapi.Messag
 		}
 
 	case models.StateWaitingForCCity:
-		user, err := h.db.ChangeAdCity(user, message.Text)
+		if err := h.HandleCityInput(user, message); err != nil {
+			return err
+		}
 
-		if err != nil {
+	case models.StateWaitingForSchedule:
+		if err := h.HandleScheduleInput(user, message.Text); err != nil {
 			return err
 		}
 
-		if !user.Context.Advertisement.Editing {
-			if err := h.SendPreview(user); err != nil {
-				return err
-			}
+	case models.StateWaitingForSoldPrice:
+		if err := h.HandleSoldPriceInput(user, message.Text); err != nil {
+			return err
 		}
 
-		if _, err := h.GoNextIfCreatingElseDropEditing(
-			user,
-			models.StateNONE,
-			h.text.AdPreview,
-		); err != nil {
+	case models.StateWaitingForNearbyLocation:
+		if err := h.HandleNearbyLocation(user, message); err != nil {
+			return err
+		}
+
+	case models.StateWaitingForNewPrice:
+		if err := h.HandleNewPriceInput(user, message.Text); err != nil {
+			return err
+		}
+
+	case models.StateWaitingForReportReason:
+		if err := h.HandleReportReasonInput(user, message.Text); err != nil {
+			return err
+		}
+
+	case models.StateWaitingForAdminTitle, models.StateWaitingForAdminDescription, models.StateWaitingForAdminPrice:
+		if err := h.HandleAdminEditInput(user, message); err != nil {
+			return err
+		}
+
+	case models.StateWaitingForBroadcast:
+		if err := h.HandleBroadcastInput(user, message); err != nil {
+			return err
+		}
+
+	case models.StateWaitingForBroadcastButtons:
+		if err := h.HandleBroadcastLinksInput(user, message.Text); err != nil {
 			return err
 		}
 	}
@@ -187,7 +412,7 @@ func (h *Handlers) GoNextIfCreatingElseDropEditing(user *models.User, state mode
 			return nil, err
 		}
 
-		if err := h.SendMessage(user, messagetext); err != nil {
+		if err := h.SendStatePrompt(user, messagetext); err != nil {
 			return nil, err
 		}
 
@@ -198,7 +423,12 @@ func (h *Handlers) GoNextIfCreatingElseDropEditing(user *models.User, state mode
 }
 
 func (h *Handlers) AfterEdited(user *models.User) (*models.User, error) {
-	_, err := h.bot.Send(h.CreateNewAdMessage(user, tgbotapi.ModeHTML))
+	message, err := h.CreateNewAdMessage(user, h.render.ParseMode())
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = h.bot.Send(message)
 	if err != nil {
 		return nil, err
 	}
@@ -233,18 +463,24 @@ func (h *Handlers) DropUserState(user *models.User) (*models.User, error) {
 	return user, nil
 }
 
-func (h *Handlers) CreateNewAdMessage(user *models.User, parsemode string) tgbotapi.MessageConfig {
-	username := user.Username
+func (h *Handlers) CreateNewAdMessage(user *models.User, parsemode string) (tgbotapi.MessageConfig, error) {
+	contact := h.render.Escape(h.text.ContactViaBot)
 
 	if DEBUG {
-		username = h.text.Hidden
+		contact = h.render.Bold(h.text.Hidden)
 	}
 
-	message := tgbotapi.NewMessage(user.Chatid, formatters.FormatAdToMessageString(user.Context.Advertisement, username))
+	text, err := h.render.Preview(user.Context.Advertisement, contact, h.render.Locale(user))
+
+	if err != nil {
+		return tgbotapi.MessageConfig{}, err
+	}
+
+	message := h.NewMessage(user, text)
 	message.ParseMode = parsemode
-	message.ReplyMarkup = h.GetPreviewMarkup()
+	message.ReplyMarkup = h.GetPreviewMarkup(user.Context.Advertisement)
 
-	return message
+	return message, nil
 }
 
 func (h *Handlers) HandleStart(user *models.User) error {
@@ -256,6 +492,10 @@ func (h *Handlers) HandleStart(user *models.User) error {
 }
 
 func (h *Handlers) HandleAddAd(user *models.User) error {
+	if allowed, err := h.CheckAdsPerDay(user); err != nil || !allowed {
+		return err
+	}
+
 	if err := h.SendMessage(user, h.text.AdGuide); err != nil {
 		return err
 	}
@@ -267,9 +507,22 @@ func (h *Handlers) HandleAddAd(user *models.User) error {
 	return nil
 }
 
-func (h *Handlers) SendMessage(user *models.User, text string) error {
+// NewMessage starts a message to the user in the chat and forum topic the
+// conversation is in.
+func (h *Handlers) NewMessage(user *models.User, text string) tgbotapi.MessageConfig {
 	message := tgbotapi.NewMessage(user.Chatid, text)
 
+	if user.ReplyTo != 0 {
+		message.ReplyToMessageID = user.ReplyTo
+		message.AllowSendingWithoutReply = true
+	}
+
+	return message
+}
+
+func (h *Handlers) SendMessage(user *models.User, text string) error {
+	message := h.NewMessage(user, text)
+
 	_, err := h.bot.Send(message)
 	if err != nil {
 		return err
@@ -278,56 +531,344 @@ func (h *Handlers) SendMessage(user *models.User, text string) error {
 	return nil
 }
 
+func (h *Handlers) SendMessageTo(chatid int64, text string) error {
+	if _, err := h.bot.Send(tgbotapi.NewMessage(chatid, text)); err != nil {
+		return err
+	}
+
+	return nil
+}
+
 func (h *Handlers) SendPreview(user *models.User) error {
-	if _, err := h.bot.Send(h.CreateNewAdMessage(user, tgbotapi.ModeHTML)); err != nil {
+	message, err := h.CreateNewAdMessage(user, h.render.ParseMode())
+
+	if err != nil {
+		return err
+	}
+
+	if _, err := h.bot.Send(message); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func (h *Handlers) GetPreviewMarkup() tgbotapi.InlineKeyboardMarkup {
+func (h *Handlers) GetPreviewMarkup(ad *models.Advertisement) tgbotapi.InlineKeyboardMarkup {
 	return tgbotapi.NewInlineKeyboardMarkup(
 		tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonData(commands.SendButtonPair.ParamName, (commands.SendButtonPair.ParamValue).(string)),
+			h.Button(commands.SendButtonPair.ParamName, commands.SendButtonPair.ParamValue, DraftTag(ad)),
+			h.Button(commands.ScheduleButton.ParamName, commands.ScheduleButton.ParamValue, DraftTag(ad)),
 		),
 		tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonData(commands.ChangeTitleButton.ParamName, fmt.Sprintf("%s:%d", commands.ChangeTitleButton.ParamValue, models.StateWaitingForCTitle)),
-			tgbotapi.NewInlineKeyboardButtonData(commands.ChangeDescriptionButton.ParamName, fmt.Sprintf("%s:%d", commands.ChangeDescriptionButton.ParamValue, models.StateWaitingForCDescription)),
+			h.Button(commands.ChangeTitleButton.ParamName, commands.ChangeValueCommandData, models.StateWaitingForCTitle, DraftTag(ad)),
+			h.Button(commands.ChangeDescriptionButton.ParamName, commands.ChangeValueCommandData, models.StateWaitingForCDescription, DraftTag(ad)),
 		),
 		tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonData(commands.ChangePriceButton.ParamName, fmt.Sprintf("%s:%d", commands.ChangePriceButton.ParamValue, models.StateWaitingForCPrice)),
-			tgbotapi.NewInlineKeyboardButtonData(commands.ChangeCityButton.ParamName, fmt.Sprintf("%s:%d", commands.ChangeCityButton.ParamValue, models.StateWaitingForCCity)),
+			h.Button(commands.ChangePriceButton.ParamName, commands.ChangeValueCommandData, models.StateWaitingForCPrice, DraftTag(ad)),
+			h.Button(commands.ChangeCityButton.ParamName, commands.ChangeValueCommandData, models.StateWaitingForCCity, DraftTag(ad)),
 		),
+		h.GetDraftSettingsMarkup(ad),
 	)
 }
 
-func (h *Handlers) HandleCallbackQuery(query *tgbotapi.CallbackQuery) error {
-	querydata := strings.Split(query.Data, ":")
+func (h *Handlers) RouteCallbackQuery(query *tgbotapi.CallbackQuery, threadid int64, answer *tgbotapi.CallbackConfig) error {
+	if query.Message == nil {
+		return nil
+	}
 
-	user, err := h.db.GetUser(query.Message.Chat.ID)
+	payload, err := h.codec.Decode(query.Data)
+
+	if err != nil {
+		return h.DropStaleKeyboard(query.Message, answer)
+	}
+
+	user, err := h.db.GetUser(models.NewChatKey(query.Message.Chat.ID, query.From.ID, threadid))
 
 	if err != nil {
 		return err
 	}
 
-	switch querydata[0] {
+	if threadid != 0 {
+		user.ReplyTo = query.Message.MessageID
+	}
+
+	if user.Banned || !h.Throttle(query.Message.Chat.ID, query.From.ID) {
+		return nil
+	}
+
+	if draftCommands[payload.Action] && !IsCurrentDraft(user, payload) {
+		return h.DropStaleKeyboard(query.Message, answer)
+	}
+
+	if adminCallbacks[payload.Action] {
+		return h.RouteAdminCallback(user, query.Message, payload)
+	}
+
+	switch payload.Action {
 	case commands.SendButtonPair.ParamValue:
-		message := tgbotapi.NewMessageToChannel(h.settings.ManageChannelLink, formatters.FormatAdToMessageString(user.Context.Advertisement, user.Username))
-		message.ParseMode = tgbotapi.ModeHTML
+		if fits, err := h.CheckAdLength(user); err != nil || !fits {
+			return err
+		}
+
+		if allowed, err := h.CheckAdsPerDay(user); err != nil || !allowed {
+			return err
+		}
 
-		if DEBUG {
-			message.DisableNotification = true
+		if err := h.PressOnce(query.Message, answer, func() error {
+			return h.SendDraft(user, query.Message)
+		}); err != nil {
+			return err
+		}
+	case commands.ScheduleCommandData:
+		if err := h.AskForSchedule(user); err != nil {
+			return err
 		}
+	case commands.SchedulePresetCommandData:
+		unix, err := payload.Int(0)
 
-		_, err = h.bot.Send(message)
 		if err != nil {
 			return err
 		}
 
-		query.Message.ReplyMarkup = nil
+		if err := h.PressOnce(query.Message, answer, func() error {
+			if err := h.ScheduleAd(user, time.Unix(unix, 0)); err != nil {
+				return err
+			}
+
+			return h.RemoveInlineKeyboard(query.Message)
+		}); err != nil {
+			return err
+		}
+	case commands.CancelScheduleCommandData:
+		adid, err := payload.Int(0)
+
+		if err != nil {
+			return err
+		}
+
+		if err := h.CancelSchedule(user, adid); err != nil {
+			return err
+		}
+	case commands.RenewAdCommandData:
+		adid, err := payload.Int(0)
+
+		if err != nil {
+			return err
+		}
+
+		if err := h.RenewAd(user, adid); err != nil {
+			return err
+		}
+	case commands.MarkSoldCommandData:
+		adid, err := payload.Int(0)
+
+		if err != nil {
+			return err
+		}
+
+		if err := h.MarkAdSold(user, adid); err != nil {
+			return err
+		}
+	case commands.TruncateAdCommandData:
+		if err := h.TruncateAd(user); err != nil {
+			return err
+		}
+	case commands.PriceCurrencyCommandData:
+		currency, err := payload.String(0)
+
+		if err != nil {
+			return err
+		}
+
+		if err := h.ChoosePriceCurrency(user, query.Message, currency); err != nil {
+			return err
+		}
+	case commands.PriceTypeCommandData:
+		priceType, err := payload.Int(0)
+
+		if err != nil {
+			return err
+		}
+
+		if err := h.ChoosePriceType(user, query.Message, models.PriceType(priceType)); err != nil {
+			return err
+		}
+	case commands.PickCityCommandData:
+		cityid, err := payload.String(0)
+
+		if err != nil {
+			return err
+		}
+
+		if err := h.ChooseCity(user, query.Message, cityid); err != nil {
+			return err
+		}
+	case commands.KeepCityCommandData:
+		if err := h.KeepTypedCity(user, query.Message); err != nil {
+			return err
+		}
+	case commands.CategoryCommandData:
+		if err := h.AskForCategory(user); err != nil {
+			return err
+		}
+	case commands.PickCategoryCommandData:
+		category, err := payload.String(0)
+
+		if err != nil {
+			return err
+		}
+
+		if err := h.ChooseCategory(user, query.Message, category); err != nil {
+			return err
+		}
+	case commands.LifetimeCommandData:
+		if err := h.AskForLifetime(user); err != nil {
+			return err
+		}
+	case commands.PickLifetimeCommandData:
+		days, err := payload.Int(0)
+
+		if err != nil {
+			return err
+		}
+
+		if err := h.ChooseLifetime(user, query.Message, int(days)); err != nil {
+			return err
+		}
+	case commands.NearbyCommandData:
+		center, radius, err := ParseNearbyData(payload)
+
+		if err != nil {
+			return err
+		}
+
+		if err := h.SendNearbyAds(user, center, radius); err != nil {
+			return err
+		}
+	case commands.RelayReplyCommandData, commands.RelayBlockCommandData, commands.RelayReportCommandData:
+		relayid, err := payload.Int(0)
+
+		if err != nil {
+			return err
+		}
+
+		switch payload.Action {
+		case commands.RelayReplyCommandData:
+			err = h.SwitchRelay(user, relayid)
+		case commands.RelayBlockCommandData:
+			err = h.BlockRelay(user, relayid)
+		default:
+			err = h.ReportRelay(user, relayid)
+		}
+
+		if err != nil {
+			return err
+		}
+	case commands.AddFavoriteCommandData, commands.RemoveFavoriteCommandData:
+		adid, err := payload.Int(0)
+
+		if err != nil {
+			return err
+		}
+
+		if payload.Action == commands.RemoveFavoriteCommandData && len(payload.Args) > 1 {
+			page, err := payload.Int(1)
+
+			if err != nil {
+				return err
+			}
+
+			if err := h.db.RemoveFavorite(user.Id, adid); err != nil {
+				return err
+			}
+
+			if err := h.ShowFavoritesPage(user, query.Message, int(page)); err != nil {
+				return err
+			}
+		} else if err := h.ToggleFavorite(user, query.Message, adid, payload.Action == commands.AddFavoriteCommandData); err != nil {
+			return err
+		}
+	case commands.FavoritesPageCommandData:
+		page, err := payload.Int(0)
+
+		if err != nil {
+			return err
+		}
+
+		if err := h.ShowFavoritesPage(user, query.Message, int(page)); err != nil {
+			return err
+		}
+	case commands.ChangePriceCommandData:
+		adid, err := payload.Int(0)
+
+		if err != nil {
+			return err
+		}
+
+		if err := h.AskForNewPrice(user, adid); err != nil {
+			return err
+		}
+	case commands.ReportReasonCommandData:
+		adid, err := payload.Int(0)
+
+		if err != nil {
+			return err
+		}
+
+		reason, err := payload.String(1)
+
+		if err != nil {
+			return err
+		}
+
+		if err := h.ChooseReportReason(user, query.Message, adid, reason); err != nil {
+			return err
+		}
+	case commands.RestoreAdCommandData, commands.ApproveAdCommandData, commands.RemoveAdCommandData, commands.BanAuthorCommandData:
+		adid, err := payload.Int(0)
+
+		if err != nil {
+			return err
+		}
+
+		action := map[string]*models.ParamPair{
+			commands.RestoreAdCommandData: commands.RestoreAdButton,
+			commands.ApproveAdCommandData: commands.ApproveAdButton,
+			commands.RemoveAdCommandData:  commands.RemoveAdButton,
+			commands.BanAuthorCommandData: commands.BanAuthorButton,
+		}[payload.Action]
+
+		if err := h.ModerateAd(user, query.Message, adid, action); err != nil {
+			return err
+		}
+	case commands.BumpAdCommandData:
+		adid, err := payload.Int(0)
+
+		if err != nil {
+			return err
+		}
+
+		if err := h.BumpAd(user, query.Message, adid); err != nil {
+			return err
+		}
+	case commands.SendToReviewCommandData:
+		adid, err := payload.Int(0)
+
+		if err != nil {
+			return err
+		}
+
+		if err := h.PressOnce(query.Message, answer, func() error {
+			return h.SendDraftToReview(user, query.Message, adid)
+		}); err != nil {
+			return err
+		}
+	case commands.SkipSoldPriceCommandData:
+		if _, err := h.DropUserState(user); err != nil {
+			return err
+		}
 	case commands.ChangeValueCommandData:
-		statenum, err := strconv.Atoi(querydata[1])
+		statenum, err := payload.Int(0)
 
 		if err != nil {
 			return err
@@ -335,6 +876,10 @@ func (h *Handlers) HandleCallbackQuery(query *tgbotapi.CallbackQuery) error {
 
 		state := models.BotState(statenum)
 
+		if !editableStates[state] {
+			return commands.ErrBadCallback
+		}
+
 		if _, err := h.db.ChangeAdEditing(user, true); err != nil {
 			return err
 		}
@@ -344,19 +889,53 @@ func (h *Handlers) HandleCallbackQuery(query *tgbotapi.CallbackQuery) error {
 			return err
 		}
 
-		if err = h.SendMessage(user, h.text.NewParameterValue); err != nil {
+		if err = h.SendStatePrompt(user, h.text.NewParameterValue); err != nil {
 			return err
 		}
+	default:
+		return h.DropStaleKeyboard(query.Message, answer)
 	}
 
 	return nil
 }
 
+// SendDraft publishes the previewed draft right away once it passes the
+// content and duplicate checks.
+func (h *Handlers) SendDraft(user *models.User, message *tgbotapi.Message) error {
+	if clean, err := h.CheckContent(user, time.Now()); err != nil || !clean {
+		return err
+	}
+
+	if unique, err := h.CheckDuplicates(user, time.Now()); err != nil || !unique {
+		return err
+	}
+
+	ad, err := h.db.SaveAd(user, models.AdStatusPublishing, time.Now())
+
+	if err != nil {
+		return err
+	}
+
+	if err := h.PublishAd(ad, user); err != nil {
+		return h.DeferPublishing(user, ad, err)
+	}
+
+	if err := h.NotifyPublished(user, ad); err != nil {
+		return err
+	}
+
+	return h.RemoveInlineKeyboard(message)
+}
+
 func (h *Handlers) AskForKey(message *tgbotapi.Message) (*models.User, error) {
+	if message.IsCommand() && message.Command() == commands.StartCommand[1:] {
+		return h.HandleUnregisteredStart(message)
+	}
+
 	if message.Text == h.settings.SecretKey {
-		user, err := h.db.Register(message.Chat.ID, message.From.UserName)
+		user, err := h.db.Register(message.From.ID, message.Chat.ID, message.From.UserName, message.From.FirstName)
 		if err != nil {
-			log.Fatal(err)
+			return nil, err
 		}
 
 		message.Text = commands.StartCommand
@@ -364,7 +943,7 @@ func (h *Handlers) AskForKey(message *tgbotapi.Message) (*models.User, error) {
 		return user, nil
 	}
 
-	if err := h.SendMessage(models.NewUser(message.Chat.ID, "", nil), h.text.AccessOnlyByKey); err != nil {
+	if err := h.SendMessage(models.NewUser(message.From.ID, message.Chat.ID, message.From.UserName, message.From.FirstName, nil), h.text.AccessOnlyByKey); err != nil {
 		return nil, err
 	}
 
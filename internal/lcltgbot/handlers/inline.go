@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/iokinai/lcltgbot/internal/lcltgbot/commands"
+	"github.com/iokinai/lcltgbot/internal/lcltgbot/formatters"
+	"github.com/iokinai/lcltgbot/internal/lcltgbot/models"
+	"github.com/iokinai/lcltgbot/internal/lcltgbot/similarity"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	InlineResultsLimit = 20
+	InlineCacheTime    = time.Minute
+)
+
+type inlineResults struct {
+	ads     []*models.Advertisement
+	expires time.Time
+}
+
+// inlineCache keeps search results for a query while the user scrolls
+// through its pages and types the next letters.
+type inlineCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	results map[string]inlineResults
+}
+
+func newInlineCache(ttl time.Duration) *inlineCache {
+	return &inlineCache{ttl: ttl, results: map[string]inlineResults{}}
+}
+
+func (c *inlineCache) Get(query string, now time.Time) ([]*models.Advertisement, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, results := range c.results {
+		if now.After(results.expires) {
+			delete(c.results, key)
+		}
+	}
+
+	results, ok := c.results[query]
+
+	return results.ads, ok
+}
+
+func (c *inlineCache) Put(query string, ads []*models.Advertisement, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.results[query] = inlineResults{ads: ads, expires: now.Add(c.ttl)}
+}
+
+// HandleInlineQuery answers "@bot <words>" typed in any chat with cards of
+// published ads that contain all the words, newest first.
+func (h *Handlers) HandleInlineQuery(query *tgbotapi.InlineQuery) error {
+	ads, err := h.SearchAds(query.Query)
+
+	if err != nil {
+		return err
+	}
+
+	offset, err := strconv.Atoi(query.Offset)
+
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+
+	if offset > len(ads) {
+		offset = len(ads)
+	}
+
+	end := offset + InlineResultsLimit
+
+	if end > len(ads) {
+		end = len(ads)
+	}
+
+	locale := h.render.Locale(&models.User{Language: query.From.LanguageCode})
+
+	results := []interface{}{}
+
+	for _, ad := range ads[offset:end] {
+		article, err := h.InlineArticle(ad, locale)
+
+		if err != nil {
+			return err
+		}
+
+		results = append(results, article)
+	}
+
+	answer := tgbotapi.InlineConfig{
+		InlineQueryID: query.ID,
+		Results:       results,
+		CacheTime:     int(InlineCacheTime.Seconds()),
+	}
+
+	if end < len(ads) {
+		answer.NextOffset = strconv.Itoa(end)
+	}
+
+	if _, err := h.bot.Request(answer); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (h *Handlers) SearchAds(query string) ([]*models.Advertisement, error) {
+	query = similarity.Normalize(query)
+
+	if ads, ok := h.inline.Get(query, time.Now()); ok {
+		return ads, nil
+	}
+
+	published, err := h.db.GetPublishedAds()
+
+	if err != nil {
+		return nil, err
+	}
+
+	words := strings.Fields(query)
+	var ads []*models.Advertisement
+
+	for _, ad := range published {
+		if MatchesAll(similarity.Normalize(ad.Title+" "+ad.Description), words) {
+			ads = append(ads, ad)
+		}
+	}
+
+	h.inline.Put(query, ads, time.Now())
+
+	return ads, nil
+}
+
+func MatchesAll(text string, words []string) bool {
+	for _, word := range words {
+		if !strings.Contains(text, word) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (h *Handlers) InlineArticle(ad *models.Advertisement, locale string) (tgbotapi.InlineQueryResultArticle, error) {
+	text, err := h.render.SearchCard(ad, locale, nil)
+
+	if err != nil {
+		return tgbotapi.InlineQueryResultArticle{}, err
+	}
+
+	article := tgbotapi.NewInlineQueryResultArticle(strconv.FormatInt(ad.Id, 10), ad.Title, text)
+	article.InputMessageContent = tgbotapi.InputTextMessageContent{Text: text, ParseMode: h.render.ParseMode()}
+	article.Description = formatters.FormatPrice(ad, locale)
+
+	if ad.City != "" {
+		article.Description = fmt.Sprintf("%s · %s", article.Description, ad.City)
+	}
+
+	markup := h.GetInlineCardMarkup(ad)
+	article.ReplyMarkup = &markup
+
+	return article, nil
+}
+
+// GetInlineCardMarkup only has URL buttons: the card is sent on behalf of
+// the user to chats whose members may not be registered in the bot, so they
+// are led to the bot instead.
+func (h *Handlers) GetInlineCardMarkup(ad *models.Advertisement) tgbotapi.InlineKeyboardMarkup {
+	row := tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonURL(commands.ContactSellerButton, h.ContactURL(ad)))
+
+	if url, ok := h.PostURL(ad); ok {
+		row = append(row, tgbotapi.NewInlineKeyboardButtonURL(commands.OpenPostButton, url))
+	}
+
+	return tgbotapi.NewInlineKeyboardMarkup(row)
+}
@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/iokinai/lcltgbot/internal/lcltgbot/commands"
+	"github.com/iokinai/lcltgbot/internal/lcltgbot/formatters"
+	"github.com/iokinai/lcltgbot/internal/lcltgbot/models"
+)
+
+func (h *Handlers) ReadMoreURL(ad *models.Advertisement) string {
+	return h.DeepLink(commands.StartPayload{Action: commands.StartOpenAd, Id: ad.Id})
+}
+
+// CheckAdLength reports whether the draft fits into a channel post and warns
+// the user with a truncate offer when it doesn't.
+func (h *Handlers) CheckAdLength(user *models.User) (bool, error) {
+	ad := user.Context.Advertisement
+
+	_, err := h.render.ChannelPost(ad, h.ChannelContact(ad), h.ReadMoreURL(ad))
+
+	var tooLong *formatters.TooLongError
+
+	if !errors.As(err, &tooLong) {
+		return err == nil, err
+	}
+
+	message := h.NewMessage(user, fmt.Sprintf(h.text.AdTooLong, tooLong.Length, tooLong.Limit))
+	message.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			h.Button(commands.TruncateAdButton.ParamName, commands.TruncateAdButton.ParamValue, DraftTag(ad)),
+			h.Button(commands.ChangeDescriptionButton.ParamName, commands.ChangeValueCommandData, models.StateWaitingForCDescription, DraftTag(ad)),
+		),
+	)
+
+	if _, err := h.bot.Send(message); err != nil {
+		return false, err
+	}
+
+	return false, nil
+}
+
+func (h *Handlers) TruncateAd(user *models.User) error {
+	user, err := h.db.ChangeAdTruncated(user, true)
+
+	if err != nil {
+		return err
+	}
+
+	return h.SendMessage(user, h.text.AdTruncated)
+}
+
+func (h *Handlers) HandleFullText(user *models.User, adid int64) error {
+	ad, err := h.db.GetPublishedAd(adid)
+
+	if err != nil || ad.Status != models.AdStatusPublished {
+		return h.SendMessage(user, h.text.AdNotFound)
+	}
+
+	title := h.NewMessage(user, h.render.Bold(ad.Title))
+	title.ParseMode = h.render.ParseMode()
+
+	markup, err := h.GetAdCardMarkup(user, ad)
+
+	if err != nil {
+		return err
+	}
+
+	title.ReplyMarkup = markup
+
+	if _, err := h.bot.Send(title); err != nil {
+		return err
+	}
+
+	for _, chunk := range formatters.SplitText(ad.Description, ad.DescriptionEntities, formatters.MessageLimit) {
+		message := h.NewMessage(user, chunk.Text)
+
+		for _, entity := range chunk.Entities {
+			message.Entities = append(message.Entities, tgbotapi.MessageEntity{Type: entity.Type, Offset: entity.Offset, Length: entity.Length})
+		}
+
+		if _, err := h.bot.Send(message); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/iokinai/lcltgbot/internal/lcltgbot/formatters"
+	"github.com/iokinai/lcltgbot/internal/lcltgbot/models"
+	"log"
+	"time"
+)
+
+// PressDedupWindow is how long a press of a publishing button is remembered,
+// a second press of the same message within it is ignored.
+const PressDedupWindow = 10 * time.Minute
+
+// Throttle spends one of the user's message tokens. The first refused message
+// gets a "slow down" reply with the wait time, the following ones are dropped
+// silently until the user is allowed again.
+func (h *Handlers) Throttle(chatid int64, userid int64) bool {
+	allowed, retry, first := h.limiter.Allow(userid, time.Now())
+
+	if allowed {
+		return true
+	}
+
+	if first {
+		if err := h.SendMessageTo(chatid, fmt.Sprintf(h.text.SlowDown, formatters.FormatWait(retry))); err != nil {
+			log.Println(err)
+		}
+	}
+
+	return false
+}
+
+// PressOnce runs action for the first press of the message's buttons and
+// answers later ones as already in progress. A failed action forgets the
+// press, so the user can try the same button again.
+func (h *Handlers) PressOnce(message *tgbotapi.Message, answer *tgbotapi.CallbackConfig, action func() error) error {
+	key := fmt.Sprintf("%d:%d", message.Chat.ID, message.MessageID)
+
+	if !h.presses.First(key, time.Now()) {
+		answer.Text = h.text.AlreadyProcessing
+		return nil
+	}
+
+	if err := action(); err != nil {
+		h.presses.Forget(key)
+		return err
+	}
+
+	return nil
+}
+
+// CheckAdsPerDay tells the user when they can add the next ad if their role's
+// daily limit is used up.
+func (h *Handlers) CheckAdsPerDay(user *models.User) (bool, error) {
+	limit := h.settings.AdsPerDayLimit(user.Id)
+
+	if limit <= 0 {
+		return true, nil
+	}
+
+	now := time.Now()
+	created, err := h.db.GetAdCreationTimes(user.Id, now.Add(-24*time.Hour))
+
+	if err != nil {
+		return false, err
+	}
+
+	if len(created) < limit {
+		return true, nil
+	}
+
+	retry := created[len(created)-limit].Add(24 * time.Hour).Sub(now)
+
+	return false, h.SendMessage(user, fmt.Sprintf(h.text.AdsPerDayReached, limit, formatters.FormatWait(retry)))
+}
@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/iokinai/lcltgbot/internal/lcltgbot/commands"
+	"github.com/iokinai/lcltgbot/internal/lcltgbot/models"
+)
+
+func (h *Handlers) HandleMyAds(user *models.User) error {
+	ads, err := h.db.GetUserAds(user.Id)
+
+	if err != nil {
+		return err
+	}
+
+	if len(ads) == 0 {
+		return h.SendMessage(user, h.text.NoAds)
+	}
+
+	for _, ad := range ads {
+		text, err := h.render.Summary(ad, h.render.Locale(user))
+
+		if err != nil {
+			return err
+		}
+
+		message := h.NewMessage(user, text)
+		message.ParseMode = h.render.ParseMode()
+
+		if markup, ok := h.GetMyAdMarkup(ad); ok {
+			message.ReplyMarkup = markup
+		}
+
+		if _, err := h.bot.Send(message); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (h *Handlers) GetMyAdMarkup(ad *models.Advertisement) (tgbotapi.InlineKeyboardMarkup, bool) {
+	var buttons []tgbotapi.InlineKeyboardButton
+
+	switch ad.Status {
+	case models.AdStatusScheduled:
+		buttons = append(buttons, h.Button(commands.CancelScheduleButton.ParamName, commands.CancelScheduleButton.ParamValue, ad.Id))
+	case models.AdStatusPublished:
+		if h.AdLifetime(ad) > 0 {
+			buttons = append(buttons, h.Button(commands.RenewAdButton.ParamName, commands.RenewAdButton.ParamValue, ad.Id))
+		}
+
+		buttons = append(buttons, h.Button(commands.ChangePublishedPriceButton.ParamName, commands.ChangePublishedPriceButton.ParamValue, ad.Id))
+		buttons = append(buttons, h.Button(commands.MarkSoldButton.ParamName, commands.MarkSoldButton.ParamValue, ad.Id))
+	}
+
+	if len(buttons) == 0 {
+		return tgbotapi.InlineKeyboardMarkup{}, false
+	}
+
+	return tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(buttons...)), true
+}
@@ -0,0 +1,170 @@
+package handlers
+
+import (
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/iokinai/lcltgbot/internal/lcltgbot/commands"
+	"github.com/iokinai/lcltgbot/internal/lcltgbot/formatters"
+	"github.com/iokinai/lcltgbot/internal/lcltgbot/geo"
+	"github.com/iokinai/lcltgbot/internal/lcltgbot/models"
+	"sort"
+	"strings"
+)
+
+const NearbyResultsLimit = 10
+
+func (h *Handlers) HandleNearby(user *models.User) error {
+	user, err := h.db.ChangeUserState(user, models.StateWaitingForNearbyLocation)
+
+	if err != nil {
+		return err
+	}
+
+	message := h.NewMessage(user, h.text.AskNearbyLocation)
+	message.ReplyMarkup = h.GetLocationMarkup()
+
+	if _, err := h.bot.Send(message); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// HandleNearbyLocation offers the radius buttons for a shared location. The
+// point is kept in the callback data, so the user can try several radiuses.
+func (h *Handlers) HandleNearbyLocation(user *models.User, message *tgbotapi.Message) error {
+	if message.Location == nil {
+		return h.HandleNearby(user)
+	}
+
+	user, err := h.DropUserState(user)
+
+	if err != nil {
+		return err
+	}
+
+	var buttons []tgbotapi.InlineKeyboardButton
+
+	locale := h.render.Locale(user)
+
+	for _, radius := range commands.NearbyRadiuses {
+		latitude, longitude := fmt.Sprintf("%.5f", message.Location.Latitude), fmt.Sprintf("%.5f", message.Location.Longitude)
+		buttons = append(buttons, h.Button(formatters.FormatDistance(float64(radius), locale), commands.NearbyCommandData, radius, latitude, longitude))
+	}
+
+	reply := h.NewMessage(user, h.text.AskNearbyRadius)
+	reply.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(buttons)
+
+	if _, err := h.bot.Send(reply); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func ParseNearbyData(payload commands.Payload) (models.Location, float64, error) {
+	var values [3]float64
+
+	for i := range values {
+		value, err := payload.Float(i)
+
+		if err != nil {
+			return models.Location{}, 0, err
+		}
+
+		values[i] = value
+	}
+
+	return models.Location{Latitude: values[1], Longitude: values[2]}, values[0], nil
+}
+
+type nearbyAd struct {
+	ad       *models.Advertisement
+	distance float64
+}
+
+func (h *Handlers) SendNearbyAds(user *models.User, center models.Location, radius float64) error {
+	ads, err := h.db.GetPublishedAdsIn(geo.Around(center, radius))
+
+	if err != nil {
+		return err
+	}
+
+	var found []nearbyAd
+
+	for _, ad := range ads {
+		if ad.Location == nil {
+			continue
+		}
+
+		if distance := geo.Distance(center, *ad.Location); distance <= radius {
+			found = append(found, nearbyAd{ad: ad, distance: distance})
+		}
+	}
+
+	if len(found) == 0 {
+		return h.SendMessage(user, fmt.Sprintf(h.text.NothingNearby, radius))
+	}
+
+	sort.Slice(found, func(i, j int) bool {
+		return found[i].distance < found[j].distance
+	})
+
+	if len(found) > NearbyResultsLimit {
+		found = found[:NearbyResultsLimit]
+	}
+
+	for _, result := range found {
+		text, err := h.render.SearchCard(result.ad, h.render.Locale(user), &result.distance)
+
+		if err != nil {
+			return err
+		}
+
+		message := h.NewMessage(user, text)
+		message.ParseMode = h.render.ParseMode()
+
+		markup, err := h.GetAdCardMarkup(user, result.ad)
+
+		if err != nil {
+			return err
+		}
+
+		message.ReplyMarkup = markup
+
+		if _, err := h.bot.Send(message); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetAdCardMarkup is the keyboard under ads shown to buyers in the bot: save
+// to favorites and open the channel post.
+func (h *Handlers) GetAdCardMarkup(user *models.User, ad *models.Advertisement) (tgbotapi.InlineKeyboardMarkup, error) {
+	favorite, err := h.GetFavoriteButton(user, ad)
+
+	if err != nil {
+		return tgbotapi.InlineKeyboardMarkup{}, err
+	}
+
+	row := tgbotapi.NewInlineKeyboardRow(favorite)
+
+	if url, ok := h.PostURL(ad); ok {
+		row = append(row, tgbotapi.NewInlineKeyboardButtonURL(commands.OpenPostButton, url))
+	}
+
+	return tgbotapi.NewInlineKeyboardMarkup(row), nil
+}
+
+// PostURL links to the ad in the channel when the channel is public.
+func (h *Handlers) PostURL(ad *models.Advertisement) (string, bool) {
+	channel := strings.TrimPrefix(h.settings.ManageChannelLink, "@")
+
+	if channel == "" || channel == h.settings.ManageChannelLink || ad.ChannelMessageId == 0 {
+		return "", false
+	}
+
+	return fmt.Sprintf("https://t.me/%s/%d", channel, ad.ChannelMessageId), true
+}
@@ -0,0 +1,157 @@
+package handlers
+
+import (
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/iokinai/lcltgbot/internal/lcltgbot/commands"
+	"github.com/iokinai/lcltgbot/internal/lcltgbot/models"
+	"strconv"
+	"strings"
+)
+
+// ParsePrice accepts both "1500.5" and "1 500,5" as users type prices in
+// their own locale.
+func ParsePrice(text string) (float64, bool) {
+	text = strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', ' ', ' ':
+			return -1
+		case ',':
+			return '.'
+		}
+
+		return r
+	}, strings.TrimSpace(text))
+
+	price, err := strconv.ParseFloat(text, 64)
+
+	if err != nil || price < 0 {
+		return 0, false
+	}
+
+	return price, true
+}
+
+// SendStatePrompt asks for the value of the user's current state and adds the
+// currency and price type keyboard on the price step and the location button
+// on the city step.
+func (h *Handlers) SendStatePrompt(user *models.User, text string) error {
+	message := h.NewMessage(user, text)
+
+	switch user.Context.State {
+	case models.StateWaitingForCPrice:
+		message.ReplyMarkup = h.GetPriceMarkup(user.Context.Advertisement)
+	case models.StateWaitingForCCity:
+		message.ReplyMarkup = h.GetLocationMarkup()
+	}
+
+	if _, err := h.bot.Send(message); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (h *Handlers) GetPriceMarkup(ad *models.Advertisement) tgbotapi.InlineKeyboardMarkup {
+	var rows [][]tgbotapi.InlineKeyboardButton
+
+	if currencies := h.settings.SelectableCurrencies(); len(currencies) > 1 {
+		var row []tgbotapi.InlineKeyboardButton
+
+		for _, currency := range currencies {
+			label := currency
+
+			if currency == h.AdCurrency(ad) {
+				label = "✓ " + label
+			}
+
+			row = append(row, h.Button(label, commands.PriceCurrencyCommandData, currency))
+		}
+
+		rows = append(rows, row)
+	}
+
+	var row []tgbotapi.InlineKeyboardButton
+
+	for _, button := range commands.PriceTypeButtons {
+		priceType := (button.ParamValue).(models.PriceType)
+		label := button.ParamName
+
+		if priceType == ad.PriceType {
+			label = "✓ " + label
+		}
+
+		row = append(row, h.Button(label, commands.PriceTypeCommandData, priceType))
+	}
+
+	return tgbotapi.NewInlineKeyboardMarkup(append(rows, row)...)
+}
+
+func (h *Handlers) AdCurrency(ad *models.Advertisement) string {
+	if ad.Currency == "" {
+		return h.settings.Currency()
+	}
+
+	return ad.Currency
+}
+
+func (h *Handlers) ChoosePriceCurrency(user *models.User, message *tgbotapi.Message, currency string) error {
+	if user.Context.State != models.StateWaitingForCPrice {
+		return nil
+	}
+
+	allowed := false
+
+	for _, c := range h.settings.SelectableCurrencies() {
+		allowed = allowed || c == currency
+	}
+
+	if !allowed {
+		return nil
+	}
+
+	user, err := h.db.ChangeAdCurrency(user, currency)
+
+	if err != nil {
+		return err
+	}
+
+	return h.RefreshPriceMarkup(user, message)
+}
+
+// ChoosePriceType stores the price type. Free items and exchanges need no
+// amount, so they move the flow on right away.
+func (h *Handlers) ChoosePriceType(user *models.User, message *tgbotapi.Message, priceType models.PriceType) error {
+	if user.Context.State != models.StateWaitingForCPrice || priceType < models.PriceFixed || priceType > models.PriceExchange {
+		return nil
+	}
+
+	user, err := h.db.ChangeAdPriceType(user, priceType)
+
+	if err != nil {
+		return err
+	}
+
+	if priceType == models.PriceFixed || priceType == models.PriceNegotiable {
+		return h.RefreshPriceMarkup(user, message)
+	}
+
+	if user, err = h.db.ChangeAdPrice(user, 0); err != nil {
+		return err
+	}
+
+	if err := h.RemoveInlineKeyboard(message); err != nil {
+		return err
+	}
+
+	_, err = h.GoNextIfCreatingElseDropEditing(user, models.StateWaitingForCCity, h.text.EnterCity)
+
+	return err
+}
+
+func (h *Handlers) RefreshPriceMarkup(user *models.User, message *tgbotapi.Message) error {
+	if _, err := h.bot.Request(tgbotapi.NewEditMessageReplyMarkup(message.Chat.ID, message.MessageID, h.GetPriceMarkup(user.Context.Advertisement))); err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,246 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/iokinai/lcltgbot/internal/lcltgbot/commands"
+	"github.com/iokinai/lcltgbot/internal/lcltgbot/models"
+	"time"
+)
+
+func (h *Handlers) ContactURL(ad *models.Advertisement) string {
+	return h.DeepLink(commands.StartPayload{Action: commands.StartContactSeller, Id: ad.Id})
+}
+
+// ChannelContact replaces the seller's username in channel posts with a link
+// that opens an anonymous chat through the bot.
+func (h *Handlers) ChannelContact(ad *models.Advertisement) string {
+	return h.render.Link(h.text.ContactViaBot, h.ContactURL(ad))
+}
+
+func (h *Handlers) GetChannelPostMarkup(ad *models.Advertisement) tgbotapi.InlineKeyboardMarkup {
+	return tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonURL(commands.ContactSellerButton, h.ContactURL(ad)),
+		tgbotapi.NewInlineKeyboardButtonURL(commands.ReportAdButton, h.DeepLink(commands.StartPayload{Action: commands.StartReport, Id: ad.Id})),
+	))
+}
+
+func (h *Handlers) HandleContactSeller(user *models.User, adid int64) error {
+	ad, err := h.db.GetPublishedAd(adid)
+
+	if err != nil || ad.Status != models.AdStatusPublished {
+		return h.SendMessage(user, h.text.AdNotFound)
+	}
+
+	if ad.OwnerId == user.Id {
+		return h.SendMessage(user, h.text.RelayOwnAd)
+	}
+
+	blocked, err := h.db.IsRelayBlocked(ad.OwnerId, user.Id)
+
+	if err != nil {
+		return err
+	}
+
+	if blocked {
+		return h.SendMessage(user, h.text.RelayBlocked)
+	}
+
+	relay, err := h.db.OpenRelay(ad, user.Id)
+
+	if err != nil {
+		return err
+	}
+
+	if _, err := h.db.ChangeActiveRelay(user, relay.Id); err != nil {
+		return err
+	}
+
+	message := h.NewMessage(user, fmt.Sprintf(h.text.RelayStarted, ad.Title, commands.EndChatCommand))
+	message.ReplyMarkup = h.GetRelayMarkup(relay, user.Id)
+
+	if _, err := h.bot.Send(message); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// RelayMessage copies a message to the other side of the user's active chat.
+// The peer gets a header with the ad title unless this chat is already the one
+// their own messages go to.
+func (h *Handlers) RelayMessage(user *models.User, message *tgbotapi.Message) error {
+	relay, err := h.db.GetRelay(user.Context.RelayId)
+
+	if err != nil || relay.Status != models.RelayActive {
+		if _, err := h.db.ChangeActiveRelay(user, 0); err != nil {
+			return err
+		}
+
+		return h.SendMessage(user, h.text.RelayClosed)
+	}
+
+	peer, err := h.db.GetUser(models.PrivateChatKey(relay.Peer(user.Id)))
+
+	if err != nil {
+		return err
+	}
+
+	if peer.Context.RelayId != relay.Id {
+		ad, err := h.db.GetPublishedAd(relay.AdId)
+
+		if err != nil {
+			return err
+		}
+
+		text := h.text.RelayFromBuyer
+
+		if user.Id == relay.SellerId {
+			text = h.text.RelayFromSeller
+		}
+
+		header := tgbotapi.NewMessage(peer.Chatid, fmt.Sprintf(text, ad.Title))
+		header.ReplyMarkup = h.GetRelayMarkup(relay, peer.Id)
+
+		if _, err := h.bot.Send(header); err != nil {
+			return err
+		}
+
+		if peer.Context.RelayId == 0 {
+			if _, err := h.db.ChangeActiveRelay(peer, relay.Id); err != nil {
+				return err
+			}
+		}
+	}
+
+	if _, err := h.bot.Request(tgbotapi.NewCopyMessage(peer.Chatid, message.Chat.ID, message.MessageID)); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (h *Handlers) GetRelayMarkup(relay *models.Relay, userid int64) tgbotapi.InlineKeyboardMarkup {
+	buttons := []tgbotapi.InlineKeyboardButton{
+		h.Button(commands.RelayReplyButton.ParamName, commands.RelayReplyButton.ParamValue, relay.Id),
+	}
+
+	if userid == relay.SellerId {
+		buttons = append(buttons, h.Button(commands.RelayBlockButton.ParamName, commands.RelayBlockButton.ParamValue, relay.Id))
+	}
+
+	buttons = append(buttons, h.Button(commands.RelayReportButton.ParamName, commands.RelayReportButton.ParamValue, relay.Id))
+
+	return tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(buttons...))
+}
+
+func (h *Handlers) GetMemberRelay(user *models.User, relayid int64) (*models.Relay, error) {
+	relay, err := h.db.GetRelay(relayid)
+
+	if err != nil || !relay.HasMember(user.Id) {
+		return nil, errors.New("relay not found")
+	}
+
+	return relay, nil
+}
+
+func (h *Handlers) SwitchRelay(user *models.User, relayid int64) error {
+	relay, err := h.GetMemberRelay(user, relayid)
+
+	if err != nil {
+		return err
+	}
+
+	if relay.Status != models.RelayActive {
+		return h.SendMessage(user, h.text.RelayClosed)
+	}
+
+	ad, err := h.db.GetPublishedAd(relay.AdId)
+
+	if err != nil {
+		return err
+	}
+
+	if _, err := h.db.ChangeActiveRelay(user, relay.Id); err != nil {
+		return err
+	}
+
+	return h.SendMessage(user, fmt.Sprintf(h.text.RelaySwitched, ad.Title, commands.EndChatCommand))
+}
+
+func (h *Handlers) HandleEndChat(user *models.User) error {
+	if user.Context.RelayId == 0 {
+		return h.SendMessage(user, h.text.NoActiveChat)
+	}
+
+	relay, err := h.GetMemberRelay(user, user.Context.RelayId)
+
+	if err != nil {
+		if _, err := h.db.ChangeActiveRelay(user, 0); err != nil {
+			return err
+		}
+
+		return h.SendMessage(user, h.text.NoActiveChat)
+	}
+
+	return h.CloseRelay(user, relay, models.RelayEnded)
+}
+
+func (h *Handlers) BlockRelay(user *models.User, relayid int64) error {
+	relay, err := h.GetMemberRelay(user, relayid)
+
+	if err != nil || user.Id != relay.SellerId {
+		return err
+	}
+
+	return h.CloseRelay(user, relay, models.RelayBlocked)
+}
+
+// CloseRelay ends the chat for both sides, switching off their active chat if
+// it was this one.
+func (h *Handlers) CloseRelay(user *models.User, relay *models.Relay, status models.RelayStatus) error {
+	if relay.Status == models.RelayActive {
+		if err := h.db.CloseRelay(relay, status, time.Now()); err != nil {
+			return err
+		}
+	}
+
+	peer, err := h.db.GetUser(models.PrivateChatKey(relay.Peer(user.Id)))
+
+	if err != nil {
+		return err
+	}
+
+	for _, member := range []*models.User{user, peer} {
+		if member.Context.RelayId != relay.Id {
+			continue
+		}
+
+		if _, err := h.db.ChangeActiveRelay(member, 0); err != nil {
+			return err
+		}
+	}
+
+	if err := h.SendMessage(peer, h.text.RelayEndedByPeer); err != nil {
+		return err
+	}
+
+	return h.SendMessage(user, h.text.RelayEnded)
+}
+
+func (h *Handlers) ReportRelay(user *models.User, relayid int64) error {
+	relay, err := h.GetMemberRelay(user, relayid)
+
+	if err != nil {
+		return err
+	}
+
+	for _, admin := range h.settings.Admins {
+		if err := h.SendMessageTo(admin, fmt.Sprintf(h.text.RelayReportForAdmin, relay.Id, relay.AdId, user.Id, relay.Peer(user.Id))); err != nil {
+			return err
+		}
+	}
+
+	return h.SendMessage(user, h.text.RelayReported)
+}
@@ -0,0 +1,360 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/iokinai/lcltgbot/internal/lcltgbot/commands"
+	"github.com/iokinai/lcltgbot/internal/lcltgbot/formatters"
+	"github.com/iokinai/lcltgbot/internal/lcltgbot/models"
+	"strings"
+	"time"
+)
+
+const ReportCommentLimit = 500
+
+func (h *Handlers) HandleReportAd(user *models.User, adid int64) error {
+	ad, err := h.db.GetPublishedAd(adid)
+
+	if err != nil || ad.Status != models.AdStatusPublished {
+		return h.SendMessage(user, h.text.AdNotFound)
+	}
+
+	if ad.OwnerId == user.Id {
+		return h.SendMessage(user, h.text.ReportOwnAd)
+	}
+
+	if allowed, err := h.CanReport(user, ad.Id); err != nil || !allowed {
+		return err
+	}
+
+	if user, err = h.db.ChangeTargetAd(user, ad.Id); err != nil {
+		return err
+	}
+
+	if user, err = h.db.ChangeUserState(user, models.StateWaitingForReportReason); err != nil {
+		return err
+	}
+
+	message := h.NewMessage(user, fmt.Sprintf(h.text.AskReportReason, ad.Title))
+	message.ReplyMarkup = h.GetReportReasonMarkup(ad)
+
+	if _, err := h.bot.Send(message); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// CanReport allows one report per ad and a few per day, so a single reader
+// can't take down ads on their own. The refusal is sent to the user.
+func (h *Handlers) CanReport(user *models.User, adid int64) (bool, error) {
+	reported, err := h.db.HasReported(adid, user.Id)
+
+	if err != nil {
+		return false, err
+	}
+
+	if reported {
+		return false, h.SendMessage(user, h.text.ReportDuplicate)
+	}
+
+	count, err := h.db.CountReportsSince(user.Id, time.Now().Add(-24*time.Hour))
+
+	if err != nil {
+		return false, err
+	}
+
+	if count >= h.settings.ReporterDailyLimit() {
+		return false, h.SendMessage(user, h.text.ReportLimit)
+	}
+
+	return true, nil
+}
+
+func (h *Handlers) GetReportReasonMarkup(ad *models.Advertisement) tgbotapi.InlineKeyboardMarkup {
+	var rows [][]tgbotapi.InlineKeyboardButton
+
+	for _, reason := range commands.ReportReasonButtons {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			h.Button(reason.ParamName, commands.ReportReasonCommandData, ad.Id, reason.ParamValue),
+		))
+	}
+
+	return tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+func (h *Handlers) ChooseReportReason(user *models.User, message *tgbotapi.Message, adid int64, reason string) error {
+	if user.Context.IsInFlow && user.Context.State != models.StateWaitingForReportReason {
+		return h.SendMessage(user, fmt.Sprintf(h.text.InChainError, commands.CancelFlow))
+	}
+
+	if err := h.RemoveInlineKeyboard(message); err != nil {
+		return err
+	}
+
+	if reason != models.ReportOther {
+		return h.SubmitReport(user, adid, reason, "")
+	}
+
+	user, err := h.db.ChangeTargetAd(user, adid)
+
+	if err != nil {
+		return err
+	}
+
+	if user, err = h.db.ChangeUserState(user, models.StateWaitingForReportReason); err != nil {
+		return err
+	}
+
+	return h.SendMessage(user, h.text.AskReportComment)
+}
+
+func (h *Handlers) HandleReportReasonInput(user *models.User, text string) error {
+	comment := formatters.Truncate(strings.TrimSpace(text), ReportCommentLimit)
+
+	if comment == "" {
+		return h.SendMessage(user, h.text.AskReportComment)
+	}
+
+	return h.SubmitReport(user, user.Context.TargetAdId, models.ReportOther, comment)
+}
+
+// SubmitReport stores the report and hides the ad once enough readers have
+// complained about it.
+func (h *Handlers) SubmitReport(user *models.User, adid int64, reason string, comment string) error {
+	if user.Context.State == models.StateWaitingForReportReason {
+		var err error
+
+		if user, err = h.DropUserState(user); err != nil {
+			return err
+		}
+	}
+
+	ad, err := h.db.GetPublishedAd(adid)
+
+	if err != nil || ad.Status != models.AdStatusPublished {
+		return h.SendMessage(user, h.text.AdNotFound)
+	}
+
+	if ad.OwnerId == user.Id {
+		return h.SendMessage(user, h.text.ReportOwnAd)
+	}
+
+	if allowed, err := h.CanReport(user, ad.Id); err != nil || !allowed {
+		return err
+	}
+
+	saved, err := h.db.SaveReport(&models.Report{AdId: ad.Id, ReporterId: user.Id, Reason: reason, Comment: comment, CreatedAt: time.Now()})
+
+	if err != nil {
+		return err
+	}
+
+	if !saved {
+		return h.SendMessage(user, h.text.ReportDuplicate)
+	}
+
+	if err := h.SendMessage(user, h.text.ReportSaved); err != nil {
+		return err
+	}
+
+	reports, err := h.db.GetOpenReports(ad.Id)
+
+	if err != nil {
+		return err
+	}
+
+	if len(reports) < h.settings.HideThreshold() {
+		return nil
+	}
+
+	return h.HideReportedAd(ad, reports)
+}
+
+// HideReportedAd takes the ad down once enough users reported it. The admins
+// are told even when the post or the owner can't be reached, since the ad
+// is hidden and waits for them either way.
+func (h *Handlers) HideReportedAd(ad *models.Advertisement, reports []*models.Report) error {
+	if err := h.db.ChangeAdStatus(ad, models.AdStatusHidden); err != nil {
+		return err
+	}
+
+	var errs []error
+
+	if text, err := h.render.Moderated(ad); err != nil {
+		errs = append(errs, err)
+	} else if err := h.EditChannelPost(ad, text); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := h.SendMessageTo(ad.OwnerId, fmt.Sprintf(h.text.AdHiddenByReports, ad.Title)); err != nil {
+		errs = append(errs, err)
+	}
+
+	for _, admin := range h.settings.Admins {
+		message := tgbotapi.NewMessage(admin, fmt.Sprintf(h.text.ReportForAdmin, ad.Id, ad.Title, len(reports), ad.OwnerId, ReportSummary(reports)))
+		message.ReplyMarkup = h.GetModerationMarkup(ad)
+
+		if _, err := h.bot.Send(message); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// ReportSummary counts reports by reason in the order of the reason buttons
+// and lists the free-form comments after them.
+func ReportSummary(reports []*models.Report) string {
+	counts := map[string]int{}
+
+	for _, report := range reports {
+		counts[report.Reason]++
+	}
+
+	var lines []string
+
+	for _, reason := range commands.ReportReasonButtons {
+		if count := counts[reason.ParamValue.(string)]; count > 0 {
+			lines = append(lines, fmt.Sprintf("%s: %d", reason.ParamName, count))
+		}
+	}
+
+	for _, report := range reports {
+		if report.Comment != "" {
+			lines = append(lines, "«"+report.Comment+"»")
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func (h *Handlers) GetModerationMarkup(ad *models.Advertisement) tgbotapi.InlineKeyboardMarkup {
+	var buttons []tgbotapi.InlineKeyboardButton
+
+	first := commands.RestoreAdButton
+
+	if ad.Status == models.AdStatusPending {
+		first = commands.ApproveAdButton
+	}
+
+	for _, action := range []*models.ParamPair{first, commands.RemoveAdButton, commands.BanAuthorButton} {
+		buttons = append(buttons, h.Button(action.ParamName, action.ParamValue, ad.Id))
+	}
+
+	return tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(buttons...))
+}
+
+// ModerateAd applies an admin's decision to a hidden or pending ad. Every
+// admin gets the same buttons, so whoever is second only learns that it is
+// already done.
+func (h *Handlers) ModerateAd(user *models.User, message *tgbotapi.Message, adid int64, action *models.ParamPair) error {
+	if !h.settings.IsAdmin(user.Id) {
+		return h.SendMessage(user, h.text.AdminOnly)
+	}
+
+	ad, err := h.db.GetPublishedAd(adid)
+
+	if err != nil {
+		return err
+	}
+
+	if err := h.RemoveInlineKeyboard(message); err != nil {
+		return err
+	}
+
+	if ad.Status != models.AdStatusHidden && ad.Status != models.AdStatusPending {
+		return h.SendMessage(user, h.text.AlreadyModerated)
+	}
+
+	switch action {
+	case commands.RestoreAdButton, commands.ApproveAdButton:
+		if ad.Status == models.AdStatusPending {
+			err = h.ApproveAd(ad)
+		} else {
+			err = h.RestoreAd(ad)
+		}
+	case commands.RemoveAdButton:
+		err = h.RemoveAd(ad)
+	case commands.BanAuthorButton:
+		err = h.BanAuthor(ad.OwnerId)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return h.SendMessage(user, fmt.Sprintf(h.text.ModerationDone, action.ParamName))
+}
+
+func (h *Handlers) RestoreAd(ad *models.Advertisement) error {
+	if err := h.db.ChangeAdStatus(ad, models.AdStatusPublished); err != nil {
+		return err
+	}
+
+	if err := h.db.ResolveReports(ad.Id, time.Now()); err != nil {
+		return err
+	}
+
+	if err := h.UpdateChannelPost(ad); err != nil {
+		return err
+	}
+
+	return h.SendMessageTo(ad.OwnerId, fmt.Sprintf(h.text.AdRestored, ad.Title))
+}
+
+func (h *Handlers) RemoveAd(ad *models.Advertisement) error {
+	if err := h.WithdrawAd(ad); err != nil {
+		return err
+	}
+
+	return h.SendMessageTo(ad.OwnerId, fmt.Sprintf(h.text.AdRemovedByAdmin, ad.Title))
+}
+
+func (h *Handlers) WithdrawAd(ad *models.Advertisement) error {
+	if err := h.db.ChangeAdStatus(ad, models.AdStatusRemoved); err != nil {
+		return err
+	}
+
+	if err := h.db.ResolveReports(ad.Id, time.Now()); err != nil {
+		return err
+	}
+
+	text, err := h.render.Moderated(ad)
+
+	if err != nil {
+		return err
+	}
+
+	return h.EditChannelPost(ad, text)
+}
+
+// BanAuthor blocks the user in the bot and withdraws everything they have
+// published or scheduled.
+func (h *Handlers) BanAuthor(userid int64) error {
+	if err := h.db.BanUser(userid, time.Now()); err != nil {
+		return err
+	}
+
+	ads, err := h.db.GetUserAds(userid)
+
+	if err != nil {
+		return err
+	}
+
+	for _, ad := range ads {
+		switch ad.Status {
+		case models.AdStatusScheduled:
+			err = h.db.ChangeAdStatus(ad, models.AdStatusCanceled)
+		case models.AdStatusPublished, models.AdStatusHidden, models.AdStatusPending:
+			err = h.WithdrawAd(ad)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return h.SendMessageTo(userid, h.text.UserBanned)
+}
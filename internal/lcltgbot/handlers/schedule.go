@@ -0,0 +1,291 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/iokinai/lcltgbot/internal/lcltgbot/commands"
+	"github.com/iokinai/lcltgbot/internal/lcltgbot/models"
+	"log"
+	"strings"
+	"time"
+)
+
+var scheduleLayouts = []string{"02.01.2006 15:04", "02.01 15:04", "15:04"}
+
+// RecordAttempts is how many times the bookkeeping of a sent channel post is
+// tried before giving up.
+const RecordAttempts = 3
+
+// PublishAd posts the ad to the channel. It only fails when nothing was
+// posted: once the post is out the ad must not go back to scheduled, so
+// failing to record it is logged and the ad stays publishing.
+func (h *Handlers) PublishAd(ad *models.Advertisement, owner *models.User) error {
+	text, err := h.render.ChannelPost(ad, h.ChannelContact(ad), h.ReadMoreURL(ad))
+
+	if err != nil {
+		return err
+	}
+
+	message := tgbotapi.NewMessageToChannel(h.settings.ManageChannelLink, text)
+	message.ParseMode = h.render.ParseMode()
+	message.ReplyMarkup = h.GetChannelPostMarkup(ad)
+
+	if DEBUG {
+		message.DisableNotification = true
+	}
+
+	sent, err := h.bot.Send(message)
+
+	if err != nil {
+		return err
+	}
+
+	if err := h.RecordPost(ad, sent, time.Now()); err != nil {
+		log.Printf("ad %d posted as message %d but not recorded: %v", ad.Id, sent.MessageID, err)
+	}
+
+	return nil
+}
+
+func (h *Handlers) RecordPost(ad *models.Advertisement, sent tgbotapi.Message, publishedAt time.Time) error {
+	if err := Retry(RecordAttempts, time.Second, func() error {
+		return h.db.MarkAdPublished(ad, sent.Chat.ID, sent.MessageID, publishedAt)
+	}); err != nil {
+		return err
+	}
+
+	lifetime := h.AdLifetime(ad)
+
+	if lifetime <= 0 {
+		return nil
+	}
+
+	return Retry(RecordAttempts, time.Second, func() error {
+		return h.db.SetAdExpiry(ad, publishedAt.Add(lifetime))
+	})
+}
+
+// Retry runs write until it succeeds, at most attempts times with delay in
+// between, and returns the last error.
+func Retry(attempts int, delay time.Duration, write func() error) error {
+	var err error
+
+	for attempt := 0; attempt < attempts; attempt++ {
+		if attempt > 0 {
+			time.Sleep(delay)
+		}
+
+		if err = write(); err == nil {
+			return nil
+		}
+	}
+
+	return err
+}
+
+func (h *Handlers) PublishDueAds(now time.Time) error {
+	ads, err := h.db.GetDueAds(now)
+
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+
+	for _, ad := range ads {
+		owner, err := h.db.GetUser(models.PrivateChatKey(ad.OwnerId))
+
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		claimed, err := h.db.ClaimAd(ad)
+
+		if err != nil || !claimed {
+			errs = append(errs, err)
+			continue
+		}
+
+		if err := h.PublishAd(ad, owner); err != nil {
+			errs = append(errs, err, h.db.ChangeAdStatus(ad, models.AdStatusScheduled))
+			continue
+		}
+
+		if err := h.NotifyPublished(owner, ad); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// DeferPublishing hands an ad that failed to go out right away over to the
+// scheduler and lets the owner know it will be posted a bit later.
+func (h *Handlers) DeferPublishing(user *models.User, ad *models.Advertisement, cause error) error {
+	if err := h.db.ChangeAdStatus(ad, models.AdStatusScheduled); err != nil {
+		return errors.Join(cause, err)
+	}
+
+	log.Println(cause)
+
+	return h.SendMessage(user, h.text.PublishDeferred)
+}
+
+func (h *Handlers) AskForSchedule(user *models.User) error {
+	if fits, err := h.CheckAdLength(user); err != nil || !fits {
+		return err
+	}
+
+	user, err := h.db.ChangeUserState(user, models.StateWaitingForSchedule)
+
+	if err != nil {
+		return err
+	}
+
+	message := h.NewMessage(user, h.text.AskSchedule)
+	message.ReplyMarkup = h.GetSchedulePresetsMarkup(user.Context.Advertisement, time.Now().In(h.settings.Location()))
+
+	if _, err := h.bot.Send(message); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (h *Handlers) GetSchedulePresetsMarkup(ad *models.Advertisement, now time.Time) tgbotapi.InlineKeyboardMarkup {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	tomorrow := today.AddDate(0, 0, 1)
+
+	presets := []struct {
+		day  string
+		hour int
+		at   time.Time
+	}{
+		{h.text.PresetToday, 18, today},
+		{h.text.PresetTomorrow, 9, tomorrow},
+		{h.text.PresetTomorrow, 12, tomorrow},
+		{h.text.PresetTomorrow, 18, tomorrow},
+	}
+
+	var buttons []tgbotapi.InlineKeyboardButton
+
+	for _, preset := range presets {
+		at := preset.at.Add(time.Duration(preset.hour) * time.Hour)
+
+		if !at.After(now) {
+			continue
+		}
+
+		label := fmt.Sprintf(preset.day, fmt.Sprintf("%d:00", preset.hour))
+		buttons = append(buttons, h.Button(label, commands.SchedulePresetCommandData, at.Unix(), DraftTag(ad)))
+	}
+
+	var rows [][]tgbotapi.InlineKeyboardButton
+
+	for i := 0; i < len(buttons); i += 2 {
+		end := i + 2
+
+		if end > len(buttons) {
+			end = len(buttons)
+		}
+
+		rows = append(rows, buttons[i:end])
+	}
+
+	return tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+func (h *Handlers) HandleScheduleInput(user *models.User, text string) error {
+	at, ok := ParseScheduleTime(text, time.Now().In(h.settings.Location()))
+
+	if !ok {
+		return h.SendMessage(user, h.text.WrongSchedule)
+	}
+
+	return h.ScheduleAd(user, at)
+}
+
+func ParseScheduleTime(text string, now time.Time) (time.Time, bool) {
+	text = strings.TrimSpace(text)
+
+	for _, layout := range scheduleLayouts {
+		parsed, err := time.ParseInLocation(layout, text, now.Location())
+
+		if err != nil {
+			continue
+		}
+
+		switch layout {
+		case "02.01 15:04":
+			parsed = parsed.AddDate(now.Year(), 0, 0)
+
+			if parsed.Before(now) {
+				parsed = parsed.AddDate(1, 0, 0)
+			}
+		case "15:04":
+			parsed = time.Date(now.Year(), now.Month(), now.Day(), parsed.Hour(), parsed.Minute(), 0, 0, now.Location())
+
+			if parsed.Before(now) {
+				parsed = parsed.AddDate(0, 0, 1)
+			}
+		}
+
+		return parsed, true
+	}
+
+	return time.Time{}, false
+}
+
+func (h *Handlers) ScheduleAd(user *models.User, at time.Time) error {
+	if !at.After(time.Now()) {
+		return h.SendMessage(user, h.text.ScheduleInPast)
+	}
+
+	if fits, err := h.CheckAdLength(user); err != nil || !fits {
+		return err
+	}
+
+	if allowed, err := h.CheckAdsPerDay(user); err != nil || !allowed {
+		return err
+	}
+
+	if clean, err := h.CheckContent(user, at); err != nil || !clean {
+		return err
+	}
+
+	if unique, err := h.CheckDuplicates(user, at); err != nil || !unique {
+		return err
+	}
+
+	if _, err := h.db.SaveAd(user, models.AdStatusScheduled, at); err != nil {
+		return err
+	}
+
+	user, err := h.DropUserState(user)
+
+	if err != nil {
+		return err
+	}
+
+	return h.SendMessage(user, fmt.Sprintf(h.text.AdScheduled, at.In(h.settings.Location()).Format("02.01.2006 15:04")))
+}
+
+func (h *Handlers) CancelSchedule(user *models.User, adid int64) error {
+	ad, err := h.db.GetPublishedAd(adid)
+
+	if err != nil {
+		return err
+	}
+
+	if ad.OwnerId != user.Id || ad.Status != models.AdStatusScheduled {
+		return errors.New("ad can't be canceled")
+	}
+
+	if err := h.db.ChangeAdStatus(ad, models.AdStatusCanceled); err != nil {
+		return err
+	}
+
+	return h.SendMessage(user, h.text.ScheduleCanceled)
+}
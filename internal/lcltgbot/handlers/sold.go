@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/iokinai/lcltgbot/internal/lcltgbot/commands"
+	"github.com/iokinai/lcltgbot/internal/lcltgbot/models"
+	"time"
+)
+
+func (h *Handlers) NotifyPublished(owner *models.User, ad *models.Advertisement) error {
+	message := tgbotapi.NewMessage(owner.Id, h.text.AdPublished)
+	message.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			h.Button(commands.MarkSoldButton.ParamName, commands.MarkSoldButton.ParamValue, ad.Id),
+		),
+	)
+
+	if _, err := h.bot.Send(message); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (h *Handlers) MarkAdSold(user *models.User, adid int64) error {
+	ad, err := h.GetOwnedAd(user, adid, models.AdStatusPublished)
+
+	if err != nil {
+		return err
+	}
+
+	// The post is closed first: should that fail, the ad is still published
+	// and the owner can press the button again.
+	sold := *ad
+	sold.Status, sold.SoldAt = models.AdStatusSold, time.Now()
+
+	text, err := h.render.Sold(&sold)
+
+	if err != nil {
+		return err
+	}
+
+	if err := h.EditChannelPost(ad, text); err != nil {
+		return err
+	}
+
+	if err := h.db.MarkAdSold(ad, sold.SoldAt); err != nil {
+		return err
+	}
+
+	if err := h.SendMessage(user, h.text.AdMarkedSold); err != nil {
+		return err
+	}
+
+	if user.Context.IsInFlow {
+		return nil
+	}
+
+	user, err = h.db.ChangeTargetAd(user, ad.Id)
+
+	if err != nil {
+		return err
+	}
+
+	user, err = h.db.ChangeUserState(user, models.StateWaitingForSoldPrice)
+
+	if err != nil {
+		return err
+	}
+
+	message := h.NewMessage(user, h.text.AskSoldPrice)
+	message.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			h.Button(commands.SkipSoldPriceButton.ParamName, commands.SkipSoldPriceButton.ParamValue),
+		),
+	)
+
+	if _, err := h.bot.Send(message); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (h *Handlers) HandleSoldPriceInput(user *models.User, text string) error {
+	price, ok := ParsePrice(text)
+
+	if !ok {
+		return h.SendMessage(user, h.text.WrongPrice)
+	}
+
+	if err := h.db.SetAdSoldPrice(user.Context.TargetAdId, price); err != nil {
+		return err
+	}
+
+	user, err := h.DropUserState(user)
+
+	if err != nil {
+		return err
+	}
+
+	return h.SendMessage(user, h.text.SoldPriceSaved)
+}
@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/iokinai/lcltgbot/internal/lcltgbot/commands"
+	"github.com/iokinai/lcltgbot/internal/lcltgbot/models"
+	"strconv"
+	"strings"
+)
+
+func (h *Handlers) DeepLink(payload commands.StartPayload) string {
+	return fmt.Sprintf("https://t.me/%s?start=%s", h.me.UserName, payload)
+}
+
+func (h *Handlers) HandleStartPayload(user *models.User, payload commands.StartPayload) error {
+	switch payload.Action {
+	case commands.StartOpenAd:
+		return h.HandleFullText(user, payload.Id)
+	case commands.StartContactSeller:
+		return h.HandleContactSeller(user, payload.Id)
+	case commands.StartReport:
+		return h.HandleReportAd(user, payload.Id)
+	}
+
+	return h.HandleStart(user)
+}
+
+// HandleUnregisteredStart lets an invite link register the user without the
+// secret key, and so do the contact and report buttons of channel posts, whose
+// readers need not have a key. A referral is remembered so it is counted once
+// the user gets in, any other link still asks for the key.
+func (h *Handlers) HandleUnregisteredStart(message *tgbotapi.Message) (*models.User, error) {
+	guest := models.NewUser(message.From.ID, message.Chat.ID, message.From.UserName, message.From.FirstName, nil)
+	payload := commands.ParseStartPayload(message.CommandArguments())
+
+	switch payload.Action {
+	case commands.StartInvite:
+		invite, err := h.db.UseInvite(payload.Code)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if invite == nil {
+			return nil, h.SendMessage(guest, h.text.InviteInvalid)
+		}
+
+		user, err := h.db.Register(message.From.ID, message.Chat.ID, message.From.UserName, message.From.FirstName)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if err := h.db.SaveReferral(user.Id, invite.CreatedBy); err != nil {
+			return nil, err
+		}
+
+		if err := h.SendMessage(user, h.text.InviteAccepted); err != nil {
+			return nil, err
+		}
+
+		message.Text = commands.StartCommand
+
+		return user, nil
+	case commands.StartContactSeller, commands.StartReport:
+		// The payload stays in the text and is handled as for any user.
+		return h.db.Register(message.From.ID, message.Chat.ID, message.From.UserName, message.From.FirstName)
+	case commands.StartReferral:
+		if payload.Id != message.From.ID {
+			if err := h.db.SaveReferral(message.From.ID, payload.Id); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return nil, h.SendMessage(guest, h.text.AccessOnlyByKey)
+}
+
+func (h *Handlers) HandleInvite(user *models.User, args string) error {
+	if !h.settings.IsAdmin(user.Id) {
+		return h.SendMessage(user, h.text.AdminOnly)
+	}
+
+	uses := 1
+
+	if args = strings.TrimSpace(args); args != "" {
+		parsed, err := strconv.Atoi(args)
+
+		if err != nil || parsed < 0 {
+			return h.SendMessage(user, h.text.WrongInviteUses)
+		}
+
+		uses = parsed
+	}
+
+	code, err := NewInviteCode()
+
+	if err != nil {
+		return err
+	}
+
+	if err := h.db.CreateInvite(code, user.Id, uses); err != nil {
+		return err
+	}
+
+	return h.SendMessage(user, fmt.Sprintf(h.text.InviteCreated, h.DeepLink(commands.StartPayload{Action: commands.StartInvite, Code: code})))
+}
+
+func (h *Handlers) HandleReferral(user *models.User) error {
+	count, err := h.db.CountReferrals(user.Id)
+
+	if err != nil {
+		return err
+	}
+
+	return h.SendMessage(user, fmt.Sprintf(h.text.ReferralLink, h.DeepLink(commands.StartPayload{Action: commands.StartReferral, Id: user.Id}), count))
+}
+
+func NewInviteCode() (string, error) {
+	random := make([]byte, 10)
+
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+
+	return strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(random)), nil
+}
@@ -1,29 +1,368 @@
 package models
 
+import (
+	"crypto/sha256"
+	"errors"
+	"time"
+)
+
 type AppSettings struct {
-	Key               string `json:"key"`
-	SecretKey         string `json:"secretKey"`
-	ManageChannelLink string `json:"manageChannelLink"`
-	DatabasePath      string `json:"databasePath"`
+	Key               string         `json:"key"`
+	SecretKey         string         `json:"secretKey"`
+	ManageChannelLink string         `json:"manageChannelLink"`
+	DatabasePath      string         `json:"databasePath"`
+	Admins            []int64        `json:"admins"`
+	GroupsEnabled     bool           `json:"groupsEnabled"`
+	Timezone          string         `json:"timezone"`
+	AdLifetimeDays    int            `json:"adLifetimeDays"`
+	ReminderDays      int            `json:"reminderDays"`
+	DeleteExpiredPost bool           `json:"deleteExpiredPost"`
+	ParseMode         string         `json:"parseMode"`
+	DefaultCurrency   string         `json:"defaultCurrency"`
+	Currencies        []string       `json:"currencies"`
+	Locale            string         `json:"locale"`
+	ReportThreshold   int            `json:"reportThreshold"`
+	ReportsPerDay     int            `json:"reportsPerDay"`
+	MessagesPerMinute int            `json:"messagesPerMinute"`
+	MessageBurst      int            `json:"messageBurst"`
+	AdsPerDay         map[string]int `json:"adsPerDay"`
+	DuplicateDays     int            `json:"duplicateDays"`
+	CallbackKey       string         `json:"callbackKey"`
+	SendRate          float64        `json:"sendRate"`
+	Categories        []AdCategory   `json:"categories"`
+}
+
+// AdCategory is a category owners can file their ads under. LifetimeDays
+// overrides the global lifetime for ads in it.
+type AdCategory struct {
+	Name         string `json:"name"`
+	LifetimeDays int    `json:"lifetimeDays"`
+}
+
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+var defaultAdsPerDay = map[string]int{RoleUser: 10, RoleAdmin: 0}
+
+func (s *AppSettings) Currency() string {
+	if s.DefaultCurrency == "" {
+		return "RUB"
+	}
+
+	return s.DefaultCurrency
+}
+
+func (s *AppSettings) SelectableCurrencies() []string {
+	if len(s.Currencies) == 0 {
+		return []string{s.Currency()}
+	}
+
+	return s.Currencies
+}
+
+// HideThreshold is how many open reports hide an ad until an admin reviews it.
+func (s *AppSettings) HideThreshold() int {
+	if s.ReportThreshold <= 0 {
+		return 3
+	}
+
+	return s.ReportThreshold
+}
+
+func (s *AppSettings) ReporterDailyLimit() int {
+	if s.ReportsPerDay <= 0 {
+		return 5
+	}
+
+	return s.ReportsPerDay
+}
+
+func (s *AppSettings) Role(userid int64) string {
+	if s.IsAdmin(userid) {
+		return RoleAdmin
+	}
+
+	return RoleUser
+}
+
+// MessageRate is how many messages and button presses a user gets per second,
+// with MessageBurst of them allowed back to back.
+func (s *AppSettings) MessageRate() (float64, int) {
+	perMinute, burst := s.MessagesPerMinute, s.MessageBurst
+
+	if perMinute <= 0 {
+		perMinute = 30
+	}
+
+	if burst <= 0 {
+		burst = 10
+	}
+
+	return float64(perMinute) / 60, burst
+}
+
+// AdsPerDayLimit is how many ads the user may create in 24 hours, zero means
+// no limit.
+func (s *AppSettings) AdsPerDayLimit(userid int64) int {
+	role := s.Role(userid)
+
+	if limit, ok := s.AdsPerDay[role]; ok {
+		return limit
+	}
+
+	return defaultAdsPerDay[role]
+}
+
+// CallbackSecret signs button data. Without a configured key it is derived
+// from the bot token, which is just as secret and stable across restarts.
+func (s *AppSettings) CallbackSecret() []byte {
+	if s.CallbackKey != "" {
+		return []byte(s.CallbackKey)
+	}
+
+	sum := sha256.Sum256([]byte("callbacks:" + s.Key))
+
+	return sum[:]
+}
+
+// OutgoingRate is how many requests per second the bot makes to Telegram in
+// total, all chats together.
+func (s *AppSettings) OutgoingRate() float64 {
+	if s.SendRate <= 0 {
+		return 30
+	}
+
+	return s.SendRate
+}
+
+// DuplicateWindow is how far back new ads are compared with existing ones.
+func (s *AppSettings) DuplicateWindow() time.Duration {
+	if s.DuplicateDays <= 0 {
+		return 7 * 24 * time.Hour
+	}
+
+	return time.Duration(s.DuplicateDays) * 24 * time.Hour
+}
+
+// AdLifetime is how long ads stay in the channel unless their category or
+// owner says otherwise: 30 days by default, zero when AdLifetimeDays is
+// negative and ads never expire.
+func (s *AppSettings) AdLifetime() time.Duration {
+	switch {
+	case s.AdLifetimeDays < 0:
+		return 0
+	case s.AdLifetimeDays == 0:
+		return 30 * 24 * time.Hour
+	}
+
+	return time.Duration(s.AdLifetimeDays) * 24 * time.Hour
+}
+
+func (s *AppSettings) Category(name string) (AdCategory, bool) {
+	for _, category := range s.Categories {
+		if category.Name == name {
+			return category, true
+		}
+	}
+
+	return AdCategory{}, false
+}
+
+// CategoryLifetime is how long ads of the category stay in the channel.
+func (s *AppSettings) CategoryLifetime(name string) time.Duration {
+	if category, ok := s.Category(name); ok && category.LifetimeDays > 0 {
+		return time.Duration(category.LifetimeDays) * 24 * time.Hour
+	}
+
+	return s.AdLifetime()
+}
+
+func (s *AppSettings) ReminderBefore() time.Duration {
+	return time.Duration(s.ReminderDays) * 24 * time.Hour
+}
+
+func (s *AppSettings) Location() *time.Location {
+	if s.Timezone == "" {
+		return time.Local
+	}
+
+	location, err := time.LoadLocation(s.Timezone)
+
+	if err != nil {
+		return time.Local
+	}
+
+	return location
+}
+
+func (s *AppSettings) IsAdmin(userid int64) bool {
+	for _, admin := range s.Admins {
+		if admin == userid {
+			return true
+		}
+	}
+
+	return false
 }
 
 func NewBotData(key string) *AppSettings {
 	return &AppSettings{Key: key}
 }
 
+type AdStatus int8
+
+const (
+	AdStatusScheduled AdStatus = iota + 1
+	AdStatusPublished
+	AdStatusCanceled
+	AdStatusSold
+	AdStatusExpired
+	AdStatusHidden
+	AdStatusRemoved
+	AdStatusPending
+	AdStatusPublishing
+)
+
+func (s AdStatus) String() string {
+	switch s {
+	case AdStatusScheduled:
+		return "scheduled"
+	case AdStatusPublished:
+		return "published"
+	case AdStatusCanceled:
+		return "canceled"
+	case AdStatusSold:
+		return "sold"
+	case AdStatusExpired:
+		return "expired"
+	case AdStatusHidden:
+		return "hidden"
+	case AdStatusRemoved:
+		return "removed"
+	case AdStatusPending:
+		return "pending"
+	case AdStatusPublishing:
+		return "publishing"
+	}
+
+	return "unknown"
+}
+
+type PriceType int8
+
+const (
+	PriceFixed PriceType = iota
+	PriceNegotiable
+	PriceFree
+	PriceExchange
+)
+
+type Location struct {
+	Latitude  float64
+	Longitude float64
+}
+
+type Entity struct {
+	Type   string `json:"type"`
+	Offset int    `json:"offset"`
+	Length int    `json:"length"`
+}
+
 type Advertisement struct {
-	Id          int64
-	Title       string
-	Description string
-	Price       float64
-	City        string
-	Editing     bool
+	Id                  int64
+	Title               string
+	TitleEntities       []Entity
+	Description         string
+	DescriptionEntities []Entity
+	Price               float64
+	Currency            string
+	PriceType           PriceType
+	City                string
+	CityId              string
+	Location            *Location
+	Editing             bool
+	Truncated           bool
+	Revision            int64
+	OwnerId             int64
+	Status              AdStatus
+	PublishAt           time.Time
+	PublishedAt         time.Time
+	ChannelChatId       int64
+	ChannelMessageId    int
+	ExpiresAt           time.Time
+	RemindedAt          time.Time
+	SoldAt              time.Time
+	SoldPrice           float64
+	Category            string
+	LifetimeDays        int
 }
 
 func NewAdvertisement(id int64, title string, description string, price float64, city string, editing bool) *Advertisement {
 	return &Advertisement{Id: id, Title: title, Description: description, Price: price, City: city, Editing: editing}
 }
 
+type Invite struct {
+	Code      string
+	CreatedBy int64
+	MaxUses   int
+	Uses      int
+	CreatedAt time.Time
+}
+
+const (
+	ReportScam       = "scam"
+	ReportProhibited = "prohibited"
+	ReportSpam       = "spam"
+	ReportMisleading = "misleading"
+	ReportOther      = "other"
+)
+
+// Report is a reader's complaint about a published ad. Reports stay open until
+// an admin reviews the ad, only open ones count towards hiding it.
+type Report struct {
+	Id         int64
+	AdId       int64
+	ReporterId int64
+	Reason     string
+	Comment    string
+	CreatedAt  time.Time
+	ResolvedAt time.Time
+}
+
+type RelayStatus int8
+
+const (
+	RelayActive RelayStatus = iota
+	RelayEnded
+	RelayBlocked
+)
+
+// Relay is an anonymous conversation between a buyer and the seller of an ad,
+// messages are copied by the bot so neither side sees the other's account.
+type Relay struct {
+	Id        int64
+	AdId      int64
+	BuyerId   int64
+	SellerId  int64
+	Status    RelayStatus
+	CreatedAt time.Time
+	EndedAt   time.Time
+}
+
+func (r *Relay) Peer(userid int64) int64 {
+	if userid == r.BuyerId {
+		return r.SellerId
+	}
+
+	return r.BuyerId
+}
+
+func (r *Relay) HasMember(userid int64) bool {
+	return userid == r.BuyerId || userid == r.SellerId
+}
+
 type BotState int8
 
 const (
@@ -32,23 +371,172 @@ const (
 	StateWaitingForCDescription
 	StateWaitingForCPrice
 	StateWaitingForCCity
+	StateWaitingForSchedule
+	StateWaitingForSoldPrice
+	StateWaitingForNearbyLocation
+	StateWaitingForNewPrice
+	StateWaitingForReportReason
+	StateWaitingForAdminTitle
+	StateWaitingForAdminDescription
+	StateWaitingForAdminPrice
+	StateWaitingForBroadcast
+	StateWaitingForBroadcastButtons
 )
 
+func (s BotState) Name() string {
+	switch s {
+	case StateNONE:
+		return "none"
+	case StateWaitingForCTitle:
+		return "title"
+	case StateWaitingForCDescription:
+		return "description"
+	case StateWaitingForCPrice:
+		return "price"
+	case StateWaitingForCCity:
+		return "city"
+	case StateWaitingForSchedule:
+		return "schedule"
+	case StateWaitingForSoldPrice:
+		return "sold_price"
+	case StateWaitingForNearbyLocation:
+		return "nearby_location"
+	case StateWaitingForNewPrice:
+		return "new_price"
+	case StateWaitingForReportReason:
+		return "report_reason"
+	case StateWaitingForAdminTitle:
+		return "admin_title"
+	case StateWaitingForAdminDescription:
+		return "admin_description"
+	case StateWaitingForAdminPrice:
+		return "admin_price"
+	case StateWaitingForBroadcast:
+		return "broadcast"
+	case StateWaitingForBroadcastButtons:
+		return "broadcast_buttons"
+	}
+
+	return "unknown"
+}
+
+type BroadcastStatus int8
+
+const (
+	BroadcastDraft BroadcastStatus = iota + 1
+	BroadcastSending
+	BroadcastDone
+	BroadcastCanceled
+)
+
+type DeliveryStatus int8
+
+const (
+	DeliveryPending DeliveryStatus = iota
+	DeliverySent
+	DeliveryFailed
+	DeliveryBlocked
+)
+
+type LinkButton struct {
+	Text string `json:"text"`
+	URL  string `json:"url"`
+}
+
+// Broadcast is a message an admin sends to every user. The bot copies the
+// admin's own message, so whatever it holds arrives as it was written.
+type Broadcast struct {
+	Id            int64
+	CreatedBy     int64
+	FromChatId    int64
+	FromMessageId int
+	Buttons       []LinkButton
+	Status        BroadcastStatus
+	CreatedAt     time.Time
+	StartedAt     time.Time
+	FinishedAt    time.Time
+}
+
+type Delivery struct {
+	BroadcastId int64
+	UserId      int64
+	ChatId      int64
+	Attempts    int
+}
+
+type DeliveryCounts struct {
+	Pending int
+	Sent    int
+	Failed  int
+	Blocked int
+}
+
+// QueueMetrics describes the outgoing queue: how many requests wait in it by
+// priority, how many are being sent, and counters since the start.
+type QueueMetrics struct {
+	Interactive int
+	Bulk        int
+	InFlight    int
+	Sent        int64
+	Retried     int64
+	Failed      int64
+}
+
+// Stats is the snapshot shown by /stats. InFlow counts chats where a user is
+// in the middle of a dialog, Drafts those of them composing an ad.
+type Stats struct {
+	Users       int
+	Banned      int
+	InFlow      int
+	Drafts      int
+	AdsByStatus map[AdStatus]int
+	Created     []time.Time
+}
+
 type BotContext struct {
 	Id            int64
 	IsInFlow      bool
 	Advertisement *Advertisement
 	State         BotState
+	TargetAdId    int64
+	RelayId       int64
+}
+
+// ChatKey identifies a conversation with a single user. ThreadId is the forum
+// topic the conversation happens in, zero outside forums.
+type ChatKey struct {
+	ChatId   int64
+	UserId   int64
+	ThreadId int64
+}
+
+func NewChatKey(chatid int64, userid int64, threadid int64) ChatKey {
+	return ChatKey{ChatId: chatid, UserId: userid, ThreadId: threadid}
+}
+
+func PrivateChatKey(userid int64) ChatKey {
+	return ChatKey{ChatId: userid, UserId: userid}
 }
 
 type User struct {
-	Chatid   int64
-	Username string
-	Context  *BotContext
+	Id        int64
+	Chatid    int64
+	Username  string
+	FirstName string
+	Language  string
+	Banned    bool
+	Inactive  bool
+	Context   *BotContext
+	// ReplyTo is the message the bot is answering in a forum topic. Bot
+	// messages are sent as replies to it so they stay in the same topic.
+	ReplyTo int
 }
 
-func NewUser(chatid int64, username string, context *BotContext) *User {
-	return &User{Chatid: chatid, Username: username, Context: context}
+// ErrUserNotFound is returned for a user who never registered.
+var ErrUserNotFound = errors.New("user not found")
+
+func NewUser(id int64, chatid int64, username string, firstname string, context *BotContext) *User {
+	return &User{Id: id, Chatid: chatid, Username: username, FirstName: firstname, Context: context}
 }
 
 type ParamPair struct {
@@ -61,16 +549,130 @@ func NewParamPair(paramName string, paramValue any) *ParamPair {
 }
 
 type TextSettings struct {
-	Start             string `json:"start"`
-	WrongCommand      string `json:"wrongCommand"`
-	InChainError      string `json:"inChainError"`
-	ChainCanceled     string `json:"chainCanceled"`
-	AdGuide           string `json:"adGuide"`
-	EnterDescription  string `json:"enterDescription"`
-	EnterPrice        string `json:"enterPrice"`
-	EnterCity         string `json:"enterCity"`
-	AdPreview         string `json:"adPreview"`
-	Hidden            string `json:"hidden"`
-	NewParameterValue string `json:"newParameterValue"`
-	AccessOnlyByKey   string `json:"accessOnlyByKey"`
+	Start               string `json:"start"`
+	WrongCommand        string `json:"wrongCommand"`
+	InChainError        string `json:"inChainError"`
+	ChainCanceled       string `json:"chainCanceled"`
+	AdGuide             string `json:"adGuide"`
+	EnterDescription    string `json:"enterDescription"`
+	EnterPrice          string `json:"enterPrice"`
+	EnterCity           string `json:"enterCity"`
+	AdPreview           string `json:"adPreview"`
+	Hidden              string `json:"hidden"`
+	NewParameterValue   string `json:"newParameterValue"`
+	AccessOnlyByKey     string `json:"accessOnlyByKey"`
+	RegisterInPrivate   string `json:"registerInPrivate"`
+	GroupAllowed        string `json:"groupAllowed"`
+	GroupDenied         string `json:"groupDenied"`
+	AdminOnly           string `json:"adminOnly"`
+	AskSchedule         string `json:"askSchedule"`
+	PresetToday         string `json:"presetToday"`
+	PresetTomorrow      string `json:"presetTomorrow"`
+	WrongSchedule       string `json:"wrongSchedule"`
+	ScheduleInPast      string `json:"scheduleInPast"`
+	AdScheduled         string `json:"adScheduled"`
+	AdPublished         string `json:"adPublished"`
+	ScheduleCanceled    string `json:"scheduleCanceled"`
+	PublishDeferred     string `json:"publishDeferred"`
+	NoAds               string `json:"noAds"`
+	ExpiryReminder      string `json:"expiryReminder"`
+	AdRenewed           string `json:"adRenewed"`
+	AdExpired           string `json:"adExpired"`
+	AdMarkedSold        string `json:"adMarkedSold"`
+	AskSoldPrice        string `json:"askSoldPrice"`
+	WrongPrice          string `json:"wrongPrice"`
+	ChooseCity          string `json:"chooseCity"`
+	ChooseCategory      string `json:"chooseCategory"`
+	ChooseLifetime      string `json:"chooseLifetime"`
+	LifetimeDays        string `json:"lifetimeDays"`
+	CityChosen          string `json:"cityChosen"`
+	CityNotDetected     string `json:"cityNotDetected"`
+	CityLabel           string `json:"cityLabel"`
+	NoCity              string `json:"noCity"`
+	AskNearbyLocation   string `json:"askNearbyLocation"`
+	AskNearbyRadius     string `json:"askNearbyRadius"`
+	NothingNearby       string `json:"nothingNearby"`
+	ContactViaBot       string `json:"contactViaBot"`
+	RelayStarted        string `json:"relayStarted"`
+	RelayOwnAd          string `json:"relayOwnAd"`
+	RelayBlocked        string `json:"relayBlocked"`
+	RelayClosed         string `json:"relayClosed"`
+	RelayFromBuyer      string `json:"relayFromBuyer"`
+	RelayFromSeller     string `json:"relayFromSeller"`
+	RelaySwitched       string `json:"relaySwitched"`
+	RelayEnded          string `json:"relayEnded"`
+	RelayEndedByPeer    string `json:"relayEndedByPeer"`
+	NoActiveChat        string `json:"noActiveChat"`
+	RelayReported       string `json:"relayReported"`
+	RelayReportForAdmin string `json:"relayReportForAdmin"`
+	InviteAccepted      string `json:"inviteAccepted"`
+	InviteInvalid       string `json:"inviteInvalid"`
+	InviteCreated       string `json:"inviteCreated"`
+	WrongInviteUses     string `json:"wrongInviteUses"`
+	ReferralLink        string `json:"referralLink"`
+	NoFavorites         string `json:"noFavorites"`
+	FavoritesPage       string `json:"favoritesPage"`
+	AskNewPrice         string `json:"askNewPrice"`
+	PriceChanged        string `json:"priceChanged"`
+	PriceDropped        string `json:"priceDropped"`
+	AskReportReason     string `json:"askReportReason"`
+	AskReportComment    string `json:"askReportComment"`
+	ReportSaved         string `json:"reportSaved"`
+	ReportDuplicate     string `json:"reportDuplicate"`
+	ReportLimit         string `json:"reportLimit"`
+	ReportOwnAd         string `json:"reportOwnAd"`
+	ReportForAdmin      string `json:"reportForAdmin"`
+	AdHiddenByReports   string `json:"adHiddenByReports"`
+	AdRestored          string `json:"adRestored"`
+	AdRemovedByAdmin    string `json:"adRemovedByAdmin"`
+	UserBanned          string `json:"userBanned"`
+	ModerationDone      string `json:"moderationDone"`
+	AlreadyModerated    string `json:"alreadyModerated"`
+	SlowDown            string `json:"slowDown"`
+	AdsPerDayReached    string `json:"adsPerDayReached"`
+	DuplicateAd         string `json:"duplicateAd"`
+	SimilarOwnAd        string `json:"similarOwnAd"`
+	AdSentToReview      string `json:"adSentToReview"`
+	ReviewForAdmin      string `json:"reviewForAdmin"`
+	ReviewSimilar       string `json:"reviewSimilar"`
+	ReviewFilter        string `json:"reviewFilter"`
+	FilterRejected      string `json:"filterRejected"`
+	FilterMasked        string `json:"filterMasked"`
+	AdApproved          string `json:"adApproved"`
+	AdBumped            string `json:"adBumped"`
+	BumpTooEarly        string `json:"bumpTooEarly"`
+	StaleButton         string `json:"staleButton"`
+	AlreadyProcessing   string `json:"alreadyProcessing"`
+	ActionFailed        string `json:"actionFailed"`
+	SoldPriceSaved      string `json:"soldPriceSaved"`
+	AdTooLong           string `json:"adTooLong"`
+	AdTruncated         string `json:"adTruncated"`
+	AdNotFound          string `json:"adNotFound"`
+
+	AdminUsage        string            `json:"adminUsage"`
+	UserNotFound      string            `json:"userNotFound"`
+	AdminStats        string            `json:"adminStats"`
+	AdminStatsDay     string            `json:"adminStatsDay"`
+	AdminQueue        string            `json:"adminQueue"`
+	AdminUser         string            `json:"adminUser"`
+	AdminUserBanned   string            `json:"adminUserBanned"`
+	AdminAd           string            `json:"adminAd"`
+	AdminNoUserAds    string            `json:"adminNoUserAds"`
+	AdminUserAds      string            `json:"adminUserAds"`
+	StateReset        string            `json:"stateReset"`
+	StateNotStuck     string            `json:"stateNotStuck"`
+	StateResetByAdmin string            `json:"stateResetByAdmin"`
+	AskAdminEdit      string            `json:"askAdminEdit"`
+	AdminEditDone     string            `json:"adminEditDone"`
+	AdEditedByAdmin   string            `json:"adEditedByAdmin"`
+	UserUnbanned      string            `json:"userUnbanned"`
+	AskBroadcast      string            `json:"askBroadcast"`
+	AskBroadcastLinks string            `json:"askBroadcastLinks"`
+	BadBroadcastLink  string            `json:"badBroadcastLink"`
+	BroadcastConfirm  string            `json:"broadcastConfirm"`
+	BroadcastStarted  string            `json:"broadcastStarted"`
+	BroadcastCanceled string            `json:"broadcastCanceled"`
+	BroadcastDone     string            `json:"broadcastDone"`
+	AdStatusNames     map[string]string `json:"adStatusNames"`
+	StateNames        map[string]string `json:"stateNames"`
 }
@@ -0,0 +1,97 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	warned  bool
+}
+
+// Limiter is a token bucket per key: a key may spend burst tokens at once and
+// gets them back at rate tokens per second.
+type Limiter struct {
+	mu      sync.Mutex
+	rate    float64
+	burst   float64
+	buckets map[int64]*bucket
+	swept   time.Time
+}
+
+func NewLimiter(rate float64, burst int) *Limiter {
+	return &Limiter{rate: rate, burst: float64(burst), buckets: map[int64]*bucket{}}
+}
+
+// Allow takes a token for key. When there is none it returns how long to wait
+// for the next one and whether this is the first refusal since the key was
+// last allowed, so the caller can warn the user once instead of on every
+// message.
+func (l *Limiter) Allow(key int64, now time.Time) (ok bool, retry time.Duration, first bool) {
+	if l.rate <= 0 {
+		return true, 0, false
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweep(now)
+
+	b, exists := l.buckets[key]
+
+	if !exists {
+		b = &bucket{tokens: l.burst, updated: now}
+		l.buckets[key] = b
+	}
+
+	if elapsed := now.Sub(b.updated).Seconds(); elapsed > 0 {
+		b.tokens += elapsed * l.rate
+
+		if b.tokens > l.burst {
+			b.tokens = l.burst
+		}
+
+		b.updated = now
+	}
+
+	if b.tokens >= 1 {
+		b.tokens--
+		b.warned = false
+		return true, 0, false
+	}
+
+	first = !b.warned
+	b.warned = true
+
+	return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second)), first
+}
+
+// Refund gives back a token taken by Allow that ended up unused.
+func (l *Limiter) Refund(key int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if b, ok := l.buckets[key]; ok && b.tokens+1 <= l.burst {
+		b.tokens++
+	}
+}
+
+// sweep drops buckets that have refilled completely, so the map doesn't keep
+// every user who ever wrote to the bot.
+func (l *Limiter) sweep(now time.Time) {
+	full := time.Duration(l.burst / l.rate * float64(time.Second))
+
+	if now.Sub(l.swept) < full {
+		return
+	}
+
+	for key, b := range l.buckets {
+		if now.Sub(b.updated) > full {
+			delete(l.buckets, key)
+		}
+	}
+
+	l.swept = now
+}
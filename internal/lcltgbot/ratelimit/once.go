@@ -0,0 +1,46 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Once remembers keys for ttl, it is used to act on a button press only once
+// when Telegram delivers a double tap as two callbacks.
+type Once struct {
+	mu   sync.Mutex
+	ttl  time.Duration
+	seen map[string]time.Time
+}
+
+func NewOnce(ttl time.Duration) *Once {
+	return &Once{ttl: ttl, seen: map[string]time.Time{}}
+}
+
+// First reports whether key has not been seen within ttl and marks it seen.
+func (o *Once) First(key string, now time.Time) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	for seen, at := range o.seen {
+		if now.Sub(at) > o.ttl {
+			delete(o.seen, seen)
+		}
+	}
+
+	if _, ok := o.seen[key]; ok {
+		return false
+	}
+
+	o.seen[key] = now
+
+	return true
+}
+
+// Forget lets the next First of key succeed again.
+func (o *Once) Forget(key string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	delete(o.seen, key)
+}
@@ -0,0 +1,90 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLimiterAllow(t *testing.T) {
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name  string
+		after time.Duration
+		ok    bool
+		first bool
+	}{
+		{"burst 1", 0, true, false},
+		{"burst 2", 0, true, false},
+		{"empty", 0, false, true},
+		{"still empty", 100 * time.Millisecond, false, false},
+		{"refilled", time.Second, true, false},
+		{"empty again", time.Second, false, true},
+	}
+
+	limiter := NewLimiter(1, 2)
+
+	for _, test := range tests {
+		ok, retry, first := limiter.Allow(1, start.Add(test.after))
+
+		if ok != test.ok || first != test.first {
+			t.Errorf("%s: got ok %v first %v, want ok %v first %v", test.name, ok, first, test.ok, test.first)
+		}
+
+		if !ok && (retry <= 0 || retry > time.Second) {
+			t.Errorf("%s: retry after %v", test.name, retry)
+		}
+	}
+}
+
+func TestLimiterKeys(t *testing.T) {
+	now := time.Now()
+	limiter := NewLimiter(1, 1)
+
+	if ok, _, _ := limiter.Allow(1, now); !ok {
+		t.Fatal("first message refused")
+	}
+
+	if ok, _, _ := limiter.Allow(2, now); !ok {
+		t.Error("another key shares the bucket")
+	}
+
+	limiter.Refund(1)
+
+	if ok, _, _ := limiter.Allow(1, now); !ok {
+		t.Error("the refunded token is not spent")
+	}
+
+	if ok, _, _ := NewLimiter(0, 0).Allow(1, now); !ok {
+		t.Error("a zero rate limits")
+	}
+}
+
+func TestOnceFirst(t *testing.T) {
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	once := NewOnce(time.Second)
+
+	tests := []struct {
+		name  string
+		key   string
+		after time.Duration
+		want  bool
+	}{
+		{"first press", "send:1", 0, true},
+		{"double tap", "send:1", 100 * time.Millisecond, false},
+		{"other button", "send:2", 100 * time.Millisecond, true},
+		{"after ttl", "send:1", 2 * time.Second, true},
+	}
+
+	for _, test := range tests {
+		if got := once.First(test.key, start.Add(test.after)); got != test.want {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+
+	once.Forget("send:2")
+
+	if !once.First("send:2", start.Add(2*time.Second)) {
+		t.Error("forgotten key: got false, want true")
+	}
+}
@@ -0,0 +1,84 @@
+package similarity
+
+import (
+	"strings"
+	"unicode"
+)
+
+// ShingleSize is the length of the character n-grams texts are compared by.
+// Three letters survive typos and word order changes while still telling
+// different items apart.
+const ShingleSize = 3
+
+// NearDuplicate is the score from which two ads are considered the same item
+// posted again with small edits.
+const NearDuplicate = 0.7
+
+type Fingerprint struct {
+	Text     string
+	Shingles map[string]struct{}
+}
+
+func NewFingerprint(title string, description string) Fingerprint {
+	text := Normalize(title + " " + description)
+
+	return Fingerprint{Text: text, Shingles: Shingles(text, ShingleSize)}
+}
+
+// Normalize lowercases the text and keeps only letters and digits separated
+// by single spaces, so punctuation, emoji and line breaks don't matter.
+func Normalize(text string) string {
+	text = strings.ReplaceAll(strings.ToLower(text), "ё", "е")
+
+	return strings.Join(strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}), " ")
+}
+
+func Shingles(text string, size int) map[string]struct{} {
+	runes := []rune(text)
+	shingles := map[string]struct{}{}
+
+	if len(runes) <= size {
+		if len(runes) > 0 {
+			shingles[text] = struct{}{}
+		}
+
+		return shingles
+	}
+
+	for i := 0; i+size <= len(runes); i++ {
+		shingles[string(runes[i:i+size])] = struct{}{}
+	}
+
+	return shingles
+}
+
+// Jaccard is the share of shingles the two sets have in common.
+func Jaccard(a map[string]struct{}, b map[string]struct{}) float64 {
+	if len(a) == 0 && len(b) == 0 {
+		return 1
+	}
+
+	common := 0
+
+	for shingle := range a {
+		if _, ok := b[shingle]; ok {
+			common++
+		}
+	}
+
+	return float64(common) / float64(len(a)+len(b)-common)
+}
+
+func (f Fingerprint) IsExact(other Fingerprint) bool {
+	return f.Text == other.Text
+}
+
+func (f Fingerprint) Similarity(other Fingerprint) float64 {
+	if f.IsExact(other) {
+		return 1
+	}
+
+	return Jaccard(f.Shingles, other.Shingles)
+}
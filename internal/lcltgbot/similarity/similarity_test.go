@@ -0,0 +1,104 @@
+package similarity
+
+import "testing"
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"Продам  ВЕЛОСИПЕД!!!", "продам велосипед"},
+		{"Ёлка 🎄\nискусственная", "елка искусственная"},
+		{"iPhone 12, 128 ГБ", "iphone 12 128 гб"},
+		{"  ", ""},
+	}
+
+	for _, test := range tests {
+		if got := Normalize(test.text); got != test.want {
+			t.Errorf("%q: got %q, want %q", test.text, got, test.want)
+		}
+	}
+}
+
+func TestShingles(t *testing.T) {
+	tests := []struct {
+		text string
+		want int
+	}{
+		{"", 0},
+		{"ab", 1},
+		{"abc", 1},
+		{"abcd", 2},
+		{"аааа", 1},
+	}
+
+	for _, test := range tests {
+		if got := len(Shingles(test.text, ShingleSize)); got != test.want {
+			t.Errorf("%q: got %d shingles, want %d", test.text, got, test.want)
+		}
+	}
+}
+
+func TestSimilarity(t *testing.T) {
+	original := NewFingerprint("Велосипед Stels", "Горный, 21 скорость, почти новый")
+
+	tests := []struct {
+		name        string
+		title       string
+		description string
+		exact       bool
+		near        bool
+	}{
+		{"same text", "Велосипед Stels", "Горный, 21 скорость, почти новый", true, true},
+		{"punctuation and case", "ВЕЛОСИПЕД STELS!", "горный 21 скорость — почти новый", true, true},
+		{"small edit", "Велосипед Stels", "Горный, 21 скорость, почти новый, торг", false, true},
+		{"different item", "Диван угловой", "Раскладной, серый, самовывоз", false, false},
+	}
+
+	for _, test := range tests {
+		fingerprint := NewFingerprint(test.title, test.description)
+		score := original.Similarity(fingerprint)
+
+		if exact := original.IsExact(fingerprint); exact != test.exact {
+			t.Errorf("%s: exact %v, want %v", test.name, exact, test.exact)
+		}
+
+		if near := score >= NearDuplicate; near != test.near {
+			t.Errorf("%s: score %.2f, want near duplicate %v", test.name, score, test.near)
+		}
+
+		if test.exact && score != 1 {
+			t.Errorf("%s: exact copy scored %.2f", test.name, score)
+		}
+	}
+}
+
+func TestJaccard(t *testing.T) {
+	set := func(shingles ...string) map[string]struct{} {
+		s := map[string]struct{}{}
+
+		for _, shingle := range shingles {
+			s[shingle] = struct{}{}
+		}
+
+		return s
+	}
+
+	tests := []struct {
+		name string
+		a    map[string]struct{}
+		b    map[string]struct{}
+		want float64
+	}{
+		{"both empty", set(), set(), 1},
+		{"one empty", set("abc"), set(), 0},
+		{"half", set("abc", "bcd"), set("abc", "xyz"), 1.0 / 3},
+		{"same", set("abc", "bcd"), set("bcd", "abc"), 1},
+	}
+
+	for _, test := range tests {
+		if got := Jaccard(test.a, test.b); got != test.want {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package lcltgbot
+
+import (
+	"github.com/iokinai/lcltgbot/internal/lcltgbot/models"
+	"time"
+)
+
+var draftStates = []any{
+	models.StateWaitingForCTitle,
+	models.StateWaitingForCDescription,
+	models.StateWaitingForCPrice,
+	models.StateWaitingForCCity,
+	models.StateWaitingForSchedule,
+}
+
+// GetStats counts users and ads for the admin console and lists the
+// creation times of ads made since the given time.
+func (s *SqliteDb) GetStats(since time.Time) (*models.Stats, error) {
+	stats := &models.Stats{AdsByStatus: map[models.AdStatus]int{}}
+
+	if err := s.db.QueryRow("SELECT COUNT(*), COUNT(NULLIF(banned_at, 0)) FROM users").Scan(&stats.Users, &stats.Banned); err != nil {
+		return nil, err
+	}
+
+	err := s.db.QueryRow(
+		"SELECT COUNT(*), COUNT(CASE WHEN state IN (?, ?, ?, ?, ?) THEN 1 END) FROM temp_contexts WHERE is_in_flow = 1",
+		draftStates...,
+	).Scan(&stats.InFlow, &stats.Drafts)
+
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.Query("SELECT status, COUNT(*) FROM ads GROUP BY status")
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			status models.AdStatus
+			count  int
+		)
+
+		if err := rows.Scan(&status, &count); err != nil {
+			return nil, err
+		}
+
+		stats.AdsByStatus[status] = count
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	created, err := s.db.Query("SELECT created_at FROM ads WHERE created_at >= ? ORDER BY created_at", since.Unix())
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer created.Close()
+
+	for created.Next() {
+		var createdAt int64
+
+		if err := created.Scan(&createdAt); err != nil {
+			return nil, err
+		}
+
+		stats.Created = append(stats.Created, time.Unix(createdAt, 0))
+	}
+
+	return stats, created.Err()
+}
+
+func (s *SqliteDb) GetUserIdByUsername(username string) (int64, error) {
+	var userid int64
+
+	if err := s.db.QueryRow("SELECT user_id FROM users WHERE username = ? COLLATE NOCASE", username).Scan(&userid); err != nil {
+		return 0, err
+	}
+
+	return userid, nil
+}
+
+// ResetUserStates takes the user out of every flow in every chat and returns
+// how many of them were in one.
+func (s *SqliteDb) ResetUserStates(userid int64) (int64, error) {
+	result, err := s.db.Exec(
+		"UPDATE temp_contexts SET is_in_flow = 0, state = ? WHERE is_in_flow = 1 AND id IN (SELECT context_id FROM chat_contexts WHERE user_id = ?)",
+		models.StateNONE, userid,
+	)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+func (s *SqliteDb) UnbanUser(userid int64) error {
+	_, err := s.db.Exec("UPDATE users SET banned_at = 0 WHERE user_id = ?", userid)
+	return err
+}
+
+func (s *SqliteDb) UpdateAdTitle(ad *models.Advertisement, title string, entities []models.Entity) error {
+	if _, err := s.db.Exec("UPDATE ads SET title = ?, title_entities = ? WHERE id = ?", title, EncodeEntities(entities), ad.Id); err != nil {
+		return err
+	}
+
+	ad.Title = title
+	ad.TitleEntities = entities
+
+	return nil
+}
+
+func (s *SqliteDb) UpdateAdDescription(ad *models.Advertisement, description string, entities []models.Entity) error {
+	if _, err := s.db.Exec("UPDATE ads SET description = ?, description_entities = ? WHERE id = ?", description, EncodeEntities(entities), ad.Id); err != nil {
+		return err
+	}
+
+	ad.Description = description
+	ad.DescriptionEntities = entities
+
+	return nil
+}
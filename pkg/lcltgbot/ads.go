@@ -0,0 +1,326 @@
+package lcltgbot
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/iokinai/lcltgbot/internal/lcltgbot/geo"
+	"github.com/iokinai/lcltgbot/internal/lcltgbot/models"
+	"strings"
+	"time"
+)
+
+const adColumns = "id, user_id, title, description, price, city, status, publish_at, published_at, channel_chat_id, channel_message_id, expires_at, reminded_at, sold_at, sold_price, title_entities, description_entities, truncated, currency, price_type, city_id, latitude, longitude, category, lifetime_days"
+
+func prefixedAdColumns(table string) string {
+	return table + "." + strings.ReplaceAll(adColumns, ", ", ", "+table+".")
+}
+
+func ScanAd(rows *sql.Rows) (*models.Advertisement, error) {
+	var (
+		ad          models.Advertisement
+		publishAt   int64
+		publishedAt int64
+		expiresAt   int64
+		remindedAt  int64
+		soldAt      int64
+		soldPrice   sql.NullFloat64
+		titleEnt    string
+		descrEnt    string
+		latitude    sql.NullFloat64
+		longitude   sql.NullFloat64
+	)
+
+	if err := rows.Scan(&ad.Id, &ad.OwnerId, &ad.Title, &ad.Description, &ad.Price, &ad.City, &ad.Status, &publishAt, &publishedAt, &ad.ChannelChatId, &ad.ChannelMessageId, &expiresAt, &remindedAt, &soldAt, &soldPrice, &titleEnt, &descrEnt, &ad.Truncated, &ad.Currency, &ad.PriceType, &ad.CityId, &latitude, &longitude, &ad.Category, &ad.LifetimeDays); err != nil {
+		return nil, err
+	}
+
+	ad.PublishAt = UnixOrZero(publishAt)
+	ad.PublishedAt = UnixOrZero(publishedAt)
+	ad.ExpiresAt = UnixOrZero(expiresAt)
+	ad.RemindedAt = UnixOrZero(remindedAt)
+	ad.SoldAt = UnixOrZero(soldAt)
+	ad.SoldPrice = soldPrice.Float64
+	ad.TitleEntities = DecodeEntities(titleEnt)
+	ad.DescriptionEntities = DecodeEntities(descrEnt)
+	ad.Location = LocationOrNil(latitude, longitude)
+
+	return &ad, nil
+}
+
+func LocationOrNil(latitude sql.NullFloat64, longitude sql.NullFloat64) *models.Location {
+	if !latitude.Valid || !longitude.Valid {
+		return nil
+	}
+
+	return &models.Location{Latitude: latitude.Float64, Longitude: longitude.Float64}
+}
+
+func NullLocation(location *models.Location) (sql.NullFloat64, sql.NullFloat64) {
+	if location == nil {
+		return sql.NullFloat64{}, sql.NullFloat64{}
+	}
+
+	return sql.NullFloat64{Float64: location.Latitude, Valid: true}, sql.NullFloat64{Float64: location.Longitude, Valid: true}
+}
+
+func UnixOrZero(seconds int64) time.Time {
+	if seconds == 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(seconds, 0)
+}
+
+func ZeroOrUnix(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+
+	return t.Unix()
+}
+
+func (s *SqliteDb) QueryAds(query string, args ...any) ([]*models.Advertisement, error) {
+	rows, err := s.db.Query(query, args...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var ads []*models.Advertisement
+
+	for rows.Next() {
+		ad, err := ScanAd(rows)
+
+		if err != nil {
+			return nil, err
+		}
+
+		ads = append(ads, ad)
+	}
+
+	return ads, rows.Err()
+}
+
+func (s *SqliteDb) SaveAd(user *models.User, status models.AdStatus, publishAt time.Time) (*models.Advertisement, error) {
+	draft := user.Context.Advertisement
+	currency := draft.Currency
+
+	if currency == "" {
+		currency = s.settings.Currency()
+	}
+
+	latitude, longitude := NullLocation(draft.Location)
+
+	result, err := s.db.Exec(
+		"INSERT INTO ads(user_id, title, title_entities, description, description_entities, truncated, price, currency, price_type, city, city_id, latitude, longitude, category, lifetime_days, status, publish_at, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		user.Id, draft.Title, EncodeEntities(draft.TitleEntities), draft.Description, EncodeEntities(draft.DescriptionEntities), draft.Truncated, draft.Price, currency, draft.PriceType, draft.City, draft.CityId, latitude, longitude, draft.Category, draft.LifetimeDays, status, ZeroOrUnix(publishAt), time.Now().Unix(),
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := result.LastInsertId()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := s.db.Exec("UPDATE temp_ads SET revision = revision + 1 WHERE id = ?", draft.Id); err != nil {
+		return nil, err
+	}
+
+	draft.Revision++
+
+	ad := models.NewAdvertisement(id, draft.Title, draft.Description, draft.Price, draft.City, false)
+	ad.TitleEntities = draft.TitleEntities
+	ad.DescriptionEntities = draft.DescriptionEntities
+	ad.Truncated = draft.Truncated
+	ad.Currency = currency
+	ad.PriceType = draft.PriceType
+	ad.CityId = draft.CityId
+	ad.Location = draft.Location
+	ad.Category = draft.Category
+	ad.LifetimeDays = draft.LifetimeDays
+	ad.OwnerId = user.Id
+	ad.Status = status
+	ad.PublishAt = publishAt
+
+	return ad, nil
+}
+
+func (s *SqliteDb) GetPublishedAd(id int64) (*models.Advertisement, error) {
+	ads, err := s.QueryAds("SELECT "+adColumns+" FROM ads WHERE id = ?", id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ads) == 0 {
+		return nil, errors.New("no values in DB")
+	}
+
+	return ads[0], nil
+}
+
+// GetRecentAds returns ads created after since that are live or about to be:
+// scheduled, being published, published, hidden by reports or waiting for
+// review.
+func (s *SqliteDb) GetRecentAds(since time.Time) ([]*models.Advertisement, error) {
+	return s.QueryAds(
+		"SELECT "+adColumns+" FROM ads WHERE created_at >= ? AND status IN (?, ?, ?, ?, ?) ORDER BY id DESC",
+		since.Unix(), models.AdStatusScheduled, models.AdStatusPublishing, models.AdStatusPublished, models.AdStatusHidden, models.AdStatusPending,
+	)
+}
+
+// GetAdCreationTimes lists when the user's ads created after since were
+// saved, oldest first. Canceled ones count too, otherwise canceling and
+// re-adding would get around the daily limit.
+func (s *SqliteDb) GetAdCreationTimes(userid int64, since time.Time) ([]time.Time, error) {
+	rows, err := s.db.Query("SELECT created_at FROM ads WHERE user_id = ? AND created_at >= ? ORDER BY created_at", userid, since.Unix())
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var times []time.Time
+
+	for rows.Next() {
+		var createdAt int64
+
+		if err := rows.Scan(&createdAt); err != nil {
+			return nil, err
+		}
+
+		times = append(times, time.Unix(createdAt, 0))
+	}
+
+	return times, rows.Err()
+}
+
+func (s *SqliteDb) GetUserAds(userid int64) ([]*models.Advertisement, error) {
+	return s.QueryAds("SELECT "+adColumns+" FROM ads WHERE user_id = ? AND status != ? ORDER BY id DESC", userid, models.AdStatusCanceled)
+}
+
+func (s *SqliteDb) GetPublishedAds() ([]*models.Advertisement, error) {
+	return s.QueryAds("SELECT "+adColumns+" FROM ads WHERE status = ? ORDER BY published_at DESC, id DESC", models.AdStatusPublished)
+}
+
+func (s *SqliteDb) GetPublishedAdsIn(box geo.BoundingBox) ([]*models.Advertisement, error) {
+	return s.QueryAds(
+		"SELECT "+adColumns+" FROM ads WHERE status = ? AND latitude BETWEEN ? AND ? AND longitude BETWEEN ? AND ?",
+		models.AdStatusPublished, box.South, box.North, box.West, box.East,
+	)
+}
+
+func (s *SqliteDb) UpdateAdPrice(ad *models.Advertisement, price float64) error {
+	if _, err := s.db.Exec("UPDATE ads SET price = ? WHERE id = ?", price, ad.Id); err != nil {
+		return err
+	}
+
+	ad.Price = price
+
+	return nil
+}
+
+func (s *SqliteDb) GetDueAds(now time.Time) ([]*models.Advertisement, error) {
+	return s.QueryAds("SELECT "+adColumns+" FROM ads WHERE status = ? AND publish_at <= ? ORDER BY publish_at", models.AdStatusScheduled, now.Unix())
+}
+
+// ClaimAd moves a scheduled ad to publishing unless someone else has already
+// taken it, so the scheduler and a user can't post the same ad twice.
+func (s *SqliteDb) ClaimAd(ad *models.Advertisement) (bool, error) {
+	result, err := s.db.Exec("UPDATE ads SET status = ? WHERE id = ? AND status = ?", models.AdStatusPublishing, ad.Id, models.AdStatusScheduled)
+
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+
+	if err != nil || affected == 0 {
+		return false, err
+	}
+
+	ad.Status = models.AdStatusPublishing
+
+	return true, nil
+}
+
+func (s *SqliteDb) MarkAdPublished(ad *models.Advertisement, channelchatid int64, messageid int, publishedAt time.Time) error {
+	_, err := s.db.Exec(
+		"UPDATE ads SET status = ?, published_at = ?, channel_chat_id = ?, channel_message_id = ? WHERE id = ?",
+		models.AdStatusPublished, publishedAt.Unix(), channelchatid, messageid, ad.Id,
+	)
+
+	if err != nil {
+		return err
+	}
+
+	ad.Status = models.AdStatusPublished
+	ad.PublishedAt = publishedAt
+	ad.ChannelChatId = channelchatid
+	ad.ChannelMessageId = messageid
+
+	return nil
+}
+
+func (s *SqliteDb) ChangeAdStatus(ad *models.Advertisement, status models.AdStatus) error {
+	if _, err := s.db.Exec("UPDATE ads SET status = ? WHERE id = ?", status, ad.Id); err != nil {
+		return err
+	}
+
+	ad.Status = status
+
+	return nil
+}
+
+func (s *SqliteDb) SetAdExpiry(ad *models.Advertisement, expiresAt time.Time) error {
+	if _, err := s.db.Exec("UPDATE ads SET expires_at = ?, reminded_at = 0 WHERE id = ?", ZeroOrUnix(expiresAt), ad.Id); err != nil {
+		return err
+	}
+
+	ad.ExpiresAt = expiresAt
+	ad.RemindedAt = time.Time{}
+
+	return nil
+}
+
+func (s *SqliteDb) MarkAdReminded(ad *models.Advertisement, remindedAt time.Time) error {
+	if _, err := s.db.Exec("UPDATE ads SET reminded_at = ? WHERE id = ?", remindedAt.Unix(), ad.Id); err != nil {
+		return err
+	}
+
+	ad.RemindedAt = remindedAt
+
+	return nil
+}
+
+func (s *SqliteDb) GetAdsToRemind(until time.Time) ([]*models.Advertisement, error) {
+	return s.QueryAds("SELECT "+adColumns+" FROM ads WHERE status = ? AND reminded_at = 0 AND expires_at > 0 AND expires_at <= ?", models.AdStatusPublished, until.Unix())
+}
+
+func (s *SqliteDb) GetExpiredAds(now time.Time) ([]*models.Advertisement, error) {
+	return s.QueryAds("SELECT "+adColumns+" FROM ads WHERE status = ? AND expires_at > 0 AND expires_at <= ?", models.AdStatusPublished, now.Unix())
+}
+
+func (s *SqliteDb) MarkAdSold(ad *models.Advertisement, soldAt time.Time) error {
+	if _, err := s.db.Exec("UPDATE ads SET status = ?, sold_at = ? WHERE id = ?", models.AdStatusSold, soldAt.Unix(), ad.Id); err != nil {
+		return err
+	}
+
+	ad.Status = models.AdStatusSold
+	ad.SoldAt = soldAt
+
+	return nil
+}
+
+func (s *SqliteDb) SetAdSoldPrice(adid int64, price float64) error {
+	_, err := s.db.Exec("UPDATE ads SET sold_price = ? WHERE id = ? AND status = ?", price, adid, models.AdStatusSold)
+
+	return err
+}
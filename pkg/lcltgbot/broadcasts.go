@@ -0,0 +1,305 @@
+package lcltgbot
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/iokinai/lcltgbot/internal/lcltgbot/models"
+	"time"
+)
+
+const broadcastColumns = "id, created_by, from_chat_id, from_message_id, buttons, status, created_at, started_at, finished_at"
+
+func (s *SqliteDb) CreateBroadcast(broadcast *models.Broadcast) error {
+	result, err := s.db.Exec(
+		"INSERT INTO broadcasts(created_by, from_chat_id, from_message_id, status, created_at) VALUES (?, ?, ?, ?, ?)",
+		broadcast.CreatedBy, broadcast.FromChatId, broadcast.FromMessageId, models.BroadcastDraft, broadcast.CreatedAt.Unix(),
+	)
+
+	if err != nil {
+		return err
+	}
+
+	broadcast.Status = models.BroadcastDraft
+	broadcast.Id, err = result.LastInsertId()
+
+	return err
+}
+
+func (s *SqliteDb) SetBroadcastButtons(broadcast *models.Broadcast, buttons []models.LinkButton) error {
+	encoded := ""
+
+	if len(buttons) > 0 {
+		data, err := json.Marshal(buttons)
+
+		if err != nil {
+			return err
+		}
+
+		encoded = string(data)
+	}
+
+	if _, err := s.db.Exec("UPDATE broadcasts SET buttons = ? WHERE id = ?", encoded, broadcast.Id); err != nil {
+		return err
+	}
+
+	broadcast.Buttons = buttons
+
+	return nil
+}
+
+func (s *SqliteDb) GetBroadcast(id int64) (*models.Broadcast, error) {
+	broadcasts, err := s.QueryBroadcasts("SELECT "+broadcastColumns+" FROM broadcasts WHERE id = ?", id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(broadcasts) == 0 {
+		return nil, errors.New("no values in DB")
+	}
+
+	return broadcasts[0], nil
+}
+
+// GetDraftBroadcast is the admin's latest broadcast that is not sent yet.
+func (s *SqliteDb) GetDraftBroadcast(createdBy int64) (*models.Broadcast, error) {
+	broadcasts, err := s.QueryBroadcasts("SELECT "+broadcastColumns+" FROM broadcasts WHERE created_by = ? AND status = ? ORDER BY id DESC LIMIT 1", createdBy, models.BroadcastDraft)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(broadcasts) == 0 {
+		return nil, errors.New("no values in DB")
+	}
+
+	return broadcasts[0], nil
+}
+
+func (s *SqliteDb) GetSendingBroadcasts() ([]*models.Broadcast, error) {
+	return s.QueryBroadcasts("SELECT "+broadcastColumns+" FROM broadcasts WHERE status = ? ORDER BY id", models.BroadcastSending)
+}
+
+func (s *SqliteDb) QueryBroadcasts(query string, args ...any) ([]*models.Broadcast, error) {
+	rows, err := s.db.Query(query, args...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var broadcasts []*models.Broadcast
+
+	for rows.Next() {
+		var (
+			broadcast                        models.Broadcast
+			buttons                          string
+			createdAt, startedAt, finishedAt int64
+		)
+
+		err := rows.Scan(
+			&broadcast.Id, &broadcast.CreatedBy, &broadcast.FromChatId, &broadcast.FromMessageId, &buttons,
+			&broadcast.Status, &createdAt, &startedAt, &finishedAt,
+		)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if buttons != "" {
+			if err := json.Unmarshal([]byte(buttons), &broadcast.Buttons); err != nil {
+				return nil, err
+			}
+		}
+
+		broadcast.CreatedAt = UnixOrZero(createdAt)
+		broadcast.StartedAt = UnixOrZero(startedAt)
+		broadcast.FinishedAt = UnixOrZero(finishedAt)
+		broadcasts = append(broadcasts, &broadcast)
+	}
+
+	return broadcasts, rows.Err()
+}
+
+// StartBroadcast queues the draft for every user who can still receive it
+// and returns how many were queued. The queue is written in the same
+// transaction, so a restart continues from it instead of starting over. A
+// broadcast that is no longer a draft is left alone and gives false.
+func (s *SqliteDb) StartBroadcast(broadcast *models.Broadcast, startedAt time.Time) (int64, bool, error) {
+	tx, err := s.db.Begin()
+
+	if err != nil {
+		return 0, false, err
+	}
+
+	defer tx.Rollback()
+
+	result, err := tx.Exec(
+		"UPDATE broadcasts SET status = ?, started_at = ? WHERE id = ? AND status = ?",
+		models.BroadcastSending, startedAt.Unix(), broadcast.Id, models.BroadcastDraft,
+	)
+
+	if err != nil {
+		return 0, false, err
+	}
+
+	if affected, err := result.RowsAffected(); err != nil || affected == 0 {
+		return 0, false, err
+	}
+
+	result, err = tx.Exec(
+		"INSERT INTO broadcast_deliveries(broadcast_id, user_id, chat_id) SELECT ?, user_id, chat_id FROM users WHERE chat_id IS NOT NULL AND banned_at = 0 AND inactive_at = 0",
+		broadcast.Id,
+	)
+
+	if err != nil {
+		return 0, false, err
+	}
+
+	queued, err := result.RowsAffected()
+
+	if err != nil {
+		return 0, false, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, false, err
+	}
+
+	broadcast.Status = models.BroadcastSending
+	broadcast.StartedAt = startedAt
+
+	return queued, true, nil
+}
+
+func (s *SqliteDb) CancelBroadcast(broadcast *models.Broadcast) (bool, error) {
+	result, err := s.db.Exec("UPDATE broadcasts SET status = ? WHERE id = ? AND status = ?", models.BroadcastCanceled, broadcast.Id, models.BroadcastDraft)
+
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+
+	if err != nil {
+		return false, err
+	}
+
+	if affected > 0 {
+		broadcast.Status = models.BroadcastCanceled
+	}
+
+	return affected > 0, nil
+}
+
+func (s *SqliteDb) FinishBroadcast(broadcast *models.Broadcast, finishedAt time.Time) error {
+	if _, err := s.db.Exec("UPDATE broadcasts SET status = ?, finished_at = ? WHERE id = ?", models.BroadcastDone, finishedAt.Unix(), broadcast.Id); err != nil {
+		return err
+	}
+
+	broadcast.Status = models.BroadcastDone
+	broadcast.FinishedAt = finishedAt
+
+	return nil
+}
+
+func (s *SqliteDb) GetPendingDeliveries(broadcastid int64, limit int) ([]*models.Delivery, error) {
+	rows, err := s.db.Query(
+		"SELECT broadcast_id, user_id, chat_id, attempts FROM broadcast_deliveries WHERE broadcast_id = ? AND status = ? ORDER BY user_id LIMIT ?",
+		broadcastid, models.DeliveryPending, limit,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var deliveries []*models.Delivery
+
+	for rows.Next() {
+		var delivery models.Delivery
+
+		if err := rows.Scan(&delivery.BroadcastId, &delivery.UserId, &delivery.ChatId, &delivery.Attempts); err != nil {
+			return nil, err
+		}
+
+		deliveries = append(deliveries, &delivery)
+	}
+
+	return deliveries, rows.Err()
+}
+
+func (s *SqliteDb) MarkDelivery(delivery *models.Delivery, status models.DeliveryStatus, reason string, at time.Time) error {
+	_, err := s.db.Exec(
+		"UPDATE broadcast_deliveries SET status = ?, error = ?, sent_at = ? WHERE broadcast_id = ? AND user_id = ?",
+		status, reason, at.Unix(), delivery.BroadcastId, delivery.UserId,
+	)
+
+	return err
+}
+
+func (s *SqliteDb) RetryDelivery(delivery *models.Delivery) error {
+	_, err := s.db.Exec(
+		"UPDATE broadcast_deliveries SET attempts = attempts + 1 WHERE broadcast_id = ? AND user_id = ?",
+		delivery.BroadcastId, delivery.UserId,
+	)
+
+	if err != nil {
+		return err
+	}
+
+	delivery.Attempts++
+
+	return nil
+}
+
+func (s *SqliteDb) CountDeliveries(broadcastid int64) (*models.DeliveryCounts, error) {
+	rows, err := s.db.Query("SELECT status, COUNT(*) FROM broadcast_deliveries WHERE broadcast_id = ? GROUP BY status", broadcastid)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var counts models.DeliveryCounts
+
+	for rows.Next() {
+		var (
+			status models.DeliveryStatus
+			count  int
+		)
+
+		if err := rows.Scan(&status, &count); err != nil {
+			return nil, err
+		}
+
+		switch status {
+		case models.DeliveryPending:
+			counts.Pending = count
+		case models.DeliverySent:
+			counts.Sent = count
+		case models.DeliveryFailed:
+			counts.Failed = count
+		case models.DeliveryBlocked:
+			counts.Blocked = count
+		}
+	}
+
+	return &counts, rows.Err()
+}
+
+// SetUserInactive marks a user who blocked the bot, so broadcasts skip them
+// until they write to the bot again.
+func (s *SqliteDb) SetUserInactive(userid int64, inactive bool, at time.Time) error {
+	inactiveAt := int64(0)
+
+	if inactive {
+		inactiveAt = at.Unix()
+	}
+
+	_, err := s.db.Exec("UPDATE users SET inactive_at = ? WHERE user_id = ?", inactiveAt, userid)
+	return err
+}
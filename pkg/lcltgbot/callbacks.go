@@ -0,0 +1,33 @@
+package lcltgbot
+
+import "time"
+
+func (s *SqliteDb) SaveCallbackPayload(payload string, at time.Time) (int64, error) {
+	result, err := s.db.Exec("INSERT INTO callback_payloads(payload, created_at) VALUES (?, ?)", payload, at.Unix())
+
+	if err != nil {
+		return 0, err
+	}
+
+	return result.LastInsertId()
+}
+
+func (s *SqliteDb) GetCallbackPayload(id int64) (string, error) {
+	var payload string
+
+	if err := s.db.QueryRow("SELECT payload FROM callback_payloads WHERE id = ?", id).Scan(&payload); err != nil {
+		return "", err
+	}
+
+	return payload, nil
+}
+
+func (s *SqliteDb) DeleteCallbackPayloads(before time.Time) (int64, error) {
+	result, err := s.db.Exec("DELETE FROM callback_payloads WHERE created_at < ?", before.Unix())
+
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
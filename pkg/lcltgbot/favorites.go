@@ -0,0 +1,65 @@
+package lcltgbot
+
+import (
+	"github.com/iokinai/lcltgbot/internal/lcltgbot/models"
+	"time"
+)
+
+func (s *SqliteDb) AddFavorite(userid int64, adid int64) error {
+	_, err := s.db.Exec("INSERT OR IGNORE INTO favorites(user_id, ad_id, created_at) VALUES (?, ?, ?)", userid, adid, time.Now().Unix())
+	return err
+}
+
+func (s *SqliteDb) RemoveFavorite(userid int64, adid int64) error {
+	_, err := s.db.Exec("DELETE FROM favorites WHERE user_id = ? AND ad_id = ?", userid, adid)
+	return err
+}
+
+func (s *SqliteDb) IsFavorite(userid int64, adid int64) (bool, error) {
+	var count int
+
+	err := s.db.QueryRow("SELECT COUNT(*) FROM favorites WHERE user_id = ? AND ad_id = ?", userid, adid).Scan(&count)
+
+	return count > 0, err
+}
+
+// GetFavorites returns a page of saved ads, newest first. Sold and withdrawn
+// ads stay in the list with their status, so the user sees them as unavailable.
+func (s *SqliteDb) GetFavorites(userid int64, offset int, limit int) ([]*models.Advertisement, error) {
+	return s.QueryAds(
+		"SELECT "+prefixedAdColumns("ads")+" FROM favorites JOIN ads ON ads.id = favorites.ad_id WHERE favorites.user_id = ? ORDER BY favorites.created_at DESC, favorites.ad_id DESC LIMIT ? OFFSET ?",
+		userid, limit, offset,
+	)
+}
+
+func (s *SqliteDb) CountFavorites(userid int64) (int, error) {
+	var count int
+
+	err := s.db.QueryRow("SELECT COUNT(*) FROM favorites WHERE user_id = ?", userid).Scan(&count)
+
+	return count, err
+}
+
+func (s *SqliteDb) GetFavoriteWatchers(adid int64) ([]int64, error) {
+	rows, err := s.db.Query("SELECT user_id FROM favorites WHERE ad_id = ?", adid)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var users []int64
+
+	for rows.Next() {
+		var userid int64
+
+		if err := rows.Scan(&userid); err != nil {
+			return nil, err
+		}
+
+		users = append(users, userid)
+	}
+
+	return users, rows.Err()
+}
@@ -0,0 +1,61 @@
+package lcltgbot
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/iokinai/lcltgbot/internal/lcltgbot/models"
+	"time"
+)
+
+func (s *SqliteDb) CreateInvite(code string, createdBy int64, maxUses int) error {
+	_, err := s.db.Exec("INSERT INTO invites(code, created_by, max_uses, created_at) VALUES (?, ?, ?, ?)", code, createdBy, maxUses, time.Now().Unix())
+	return err
+}
+
+// UseInvite spends one use of the invite and returns nil when the code is
+// unknown or used up. Zero max_uses means unlimited.
+func (s *SqliteDb) UseInvite(code string) (*models.Invite, error) {
+	result, err := s.db.Exec("UPDATE invites SET uses = uses + 1 WHERE code = ? AND (max_uses = 0 OR uses < max_uses)", code)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if affected, err := result.RowsAffected(); err != nil || affected == 0 {
+		return nil, err
+	}
+
+	var (
+		invite    models.Invite
+		createdAt int64
+	)
+
+	err = s.db.QueryRow("SELECT code, created_by, max_uses, uses, created_at FROM invites WHERE code = ?", code).Scan(&invite.Code, &invite.CreatedBy, &invite.MaxUses, &invite.Uses, &createdAt)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	invite.CreatedAt = UnixOrZero(createdAt)
+
+	return &invite, nil
+}
+
+// SaveReferral keeps only the first referrer of a user.
+func (s *SqliteDb) SaveReferral(userid int64, referrerid int64) error {
+	_, err := s.db.Exec("INSERT OR IGNORE INTO referrals(user_id, referrer_id, created_at) VALUES (?, ?, ?)", userid, referrerid, time.Now().Unix())
+	return err
+}
+
+// CountReferrals counts only referred users who actually registered.
+func (s *SqliteDb) CountReferrals(referrerid int64) (int, error) {
+	var count int
+
+	err := s.db.QueryRow("SELECT COUNT(*) FROM referrals JOIN users ON users.user_id = referrals.user_id WHERE referrals.referrer_id = ?", referrerid).Scan(&count)
+
+	return count, err
+}
@@ -0,0 +1,96 @@
+package lcltgbot
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/iokinai/lcltgbot/internal/lcltgbot/models"
+	"time"
+)
+
+const relayColumns = "id, ad_id, buyer_id, seller_id, status, created_at, ended_at"
+
+func ScanRelay(row *sql.Row) (*models.Relay, error) {
+	var (
+		relay     models.Relay
+		createdAt int64
+		endedAt   int64
+	)
+
+	if err := row.Scan(&relay.Id, &relay.AdId, &relay.BuyerId, &relay.SellerId, &relay.Status, &createdAt, &endedAt); err != nil {
+		return nil, err
+	}
+
+	relay.CreatedAt = UnixOrZero(createdAt)
+	relay.EndedAt = UnixOrZero(endedAt)
+
+	return &relay, nil
+}
+
+// OpenRelay returns the buyer's active conversation about the ad or starts a
+// new one.
+func (s *SqliteDb) OpenRelay(ad *models.Advertisement, buyerid int64) (*models.Relay, error) {
+	relay, err := ScanRelay(s.db.QueryRow(
+		"SELECT "+relayColumns+" FROM relays WHERE ad_id = ? AND buyer_id = ? AND status = ?",
+		ad.Id, buyerid, models.RelayActive,
+	))
+
+	if err == nil {
+		return relay, nil
+	}
+
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	now := time.Now()
+
+	result, err := s.db.Exec(
+		"INSERT INTO relays(ad_id, buyer_id, seller_id, status, created_at) VALUES (?, ?, ?, ?, ?)",
+		ad.Id, buyerid, ad.OwnerId, models.RelayActive, now.Unix(),
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := result.LastInsertId()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.Relay{Id: id, AdId: ad.Id, BuyerId: buyerid, SellerId: ad.OwnerId, Status: models.RelayActive, CreatedAt: now}, nil
+}
+
+func (s *SqliteDb) GetRelay(id int64) (*models.Relay, error) {
+	return ScanRelay(s.db.QueryRow("SELECT "+relayColumns+" FROM relays WHERE id = ?", id))
+}
+
+func (s *SqliteDb) CloseRelay(relay *models.Relay, status models.RelayStatus, endedAt time.Time) error {
+	if _, err := s.db.Exec("UPDATE relays SET status = ?, ended_at = ? WHERE id = ?", status, endedAt.Unix(), relay.Id); err != nil {
+		return err
+	}
+
+	relay.Status = status
+	relay.EndedAt = endedAt
+
+	return nil
+}
+
+func (s *SqliteDb) IsRelayBlocked(sellerid int64, buyerid int64) (bool, error) {
+	var count int
+
+	err := s.db.QueryRow("SELECT COUNT(*) FROM relays WHERE seller_id = ? AND buyer_id = ? AND status = ?", sellerid, buyerid, models.RelayBlocked).Scan(&count)
+
+	return count > 0, err
+}
+
+func (s *SqliteDb) ChangeActiveRelay(user *models.User, relayid int64) (*models.User, error) {
+	if _, err := s.db.Exec("UPDATE temp_contexts SET relay_id = ? WHERE id = ?", relayid, user.Context.Id); err != nil {
+		return nil, err
+	}
+
+	user.Context.RelayId = relayid
+
+	return user, nil
+}
@@ -0,0 +1,89 @@
+package lcltgbot
+
+import (
+	"github.com/iokinai/lcltgbot/internal/lcltgbot/models"
+	"time"
+)
+
+// SaveReport stores the complaint unless the user has already reported this
+// ad, the returned flag tells whether it was new.
+func (s *SqliteDb) SaveReport(report *models.Report) (bool, error) {
+	result, err := s.db.Exec(
+		"INSERT OR IGNORE INTO reports(ad_id, reporter_id, reason, comment, created_at) VALUES (?, ?, ?, ?, ?)",
+		report.AdId, report.ReporterId, report.Reason, report.Comment, report.CreatedAt.Unix(),
+	)
+
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+
+	if err != nil {
+		return false, err
+	}
+
+	if affected == 0 {
+		return false, nil
+	}
+
+	if report.Id, err = result.LastInsertId(); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (s *SqliteDb) HasReported(adid int64, reporterid int64) (bool, error) {
+	var count int
+
+	err := s.db.QueryRow("SELECT COUNT(*) FROM reports WHERE ad_id = ? AND reporter_id = ?", adid, reporterid).Scan(&count)
+
+	return count > 0, err
+}
+
+func (s *SqliteDb) CountReportsSince(reporterid int64, since time.Time) (int, error) {
+	var count int
+
+	err := s.db.QueryRow("SELECT COUNT(*) FROM reports WHERE reporter_id = ? AND created_at >= ?", reporterid, since.Unix()).Scan(&count)
+
+	return count, err
+}
+
+func (s *SqliteDb) GetOpenReports(adid int64) ([]*models.Report, error) {
+	rows, err := s.db.Query("SELECT id, ad_id, reporter_id, reason, comment, created_at FROM reports WHERE ad_id = ? AND resolved_at = 0 ORDER BY id", adid)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var reports []*models.Report
+
+	for rows.Next() {
+		var (
+			report    models.Report
+			createdAt int64
+		)
+
+		if err := rows.Scan(&report.Id, &report.AdId, &report.ReporterId, &report.Reason, &report.Comment, &createdAt); err != nil {
+			return nil, err
+		}
+
+		report.CreatedAt = UnixOrZero(createdAt)
+		reports = append(reports, &report)
+	}
+
+	return reports, rows.Err()
+}
+
+func (s *SqliteDb) ResolveReports(adid int64, resolvedAt time.Time) error {
+	_, err := s.db.Exec("UPDATE reports SET resolved_at = ? WHERE ad_id = ? AND resolved_at = 0", resolvedAt.Unix(), adid)
+	return err
+}
+
+func (s *SqliteDb) BanUser(userid int64, bannedAt time.Time) error {
+	_, err := s.db.Exec("UPDATE users SET banned_at = ? WHERE user_id = ?", bannedAt.Unix(), userid)
+	return err
+}
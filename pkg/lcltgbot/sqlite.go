@@ -2,6 +2,7 @@ package lcltgbot
 
 import (
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/iokinai/lcltgbot/internal/lcltgbot/models"
@@ -14,6 +15,90 @@ type SqliteDb struct {
 	settings *models.AppSettings
 }
 
+var migrations = []string{
+	`CREATE TABLE IF NOT EXISTS temp_ads (id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT, title VARCHAR(255), description TEXT, price DOUBLE, city TEXT, editing BOOLEAN);
+	CREATE TABLE IF NOT EXISTS temp_contexts (id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT, is_in_flow INTEGER, ad_id INTEGER, state INTEGER, FOREIGN KEY(ad_id) REFERENCES temp_ads(id));
+	CREATE TABLE IF NOT EXISTS users (id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT, chat_id INTEGER UNIQUE, username TEXT UNIQUE, context_id INTEGER, FOREIGN KEY(context_id) REFERENCES temp_contexts(id))`,
+
+	`CREATE TABLE users_v2 (id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT, user_id INTEGER NOT NULL UNIQUE, chat_id INTEGER, username TEXT NOT NULL DEFAULT '', first_name TEXT NOT NULL DEFAULT '', context_id INTEGER, FOREIGN KEY(context_id) REFERENCES temp_contexts(id));
+	INSERT INTO users_v2(id, user_id, chat_id, username, context_id) SELECT id, chat_id, chat_id, IFNULL(username, ''), context_id FROM users;
+	DROP TABLE users;
+	ALTER TABLE users_v2 RENAME TO users`,
+
+	`CREATE TABLE chat_contexts (chat_id INTEGER NOT NULL, user_id INTEGER NOT NULL, thread_id INTEGER NOT NULL DEFAULT 0, context_id INTEGER NOT NULL, PRIMARY KEY(chat_id, user_id, thread_id), FOREIGN KEY(context_id) REFERENCES temp_contexts(id));
+	INSERT INTO chat_contexts(chat_id, user_id, thread_id, context_id) SELECT chat_id, user_id, 0, context_id FROM users WHERE context_id IS NOT NULL;
+	CREATE TABLE groups (chat_id INTEGER NOT NULL PRIMARY KEY, title TEXT NOT NULL DEFAULT '', allowed INTEGER NOT NULL DEFAULT 0)`,
+
+	`CREATE TABLE ads (id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT, user_id INTEGER NOT NULL, title VARCHAR(255), description TEXT, price DOUBLE, city TEXT, status INTEGER NOT NULL, publish_at INTEGER NOT NULL DEFAULT 0, published_at INTEGER NOT NULL DEFAULT 0, channel_chat_id INTEGER NOT NULL DEFAULT 0, channel_message_id INTEGER NOT NULL DEFAULT 0, created_at INTEGER NOT NULL);
+	CREATE INDEX ads_status_publish_at ON ads(status, publish_at);
+	CREATE INDEX ads_user_id ON ads(user_id)`,
+
+	`ALTER TABLE ads ADD COLUMN expires_at INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE ads ADD COLUMN reminded_at INTEGER NOT NULL DEFAULT 0;
+	CREATE INDEX ads_status_expires_at ON ads(status, expires_at)`,
+
+	`ALTER TABLE ads ADD COLUMN sold_at INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE ads ADD COLUMN sold_price DOUBLE;
+	ALTER TABLE temp_contexts ADD COLUMN target_ad_id INTEGER NOT NULL DEFAULT 0`,
+
+	`ALTER TABLE temp_ads ADD COLUMN title_entities TEXT NOT NULL DEFAULT '';
+	ALTER TABLE temp_ads ADD COLUMN description_entities TEXT NOT NULL DEFAULT '';
+	ALTER TABLE ads ADD COLUMN title_entities TEXT NOT NULL DEFAULT '';
+	ALTER TABLE ads ADD COLUMN description_entities TEXT NOT NULL DEFAULT ''`,
+
+	`ALTER TABLE temp_ads ADD COLUMN truncated BOOLEAN NOT NULL DEFAULT 0;
+	ALTER TABLE ads ADD COLUMN truncated BOOLEAN NOT NULL DEFAULT 0`,
+
+	`ALTER TABLE temp_ads ADD COLUMN currency TEXT NOT NULL DEFAULT '';
+	ALTER TABLE temp_ads ADD COLUMN price_type INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE ads ADD COLUMN currency TEXT NOT NULL DEFAULT '';
+	ALTER TABLE ads ADD COLUMN price_type INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE users ADD COLUMN language TEXT NOT NULL DEFAULT ''`,
+
+	`ALTER TABLE temp_ads ADD COLUMN city_id TEXT NOT NULL DEFAULT '';
+	ALTER TABLE temp_ads ADD COLUMN latitude DOUBLE;
+	ALTER TABLE temp_ads ADD COLUMN longitude DOUBLE;
+	ALTER TABLE ads ADD COLUMN city_id TEXT NOT NULL DEFAULT '';
+	ALTER TABLE ads ADD COLUMN latitude DOUBLE;
+	ALTER TABLE ads ADD COLUMN longitude DOUBLE;
+	CREATE INDEX ads_city_id ON ads(city_id)`,
+
+	`CREATE INDEX ads_location ON ads(status, latitude, longitude)`,
+
+	`CREATE TABLE relays (id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT, ad_id INTEGER NOT NULL, buyer_id INTEGER NOT NULL, seller_id INTEGER NOT NULL, status INTEGER NOT NULL, created_at INTEGER NOT NULL, ended_at INTEGER NOT NULL DEFAULT 0, FOREIGN KEY(ad_id) REFERENCES ads(id));
+	CREATE INDEX relays_pair ON relays(seller_id, buyer_id, status);
+	ALTER TABLE temp_contexts ADD COLUMN relay_id INTEGER NOT NULL DEFAULT 0`,
+
+	`CREATE TABLE invites (code TEXT NOT NULL PRIMARY KEY, created_by INTEGER NOT NULL, max_uses INTEGER NOT NULL DEFAULT 1, uses INTEGER NOT NULL DEFAULT 0, created_at INTEGER NOT NULL);
+	CREATE TABLE referrals (user_id INTEGER NOT NULL PRIMARY KEY, referrer_id INTEGER NOT NULL, created_at INTEGER NOT NULL);
+	CREATE INDEX referrals_referrer ON referrals(referrer_id)`,
+
+	`CREATE TABLE favorites (user_id INTEGER NOT NULL, ad_id INTEGER NOT NULL, created_at INTEGER NOT NULL, PRIMARY KEY(user_id, ad_id), FOREIGN KEY(ad_id) REFERENCES ads(id));
+	CREATE INDEX favorites_ad ON favorites(ad_id)`,
+
+	`CREATE TABLE reports (id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT, ad_id INTEGER NOT NULL, reporter_id INTEGER NOT NULL, reason TEXT NOT NULL, comment TEXT NOT NULL DEFAULT '', created_at INTEGER NOT NULL, resolved_at INTEGER NOT NULL DEFAULT 0, UNIQUE(ad_id, reporter_id), FOREIGN KEY(ad_id) REFERENCES ads(id));
+	CREATE INDEX reports_reporter ON reports(reporter_id, created_at);
+	ALTER TABLE users ADD COLUMN banned_at INTEGER NOT NULL DEFAULT 0`,
+
+	`ALTER TABLE temp_ads ADD COLUMN revision INTEGER NOT NULL DEFAULT 0`,
+
+	`CREATE TABLE callback_payloads (id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT, payload TEXT NOT NULL, created_at INTEGER NOT NULL)`,
+
+	`CREATE TABLE broadcasts (id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT, created_by INTEGER NOT NULL, from_chat_id INTEGER NOT NULL, from_message_id INTEGER NOT NULL, buttons TEXT NOT NULL DEFAULT '', status INTEGER NOT NULL, created_at INTEGER NOT NULL, started_at INTEGER NOT NULL DEFAULT 0, finished_at INTEGER NOT NULL DEFAULT 0);
+	CREATE TABLE broadcast_deliveries (broadcast_id INTEGER NOT NULL, user_id INTEGER NOT NULL, chat_id INTEGER NOT NULL, status INTEGER NOT NULL DEFAULT 0, error TEXT NOT NULL DEFAULT '', sent_at INTEGER NOT NULL DEFAULT 0, PRIMARY KEY(broadcast_id, user_id), FOREIGN KEY(broadcast_id) REFERENCES broadcasts(id));
+	CREATE INDEX broadcast_deliveries_status ON broadcast_deliveries(broadcast_id, status);
+	ALTER TABLE users ADD COLUMN inactive_at INTEGER NOT NULL DEFAULT 0`,
+
+	`CREATE INDEX callback_payloads_created_at ON callback_payloads(created_at)`,
+
+	`ALTER TABLE broadcast_deliveries ADD COLUMN attempts INTEGER NOT NULL DEFAULT 0`,
+
+	`ALTER TABLE temp_ads ADD COLUMN category TEXT NOT NULL DEFAULT '';
+	ALTER TABLE temp_ads ADD COLUMN lifetime_days INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE ads ADD COLUMN category TEXT NOT NULL DEFAULT '';
+	ALTER TABLE ads ADD COLUMN lifetime_days INTEGER NOT NULL DEFAULT 0`,
+}
+
 func NewSqliteDb(settings *models.AppSettings) *SqliteDb {
 	db, err := sql.Open("sqlite3", settings.DatabasePath)
 
@@ -21,20 +106,48 @@ func NewSqliteDb(settings *models.AppSettings) *SqliteDb {
 		log.Fatal(err)
 	}
 
-	CreateTableOrError(db, "temp_ads (id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT, title VARCHAR(255), description TEXT, price DOUBLE, city TEXT, editing BOOLEAN)")
-	CreateTableOrError(db, "temp_contexts (id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT, is_in_flow INTEGER, ad_id INTEGER, state INTEGER, FOREIGN KEY(ad_id) REFERENCES temp_ads(id))")
-	CreateTableOrError(db, "users (id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT, chat_id INTEGER UNIQUE, username TEXT UNIQUE, context_id INTEGER, FOREIGN KEY(context_id) REFERENCES temp_contexts(id))")
+	db.SetMaxOpenConns(1)
+
+	if err := Migrate(db); err != nil {
+		log.Fatal(err)
+	}
 
 	return &SqliteDb{db: db, settings: settings}
 }
 
-func CreateTableOrError(db *sql.DB, data string) {
-	if _, err := db.Exec(fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s", data)); err != nil {
-		log.Fatal(err)
+func Migrate(db *sql.DB) error {
+	var version int
+
+	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return err
 	}
+
+	for ; version < len(migrations); version++ {
+		tx, err := db.Begin()
+
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec(migrations[version]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d: %w", version+1, err)
+		}
+
+		if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", version+1)); err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
-func (s *SqliteDb) Register(chatid int64, username string) (*models.User, error) {
+func (s *SqliteDb) Register(userid int64, chatid int64, username string, firstname string) (*models.User, error) {
 	emptyad, err := s.CreateAd("", "", 0, "")
 
 	if err != nil {
@@ -47,13 +160,17 @@ func (s *SqliteDb) Register(chatid int64, username string) (*models.User, error)
 		return nil, err
 	}
 
-	_, err = s.db.Exec("INSERT INTO users(chat_id, username, context_id) VALUES (?, ?, ?)", chatid, username, emptyctx.Id)
+	_, err = s.db.Exec("INSERT INTO users(user_id, chat_id, username, first_name, context_id) VALUES (?, ?, ?, ?, ?)", userid, chatid, username, firstname, emptyctx.Id)
 
 	if err != nil {
 		return nil, err
 	}
 
-	return models.NewUser(chatid, username, emptyctx), nil
+	if err := s.BindContext(models.NewChatKey(chatid, userid, 0), emptyctx); err != nil {
+		return nil, err
+	}
+
+	return models.NewUser(userid, chatid, username, firstname, emptyctx), nil
 }
 
 func (s *SqliteDb) CreateContext(isInFlow bool, ad *models.Advertisement, state models.BotState) (*models.BotContext, error) {
@@ -88,8 +205,8 @@ func (s *SqliteDb) CreateAd(title string, description string, price float64, cit
 	return models.NewAdvertisement(id, title, description, price, city, false), nil
 }
 
-func (s *SqliteDb) GetUser(chatid int64) (*models.User, error) {
-	userrows, err := s.GetRowsById("SELECT * FROM users WHERE chat_id = ?", chatid)
+func (s *SqliteDb) GetUser(key models.ChatKey) (*models.User, error) {
+	userrows, err := s.GetRowsById("SELECT username, first_name, language, banned_at, inactive_at FROM users WHERE user_id = ?", key.UserId)
 
 	loaded := false
 
@@ -97,31 +214,96 @@ func (s *SqliteDb) GetUser(chatid int64) (*models.User, error) {
 		return nil, err
 	}
 
+	defer userrows.Close()
+
 	var (
-		userId     int
-		chatId     int
-		username   string
-		ucontextId sql.NullInt64
+		username  string
+		firstname string
+		language  string
+		bannedAt  int64
 	)
 
+	var inactiveAt int64
+
 	for userrows.Next() {
-		if err := userrows.Scan(&userId, &chatId, &username, &ucontextId); err != nil {
+		if err := userrows.Scan(&username, &firstname, &language, &bannedAt, &inactiveAt); err != nil {
 			return nil, err
 		}
 		loaded = true
 	}
 
 	if !loaded {
-		return nil, errors.New("no values in DB")
+		return nil, models.ErrUserNotFound
 	}
 
-	context, err := s.GetContext(ucontextId)
+	context, err := s.GetChatContext(key)
 
 	if err != nil {
 		return nil, err
 	}
 
-	return models.NewUser(chatid, username, context), nil
+	user := models.NewUser(key.UserId, key.ChatId, username, firstname, context)
+	user.Language = language
+	user.Banned = bannedAt > 0
+	user.Inactive = inactiveAt > 0
+
+	return user, nil
+}
+
+func (s *SqliteDb) GetChatContext(key models.ChatKey) (*models.BotContext, error) {
+	var contextId sql.NullInt64
+
+	err := s.db.QueryRow("SELECT context_id FROM chat_contexts WHERE chat_id = ? AND user_id = ? AND thread_id = ?", key.ChatId, key.UserId, key.ThreadId).Scan(&contextId)
+
+	if err == nil {
+		return s.GetContext(contextId)
+	}
+
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	emptyad, err := s.CreateAd("", "", 0, "")
+
+	if err != nil {
+		return nil, err
+	}
+
+	context, err := s.CreateContext(false, emptyad, models.StateNONE)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.BindContext(key, context); err != nil {
+		return nil, err
+	}
+
+	return context, nil
+}
+
+func (s *SqliteDb) BindContext(key models.ChatKey, context *models.BotContext) error {
+	_, err := s.db.Exec("INSERT OR REPLACE INTO chat_contexts(chat_id, user_id, thread_id, context_id) VALUES (?, ?, ?, ?)", key.ChatId, key.UserId, key.ThreadId, context.Id)
+
+	return err
+}
+
+func (s *SqliteDb) UpdateUserProfile(user *models.User, username string, firstname string, language string) (*models.User, error) {
+	if user.Username == username && user.FirstName == firstname && user.Language == language {
+		return user, nil
+	}
+
+	_, err := s.db.Exec("UPDATE users SET username = ?, first_name = ?, language = ? WHERE user_id = ?", username, firstname, language, user.Id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	user.Username = username
+	user.FirstName = firstname
+	user.Language = language
+
+	return user, nil
 }
 
 func (s *SqliteDb) GetAd(id sql.NullInt64) (*models.Advertisement, error) {
@@ -138,16 +320,29 @@ func (s *SqliteDb) GetAd(id sql.NullInt64) (*models.Advertisement, error) {
 		price       float64
 		city        string
 		editing     bool
+		titleEnt    string
+		descrEnt    string
+		truncated   bool
+		currency    string
+		priceType   models.PriceType
+		cityId      string
+		latitude    sql.NullFloat64
+		longitude   sql.NullFloat64
+		revision    int64
+		category    string
+		lifetime    int
 	)
 
-	adrows, err := s.GetRowsById("SELECT * FROM temp_ads WHERE id = ?", id.Int64)
+	adrows, err := s.GetRowsById("SELECT id, title, description, price, city, editing, title_entities, description_entities, truncated, currency, price_type, city_id, latitude, longitude, revision, category, lifetime_days FROM temp_ads WHERE id = ?", id.Int64)
 
 	if err != nil {
 		return nil, err
 	}
 
+	defer adrows.Close()
+
 	for adrows.Next() {
-		if err := adrows.Scan(&adId, &title, &description, &price, &city, &editing); err != nil {
+		if err := adrows.Scan(&adId, &title, &description, &price, &city, &editing, &titleEnt, &descrEnt, &truncated, &currency, &priceType, &cityId, &latitude, &longitude, &revision, &category, &lifetime); err != nil {
 			return nil, err
 		}
 		loaded = true
@@ -157,7 +352,19 @@ func (s *SqliteDb) GetAd(id sql.NullInt64) (*models.Advertisement, error) {
 		return nil, errors.New("no values in DB")
 	}
 
-	return models.NewAdvertisement(adId, title, description, price, city, editing), nil
+	ad := models.NewAdvertisement(adId, title, description, price, city, editing)
+	ad.TitleEntities = DecodeEntities(titleEnt)
+	ad.DescriptionEntities = DecodeEntities(descrEnt)
+	ad.Truncated = truncated
+	ad.Currency = currency
+	ad.PriceType = priceType
+	ad.CityId = cityId
+	ad.Location = LocationOrNil(latitude, longitude)
+	ad.Revision = revision
+	ad.Category = category
+	ad.LifetimeDays = lifetime
+
+	return ad, nil
 }
 
 func (s *SqliteDb) GetContext(id sql.NullInt64) (*models.BotContext, error) {
@@ -172,16 +379,20 @@ func (s *SqliteDb) GetContext(id sql.NullInt64) (*models.BotContext, error) {
 		isInFlow  bool
 		uadId     sql.NullInt64
 		state     int
+		targetAd  int64
+		relayId   int64
 	)
 
-	contextrows, err := s.GetRowsById("SELECT * FROM temp_contexts WHERE id = ?", id.Int64)
+	contextrows, err := s.GetRowsById("SELECT id, is_in_flow, ad_id, state, target_ad_id, relay_id FROM temp_contexts WHERE id = ?", id.Int64)
 
 	if err != nil {
 		return nil, err
 	}
 
+	defer contextrows.Close()
+
 	for contextrows.Next() {
-		if err := contextrows.Scan(&contextId, &isInFlow, &uadId, &state); err != nil {
+		if err := contextrows.Scan(&contextId, &isInFlow, &uadId, &state, &targetAd, &relayId); err != nil {
 			return nil, err
 		}
 		loaded = true
@@ -202,6 +413,8 @@ func (s *SqliteDb) GetContext(id sql.NullInt64) (*models.BotContext, error) {
 		IsInFlow:      isInFlow,
 		Advertisement: ad,
 		State:         models.BotState(state),
+		TargetAdId:    targetAd,
+		RelayId:       relayId,
 	}, nil
 }
 
@@ -233,13 +446,35 @@ func (s *SqliteDb) ChangeUserState(user *models.User, state models.BotState) (*m
 	return user, nil
 }
 
-func (s *SqliteDb) ChangeAdTitle(user *models.User, title string) (*models.User, error) {
+func (s *SqliteDb) ChangeTargetAd(user *models.User, adid int64) (*models.User, error) {
+	if _, err := s.db.Exec("UPDATE temp_contexts SET target_ad_id = ? WHERE id = ?", adid, user.Context.Id); err != nil {
+		return nil, err
+	}
+
+	user.Context.TargetAdId = adid
+
+	return user, nil
+}
+
+func (s *SqliteDb) ChangeAdTitle(user *models.User, title string, entities []models.Entity) (*models.User, error) {
 	user.Context.Advertisement.Title = title
+	user.Context.Advertisement.TitleEntities = entities
+
+	if err := s.ChangeAdParam(user, EncodeEntities(entities), "title_entities"); err != nil {
+		return nil, err
+	}
+
 	return user, s.ChangeAdParam(user, title, "title")
 }
 
-func (s *SqliteDb) ChangeAdDescription(user *models.User, descr string) (*models.User, error) {
+func (s *SqliteDb) ChangeAdDescription(user *models.User, descr string, entities []models.Entity) (*models.User, error) {
 	user.Context.Advertisement.Description = descr
+	user.Context.Advertisement.DescriptionEntities = entities
+
+	if err := s.ChangeAdParam(user, EncodeEntities(entities), "description_entities"); err != nil {
+		return nil, err
+	}
+
 	return user, s.ChangeAdParam(user, descr, "description")
 }
 
@@ -248,22 +483,122 @@ func (s *SqliteDb) ChangeAdPrice(user *models.User, price float64) (*models.User
 	return user, s.ChangeAdParam(user, price, "price")
 }
 
+func (s *SqliteDb) ChangeAdCurrency(user *models.User, currency string) (*models.User, error) {
+	user.Context.Advertisement.Currency = currency
+	return user, s.ChangeAdParam(user, currency, "currency")
+}
+
+func (s *SqliteDb) ChangeAdPriceType(user *models.User, priceType models.PriceType) (*models.User, error) {
+	user.Context.Advertisement.PriceType = priceType
+	return user, s.ChangeAdParam(user, priceType, "price_type")
+}
+
+func (s *SqliteDb) ChangeAdCategory(user *models.User, category string) (*models.User, error) {
+	user.Context.Advertisement.Category = category
+	return user, s.ChangeAdParam(user, category, "category")
+}
+
+func (s *SqliteDb) ChangeAdLifetime(user *models.User, days int) (*models.User, error) {
+	user.Context.Advertisement.LifetimeDays = days
+	return user, s.ChangeAdParam(user, days, "lifetime_days")
+}
+
 func (s *SqliteDb) ChangeAdCity(user *models.User, city string) (*models.User, error) {
 	user.Context.Advertisement.City = city
 	return user, s.ChangeAdParam(user, city, "city")
 }
 
+func (s *SqliteDb) ChangeAdPlace(user *models.User, city string, cityid string, location *models.Location) (*models.User, error) {
+	latitude, longitude := NullLocation(location)
+
+	if _, err := s.db.Exec("UPDATE temp_ads SET city = ?, city_id = ?, latitude = ?, longitude = ?, revision = revision + 1 WHERE id = ?", city, cityid, latitude, longitude, user.Context.Advertisement.Id); err != nil {
+		return nil, err
+	}
+
+	user.Context.Advertisement.Revision++
+
+	user.Context.Advertisement.City = city
+	user.Context.Advertisement.CityId = cityid
+	user.Context.Advertisement.Location = location
+
+	return user, nil
+}
+
 func (s *SqliteDb) ChangeAdEditing(user *models.User, editing bool) (*models.User, error) {
 	user.Context.Advertisement.Editing = editing
-	return user, s.ChangeAdParam(user, editing, "editing")
+
+	if _, err := s.db.Exec("UPDATE temp_ads SET editing = ? WHERE id = ?", editing, user.Context.Advertisement.Id); err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+func (s *SqliteDb) ChangeAdTruncated(user *models.User, truncated bool) (*models.User, error) {
+	user.Context.Advertisement.Truncated = truncated
+	return user, s.ChangeAdParam(user, truncated, "truncated")
 }
 
+// ChangeAdParam also bumps the draft revision, so buttons of previews sent
+// before the change no longer match it.
 func (s *SqliteDb) ChangeAdParam(user *models.User, param any, paramname string) error {
-	_, err := s.db.Exec(fmt.Sprintf("UPDATE temp_ads SET %v = ? WHERE id = ?", paramname), param, user.Context.Advertisement.Id)
+	_, err := s.db.Exec(fmt.Sprintf("UPDATE temp_ads SET %v = ?, revision = revision + 1 WHERE id = ?", paramname), param, user.Context.Advertisement.Id)
 
 	if err != nil {
 		return err
 	}
 
+	user.Context.Advertisement.Revision++
+
 	return nil
 }
+
+func (s *SqliteDb) IsGroupAllowed(chatid int64) (bool, error) {
+	var allowed bool
+
+	err := s.db.QueryRow("SELECT allowed FROM groups WHERE chat_id = ?", chatid).Scan(&allowed)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	return allowed, nil
+}
+
+func (s *SqliteDb) SetGroupAllowed(chatid int64, title string, allowed bool) error {
+	_, err := s.db.Exec("INSERT INTO groups(chat_id, title, allowed) VALUES (?, ?, ?) ON CONFLICT(chat_id) DO UPDATE SET title = excluded.title, allowed = excluded.allowed", chatid, title, allowed)
+
+	return err
+}
+
+func EncodeEntities(entities []models.Entity) string {
+	if len(entities) == 0 {
+		return ""
+	}
+
+	data, err := json.Marshal(entities)
+
+	if err != nil {
+		return ""
+	}
+
+	return string(data)
+}
+
+func DecodeEntities(data string) []models.Entity {
+	if data == "" {
+		return nil
+	}
+
+	var entities []models.Entity
+
+	if err := json.Unmarshal([]byte(data), &entities); err != nil {
+		return nil
+	}
+
+	return entities
+}